@@ -0,0 +1,170 @@
+// Package auth hashes passwords and issues and checks the tokens API callers
+// sign in with: short lived JWT access tokens and long lived opaque refresh
+// tokens.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidToken means an access token is malformed, badly signed or expired
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// minSecretLength is the shortest JWT_SECRET accepted, in bytes
+const minSecretLength = 32
+
+// Config holds the signing key and token lifetimes
+type Config struct {
+	Secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// DefaultConfig returns 15 minute access tokens and 30 day refresh tokens
+// signed with a random key, so tokens do not outlive the process
+func DefaultConfig() Config {
+	secret := make([]byte, minSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return Config{
+		Secret:     secret,
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads JWT_SECRET (at least 32 bytes), ACCESS_TOKEN_TTL and
+// REFRESH_TOKEN_TTL (durations such as "15m"), falling back to DefaultConfig.
+// The returned bool reports whether the secret came from the environment.
+func ConfigFromEnv() (Config, bool, error) {
+	cfg := DefaultConfig()
+	var err error
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret != "" {
+		if len(secret) < minSecretLength {
+			return cfg, false, fmt.Errorf("JWT_SECRET: must be at least %d bytes", minSecretLength)
+		}
+		cfg.Secret = []byte(secret)
+	}
+	if v := os.Getenv("ACCESS_TOKEN_TTL"); v != "" {
+		if cfg.AccessTTL, err = time.ParseDuration(v); err != nil {
+			return cfg, false, fmt.Errorf("ACCESS_TOKEN_TTL: %v", err)
+		}
+	}
+	if v := os.Getenv("REFRESH_TOKEN_TTL"); v != "" {
+		if cfg.RefreshTTL, err = time.ParseDuration(v); err != nil {
+			return cfg, false, fmt.Errorf("REFRESH_TOKEN_TTL: %v", err)
+		}
+	}
+
+	return cfg, secret != "", nil
+}
+
+// HashPassword returns the bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// dummyHash is compared against when there is no user, so a login for an
+// unknown name takes as long as one with a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// CheckPassword reports whether password matches hash. An empty hash, for a
+// user that does not exist, never matches but costs the same time.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Claims is what an access token says about its bearer
+type Claims struct {
+	UserID    int64
+	ID        string
+	ExpiresAt time.Time
+}
+
+// NewAccessToken signs an access token for a user, returning it and its claims
+func (c Config) NewAccessToken(userID int64) (string, Claims, error) {
+	now := time.Now()
+	claims := Claims{UserID: userID, ID: randomString(16), ExpiresAt: now.Add(c.AccessTTL).Truncate(time.Second)}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   strconv.FormatInt(userID, 10),
+		ID:        claims.ID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(claims.ExpiresAt),
+	})
+	signed, err := token.SignedString(c.Secret)
+	return signed, claims, err
+}
+
+// ParseAccessToken checks an access token's signature and expiry and returns
+// its claims, or ErrInvalidToken
+func (c Config) ParseAccessToken(token string) (Claims, error) {
+	var rc jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &rc, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return c.Secret, nil
+	})
+	if err != nil || rc.ExpiresAt == nil || rc.ID == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	userID, err := strconv.ParseInt(rc.Subject, 10, 64)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	return Claims{UserID: userID, ID: rc.ID, ExpiresAt: rc.ExpiresAt.Time}, nil
+}
+
+// NewRefreshToken returns a random refresh token and the hash to store for it;
+// only the hash is kept, so a leaked database does not leak live tokens
+func NewRefreshToken() (token, hash string) {
+	token = randomString(32)
+	return token, HashToken(token)
+}
+
+// apiKeyTag starts every api key so a leaked one is easy to recognise and
+// secret scanners can look for it
+const apiKeyTag = "bk_"
+
+// NewAPIKey returns a random api key, the prefix it can be shown by and the
+// hash to store for it. The prefix is the start of the key, up to the dot.
+func NewAPIKey() (key, prefix, hash string) {
+	prefix = apiKeyTag + randomString(6)
+	key = prefix + "." + randomString(32)
+	return key, prefix, HashToken(key)
+}
+
+// HashToken returns the hex sha256 of an opaque token or api key. They are random, so
+// unlike passwords they need no salt or slow hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes encoded for use in urls and headers
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, "correct horse") {
+		t.Errorf("hash contains the password: %q", hash)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Errorf("CheckPassword rejected the right password")
+	}
+	if CheckPassword(hash, "battery staple") || CheckPassword("", "correct horse") {
+		t.Errorf("CheckPassword accepted a wrong password or a missing user")
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	cfg := DefaultConfig()
+	token, claims, err := cfg.NewAccessToken(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.ParseAccessToken(token)
+	if err != nil || got.UserID != 42 || got.ID != claims.ID || !got.ExpiresAt.Equal(claims.ExpiresAt) {
+		t.Errorf("ParseAccessToken = %+v, %v; want %+v", got, err, claims)
+	}
+
+	for name, bad := range map[string]string{
+		"tampered":    token[:len(token)-2] + "xx",
+		"other key":   mustToken(t, DefaultConfig(), 42),
+		"expired":     mustToken(t, Config{Secret: cfg.Secret, AccessTTL: -time.Minute}, 42),
+		"not a token": "abc",
+	} {
+		if _, err := cfg.ParseAccessToken(bad); err != ErrInvalidToken {
+			t.Errorf("%s token: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	a, hashA := NewRefreshToken()
+	b, _ := NewRefreshToken()
+	if a == b || HashToken(a) != hashA || hashA == a {
+		t.Errorf("refresh tokens are not random or not hashed: %q %q %q", a, b, hashA)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	key, prefix, hash := NewAPIKey()
+	other, otherPrefix, _ := NewAPIKey()
+	if !strings.HasPrefix(key, prefix+".") || !strings.HasPrefix(prefix, "bk_") {
+		t.Errorf("api key %q does not start with its prefix %q", key, prefix)
+	}
+	if key == other || prefix == otherPrefix || HashToken(key) != hash {
+		t.Errorf("api keys are not random or not hashed: %q %q %q", key, other, hash)
+	}
+}
+
+func mustToken(t *testing.T, cfg Config, userID int64) string {
+	token, _, err := cfg.NewAccessToken(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
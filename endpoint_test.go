@@ -2,18 +2,73 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"go-postgres/middleware"
+	"go-postgres/models"
+	"go-postgres/router"
+	"go-postgres/store"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// token is the access token of the test user, sent by serve on every request
+var token string
+
+// serve runs req through the router as the test user, unless req already
+// carries its own Authorization header
+func serve(rr *httptest.ResponseRecorder, req *http.Request) {
+	if token != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.Router().ServeHTTP(rr, req)
+}
+
+// setenv sets an environment variable for the rest of the test and puts back
+// what was there before when it ends, like t.Setenv does from go 1.17
+func setenv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
 func TestSetUp(t *testing.T) {
 	//Deletes all entries in book table and resets primary key sequence
 	middleware.PrepForTesting()
+	//accounts that register themselves are readers, even the first one
+	token = ""
+	var early models.User
+	json.Unmarshal(doRequest(t, "POST", "/api/auth/register", `{"Username":"earlybird","Password":"correct horse"}`, http.StatusCreated), &early)
+	if early.Role != store.RoleReader {
+		t.Errorf("first registered user has role %q, want reader", early.Role)
+	}
+	//set up the admin the way ADMIN_USERNAME does and sign in as it, so the
+	//tests can make changes through the router
+	if _, err := middleware.EnsureAdmin("librarian", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	var tokens struct{ AccessToken string }
+	json.Unmarshal(doRequest(t, "POST", "/api/auth/login", `{"Username":"librarian","Password":"correct horse"}`, http.StatusOK), &tokens)
+	if tokens.AccessToken == "" {
+		t.Fatal("login did not return an access token")
+	}
+	token = tokens.AccessToken
 }
 func TestCreateBook(t *testing.T) {
 	//tests adding a new book
@@ -68,7 +123,7 @@ func TestCreateBook(t *testing.T) {
 
 }
 func TestCreateBookFailed(t *testing.T) {
-	//First test checks if user tries to update book with rating above the accepted range
+	//First test checks if user tries to add a book with rating above the accepted range
 	var jsonStr = []byte(`{"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02T00:00:00Z","Rating":5,"Status":true}`)
 
 	req, err := http.NewRequest("POST", "/api/newbook", bytes.NewBuffer(jsonStr))
@@ -79,19 +134,19 @@ func TestCreateBookFailed(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(middleware.CreateBook)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusUnprocessableEntity)
 	}
 	//should show message correct rating range to user
-	expected := `{"id":-1,"message":"Rating needs to be in range 1-3"}`
+	expected := `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/api/newbook","errors":[{"field":"Rating","message":"Rating needs to be in range 1-3"}]}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data := strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
-	//Third test checks if user tries to update book with rating bellow the accepted range
+	//Second test checks if user tries to add a book with rating below the accepted range
 	jsonStr = []byte(`{"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02T00:00:00Z","Rating":0,"Status":true}`)
 
 	req, err = http.NewRequest("POST", "/api/newbook", bytes.NewBuffer(jsonStr))
@@ -102,12 +157,35 @@ func TestCreateBookFailed(t *testing.T) {
 	rr = httptest.NewRecorder()
 	handler = http.HandlerFunc(middleware.CreateBook)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusUnprocessableEntity)
 	}
 	//should show message correct rating range to user
-	expected = `{"id":-1,"message":"Rating needs to be in range 1-3"}`
+	expected = `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/api/newbook","errors":[{"field":"Rating","message":"Rating needs to be in range 1-3"}]}`
+	expected = strings.TrimRight(expected, "\r\n")
+	data = strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+	//Third test checks if user tries to add a book with a publish date that is not a date
+	jsonStr = []byte(`{"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"July 1998","Status":true}`)
+
+	req, err = http.NewRequest("POST", "/api/newbook", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(middleware.CreateBook)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+	//should show message with the accepted date format to user
+	expected = `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/api/newbook","errors":[{"field":"Publish_Date","message":"Publish_Date must be a date in the form YYYY-MM-DD, YYYY-MM or YYYY, or an RFC 3339 timestamp"}]}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data = strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -132,7 +210,7 @@ func TestGetBooks(t *testing.T) {
 	}
 
 	// Check the response body is what we expect.
-	expected := `[{"ID":1,"Title":"Crime and Punishment","Author":"Fyodor Dostoyevsky","Publisher":"The Russian Messenger","Publish_Date":"1886-02-15T00:00:00Z","Rating":2.8,"Status":false},{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02T00:00:00Z","Rating":3,"Status":false}]`
+	expected := `[{"ID":1,"Title":"Crime and Punishment","Author":"Fyodor Dostoyevsky","Publisher":"The Russian Messenger","Publish_Date":"1886-02-15","PublishDatePrecision":"day","Rating":2.8,"RatingCount":0,"Status":false,"Authors":[{"ID":1,"Name":"Fyodor Dostoyevsky","Role":"author"}],"PublisherID":1,"Copies":0,"AvailableCopies":0},{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02","PublishDatePrecision":"day","Rating":3,"RatingCount":0,"Status":false,"Authors":[{"ID":2,"Name":"J.K. Rowling","Role":"author"}],"PublisherID":2,"Copies":0,"AvailableCopies":0}]`
 	expected = strings.TrimRight(expected, "\r\n")
 	data := strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -140,6 +218,152 @@ func TestGetBooks(t *testing.T) {
 			data, expected)
 	}
 }
+func TestGetBooksFiltered(t *testing.T) {
+	//filters are combined with AND and author matching ignores case
+	req, err := http.NewRequest("GET", "/api/book?author=j.k.+rowling&status=false", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(middleware.GetAllBooks)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `[{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02","PublishDatePrecision":"day","Rating":3,"RatingCount":0,"Status":false,"Authors":[{"ID":2,"Name":"J.K. Rowling","Role":"author"}],"PublisherID":2,"Copies":0,"AvailableCopies":0}]`
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//title substring and publish date range
+	req, err = http.NewRequest("GET", "/api/book?title=PUNISH&published_to=1900-01-01", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	expected = `[{"ID":1,"Title":"Crime and Punishment","Author":"Fyodor Dostoyevsky","Publisher":"The Russian Messenger","Publish_Date":"1886-02-15","PublishDatePrecision":"day","Rating":2.8,"RatingCount":0,"Status":false,"Authors":[{"ID":1,"Name":"Fyodor Dostoyevsky","Role":"author"}],"PublisherID":1,"Copies":0,"AvailableCopies":0}]`
+	data = strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//a filter that cannot be parsed is a bad request
+	req, err = http.NewRequest("GET", "/api/book?status=maybe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+func TestGetBooksPaged(t *testing.T) {
+	//first page of one book sorted by title descending
+	req, err := http.NewRequest("GET", "/api/book?limit=1&sort=-Title", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(middleware.GetAllBooks)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if total := rr.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("handler returned wrong total: got %v want %v", total, "2")
+	}
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if !strings.Contains(data, `"ID":2`) || strings.Contains(data, `"ID":1`) {
+		t.Errorf("handler returned unexpected page: %v", data)
+	}
+
+	//follow the next link, which should hold the last book and only link back
+	link := rr.Header().Get("Link")
+	if !strings.HasSuffix(link, `rel="next"`) || strings.Contains(link, `rel="prev"`) {
+		t.Fatalf("handler returned unexpected Link header: %v", link)
+	}
+	next := link[strings.Index(link, "<")+1 : strings.Index(link, ">")]
+	req, err = http.NewRequest("GET", next, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	data = strings.TrimRight(rr.Body.String(), "\r\n")
+	if !strings.Contains(data, `"ID":1`) || strings.Contains(data, `"ID":2`) {
+		t.Errorf("handler returned unexpected page: %v", data)
+	}
+	link = rr.Header().Get("Link")
+	if !strings.HasSuffix(link, `rel="prev"`) || strings.Contains(link, `rel="next"`) {
+		t.Errorf("handler returned unexpected Link header: %v", link)
+	}
+
+	//offset pagination gets first, prev and last links
+	req, err = http.NewRequest("GET", "/api/book?limit=1&offset=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	expected := `</api/book?limit=1&offset=0>; rel="first", </api/book?limit=1&offset=0>; rel="prev", </api/book?limit=1&offset=1>; rel="last"`
+	if link := rr.Header().Get("Link"); link != expected {
+		t.Errorf("handler returned unexpected Link header: got %v want %v", link, expected)
+	}
+}
+func TestSearchBooks(t *testing.T) {
+	//go through the router to make sure search is not mistaken for a book id
+	req, err := http.NewRequest("GET", "/api/book/search?q=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	//stemming should match Secrets and mark it in the title
+	expected := `"Title":"Harry Potter and the Chamber of <mark>Secrets</mark>"`
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if !strings.Contains(data, expected) || strings.Contains(data, "Crime") {
+		t.Errorf("Handler response %v did not contain %v",
+			data, expected)
+	}
+
+	//phrases and excluded words
+	req, err = http.NewRequest("GET", `/api/book/search?q="chamber+of+secrets"+-rowling`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	data = strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != "[]" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, "[]")
+	}
+
+	//q is required
+	req, err = http.NewRequest("GET", "/api/book/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
 func TestGetBook(t *testing.T) {
 	req, err := http.NewRequest("GET", "/api/book/2", nil)
 	//req, err := http.NewRequest("GET", "/api/book/1", nil)
@@ -157,7 +381,7 @@ func TestGetBook(t *testing.T) {
 	}
 
 	// Check the response body is what we expect.
-	expected := `{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02T00:00:00Z","Rating":3,"Status":false}`
+	expected := `{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02","PublishDatePrecision":"day","Rating":3,"RatingCount":0,"Status":false,"Authors":[{"ID":2,"Name":"J.K. Rowling","Role":"author"}],"PublisherID":2,"Copies":0,"AvailableCopies":0}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data := strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -176,12 +400,12 @@ func TestGetBookFailed(t *testing.T) {
 	handler := http.HandlerFunc(middleware.GetBook)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusNotFound {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusNotFound)
 	}
 
-	expected := `{"ID":0,"Title":"","Author":"","Publisher":"","Publish_Date":"","Rating":0,"Status":false}`
+	expected := `{"type":"/problems/not-found","title":"Not found","status":404,"detail":"book not found: id 3","instance":"/api/book/3"}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data := strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -213,6 +437,82 @@ func TestEditEntry(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+func TestPatchBook(t *testing.T) {
+	//a merge patch only touches the fields it names
+	req, err := http.NewRequest("PATCH", "/api/book/2", bytes.NewBuffer([]byte(`{"Rating":3}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(middleware.PatchBook)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02","PublishDatePrecision":"day","Rating":3,"RatingCount":0,"Status":false,"Authors":[{"ID":2,"Name":"J.K. Rowling","Role":"author"}],"PublisherID":2,"Copies":0,"AvailableCopies":0}`
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//a json patch whose test holds is applied in full
+	jsonStr := []byte(`[{"op":"test","path":"/Rating","value":3},{"op":"replace","path":"/Rating","value":2.65}]`)
+	req, err = http.NewRequest("PATCH", "/api/book/2", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if data = rr.Body.String(); !strings.Contains(data, `"Rating":2.65`) {
+		t.Errorf("Handler response %v did not contain %v", data, `"Rating":2.65`)
+	}
+
+	//a failing test operation is a conflict and nothing is changed
+	jsonStr = []byte(`[{"op":"test","path":"/Rating","value":3},{"op":"replace","path":"/Title","value":"Changed"}]`)
+	req, err = http.NewRequest("PATCH", "/api/book/2", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	//the patched book must pass the same rules as a new one
+	req, err = http.NewRequest("PATCH", "/api/book/2", bytes.NewBuffer([]byte(`{"Rating":7}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+	req, err = http.NewRequest("PATCH", "/api/book/2", bytes.NewBuffer([]byte(`{"Publish_Date":"someday"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+}
 func TestEditEntryFailed(t *testing.T) {
 	//first test for trying to edit book that doesnt exist
 	var jsonStr = []byte(`{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02T00:00:00Z","Rating":2.65,"Status":true}`)
@@ -225,12 +525,12 @@ func TestEditEntryFailed(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(middleware.UpdateBook)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusNotFound {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusNotFound)
 	}
-	//should show that 0 rows/records were affected because book with id 3 does not exist
-	expected := `{"id":3,"message":"User updated successfully. Total rows/record affected 0 "}`
+	//should report not found because book with id 3 does not exist
+	expected := `{"type":"/problems/not-found","title":"Not found","status":404,"detail":"book not found: id 3","instance":"/api/book/3"}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data := strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -249,19 +549,19 @@ func TestEditEntryFailed(t *testing.T) {
 	rr = httptest.NewRecorder()
 	handler = http.HandlerFunc(middleware.UpdateBook)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusUnprocessableEntity)
 	}
 	//should show message correct rating range to user
-	expected = `{"id":2,"message":"Rating needs to be in range 1-3"}`
+	expected = `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/api/book/2","errors":[{"field":"Rating","message":"Rating needs to be in range 1-3"}]}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data = strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
-	//Third test checks if user tries to update book with rating bellow the accepted range
+	//Third test checks if user tries to update book with rating below the accepted range
 	jsonStr = []byte(`{"ID":2,"Title":"Harry Potter and the Chamber of Secrets","Author":"J.K. Rowling","Publisher":"Bloomsbury","Publish_Date":"1998-07-02T00:00:00Z","Rating":0,"Status":true}`)
 
 	req, err = http.NewRequest("PUT", "/api/book/2", bytes.NewBuffer(jsonStr))
@@ -272,12 +572,12 @@ func TestEditEntryFailed(t *testing.T) {
 	rr = httptest.NewRecorder()
 	handler = http.HandlerFunc(middleware.UpdateBook)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusUnprocessableEntity)
 	}
 	//should show message correct rating range to user
-	expected = `{"id":2,"message":"Rating needs to be in range 1-3"}`
+	expected = `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/api/book/2","errors":[{"field":"Rating","message":"Rating needs to be in range 1-3"}]}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data = strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -316,11 +616,11 @@ func TestDeleteBookFailed(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(middleware.DeleteBook)
 	handler.ServeHTTP(rr, req)
-	if status := rr.Code; status != http.StatusOK {
+	if status := rr.Code; status != http.StatusNotFound {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusNotFound)
 	}
-	expected := `{"id":3,"message":"User updated successfully. Total rows/record affected 0"}`
+	expected := `{"type":"/problems/not-found","title":"Not found","status":404,"detail":"book not found: id 3","instance":"/api/deletebook/3"}`
 	expected = strings.TrimRight(expected, "\r\n")
 	data := strings.TrimRight(rr.Body.String(), "\r\n")
 	if data != expected {
@@ -328,3 +628,1403 @@ func TestDeleteBookFailed(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+func TestBadRequests(t *testing.T) {
+	//malformed json should be rejected without taking the server down
+	req, err := http.NewRequest("POST", "/api/newbook", bytes.NewBuffer([]byte(`{"Title":`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(middleware.CreateBook)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	//a non numeric id should be a bad request
+	req, err = http.NewRequest("GET", "/api/book/abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(middleware.GetBook)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	expected := `{"type":"/problems/invalid-request","title":"Invalid request","status":400,"detail":"Book id must be an integer","instance":"/api/book/abc"}`
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//a publish date the store cannot understand is unprocessable
+	jsonStr := []byte(`{"Title":"Dune","Author":"Frank Herbert","Publisher":"Chilton Books","Publish_Date":"not a date","Rating":3,"Status":false}`)
+	req, err = http.NewRequest("POST", "/api/newbook", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(middleware.CreateBook)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("handler returned wrong content type: got %v want %v",
+			ct, "application/problem+json")
+	}
+}
+func TestImportBooks(t *testing.T) {
+	csvStr := "Title,Author,Publisher,Publish_Date,Rating,Status\n" +
+		"Dune,Frank Herbert,Chilton Books,1965-08-01,3,false\n" +
+		"\"The Hobbit, or There and Back Again\",J.R.R. Tolkien,George Allen & Unwin,1937-09-21,9,false\n" +
+		"Emma,Jane Austen,John Murray,1815-12-23,2.5,true\n"
+
+	//a dry run reports what would happen without writing anything
+	req, err := http.NewRequest("POST", "/api/book/import?dry_run=true", strings.NewReader(csvStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(middleware.ImportBooks)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := `{"dry_run":true,"rows":3,"imported":2,"rejected":1,"ids":[],"errors":[{"line":3,"errors":[{"field":"Rating","message":"Rating needs to be in range 1-3"}]}]}`
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//the real import inserts the valid rows and reports the same rejection
+	req, err = http.NewRequest("POST", "/api/book/import", strings.NewReader(csvStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	expected = `{"dry_run":false,"rows":3,"imported":2,"rejected":1,"ids":[3,4],"errors":[{"line":3,"errors":[{"field":"Rating","message":"Rating needs to be in range 1-3"}]}]}`
+	data = strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//a header naming something that is not a book field is refused
+	req, err = http.NewRequest("POST", "/api/book/import", strings.NewReader("Title,Pages\nDune,412\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+func TestExportBooks(t *testing.T) {
+	//csv honours the listing filters and sort
+	req, err := http.NewRequest("GET", "/api/book/export?format=csv&published_from=1800-01-01&sort=-Title", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(middleware.ExportBooks)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	expected := "ID,Title,Author,Publisher,Publish_Date,Rating,Status,ISBN_10,ISBN_13\n" +
+		"2,Harry Potter and the Chamber of Secrets,J.K. Rowling,Bloomsbury,1998-07-02,2.65,false,,\n" +
+		"4,Emma,Jane Austen,John Murray,1815-12-23,2.5,false,,\n" +
+		"3,Dune,Frank Herbert,Chilton Books,1965-08-01,3,false,,\n"
+	if data := rr.Body.String(); data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//ndjson chosen from the Accept header, one book per line
+	req, err = http.NewRequest("GET", "/api/book/export?author=jane+austen", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	expected = `{"ID":4,"Title":"Emma","Author":"Jane Austen","Publisher":"John Murray","Publish_Date":"1815-12-23","PublishDatePrecision":"day","Rating":2.5,"RatingCount":0,"Status":false,"Authors":[{"ID":4,"Name":"Jane Austen","Role":"author"}],"PublisherID":4,"Copies":0,"AvailableCopies":0}` + "\n"
+	if data := rr.Body.String(); data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//a json array is still valid json when nothing matches
+	req, err = http.NewRequest("GET", "/api/book/export?format=json&author=nobody", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if data := strings.TrimRight(rr.Body.String(), "\r\n"); data != "[]" {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, "[]")
+	}
+
+	//exporting the whole catalogue needs a signed in user
+	doRequestAs(t, "", "GET", "/api/book/export", "", http.StatusUnauthorized)
+	doRequest(t, "GET", "/api/book/export?format=json&author=nobody", "", http.StatusOK)
+}
+func TestBookISBN(t *testing.T) {
+	//an ISBN-10 with hyphens is stored as both forms
+	jsonStr := []byte(`{"ID":3,"Title":"Dune","Author":"Frank Herbert","Publisher":"Chilton Books","Publish_Date":"1965-08-01","Rating":3,"Status":false,"ISBN_10":"0-441-17271-7"}`)
+	req, err := http.NewRequest("PUT", "/api/book/3", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	//it can then be found by either form
+	expected := `{"ID":3,"Title":"Dune","Author":"Frank Herbert","Publisher":"Chilton Books","Publish_Date":"1965-08-01","PublishDatePrecision":"day","Rating":3,"RatingCount":0,"Status":false,"ISBN_10":"0441172717","ISBN_13":"9780441172719","Authors":[{"ID":3,"Name":"Frank Herbert","Role":"author"}],"PublisherID":3,"Copies":0,"AvailableCopies":0}`
+	for _, number := range []string{"0441172717", "978-0-441-17271-9"} {
+		req, err = http.NewRequest("GET", "/api/book/isbn/"+number, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code for %v: got %v want %v",
+				number, status, http.StatusOK)
+		}
+		data := strings.TrimRight(rr.Body.String(), "\r\n")
+		if data != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				data, expected)
+		}
+	}
+
+	//a bad check digit is rejected and a second book cannot reuse the number
+	for _, body := range []struct {
+		json   string
+		status int
+	}{
+		{`{"Title":"Dune","Publish_Date":"1965-08-01","Rating":3,"ISBN_13":"9780441172710"}`, http.StatusUnprocessableEntity},
+		{`{"Title":"Dune","Publish_Date":"1965-08-01","Rating":3,"ISBN_13":"9780441172719"}`, http.StatusConflict},
+	} {
+		req, err = http.NewRequest("POST", "/api/newbook", strings.NewReader(body.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != body.status {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, body.status)
+		}
+	}
+
+	//lookups with an invalid or unknown ISBN
+	for _, c := range []struct {
+		number string
+		status int
+	}{
+		{"12345", http.StatusBadRequest},
+		{"9780306406157", http.StatusNotFound},
+	} {
+		req, err = http.NewRequest("GET", "/api/book/isbn/"+c.number, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != c.status {
+			t.Errorf("handler returned wrong status code for %v: got %v want %v",
+				c.number, status, c.status)
+		}
+	}
+}
+func TestAuthors(t *testing.T) {
+	//an Author string naming two people is split into two credits
+	jsonStr := `{"Title":"Good Omens","Author":"Terry Pratchett & Neil Gaiman","Publisher":"Gollancz","Publish_Date":"1990-05-01","Rating":3,"Status":true}`
+	req, err := http.NewRequest("POST", "/api/newbook", strings.NewReader(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	var created struct{ ID int64 }
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	book := getBook(t, created.ID)
+	if book.Author != "Terry Pratchett, Neil Gaiman" || len(book.Authors) != 2 || book.Authors[1].Name != "Neil Gaiman" || book.Authors[1].Role != "author" {
+		t.Errorf("book was not credited to both authors: got %+v", book)
+	}
+	gaiman := book.Authors[1].ID
+
+	//another spelling of the same name is the same person, and credits keep their order and role
+	jsonStr = fmt.Sprintf(`{"Title":"The Sandman","Publisher":"DC Comics","Publish_Date":"1989-01-01","Rating":3,"Status":true,`+
+		`"Authors":[{"ID":%d},{"Name":"dave  McKean","Role":"illustrator"},{"Name":"Dave McKean.","Role":"editor"}]}`, gaiman)
+	req, err = http.NewRequest("POST", "/api/newbook", strings.NewReader(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	json.NewDecoder(rr.Body).Decode(&created)
+	book = getBook(t, created.ID)
+	if book.Author != "Neil Gaiman" || len(book.Authors) != 3 || book.Authors[1].ID != book.Authors[2].ID || book.Authors[2].Role != "editor" {
+		t.Errorf("book was not credited as sent: got %+v", book)
+	}
+
+	//renaming an author changes the Author string of their books
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/api/authors/%d", gaiman), strings.NewReader(`{"Name":"Neil Richard Gaiman"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if book = getBook(t, created.ID); book.Author != "Neil Richard Gaiman" {
+		t.Errorf("book author was not renamed: got %v", book.Author)
+	}
+
+	//listing by part of a name
+	req, err = http.NewRequest("GET", "/api/authors?name=GAIMAN", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	expected := fmt.Sprintf(`[{"ID":%d,"Name":"Neil Richard Gaiman"}]`, gaiman)
+	data := strings.TrimRight(rr.Body.String(), "\r\n")
+	if data != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			data, expected)
+	}
+
+	//duplicates, credited authors, unknown ids and roles are refused
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/api/authors", `{"Name":"terry pratchett"}`, http.StatusConflict},
+		{"POST", "/api/authors", `{"Name":" "}`, http.StatusUnprocessableEntity},
+		{"DELETE", fmt.Sprintf("/api/authors/%d", gaiman), "", http.StatusConflict},
+		{"GET", "/api/authors/9999", "", http.StatusNotFound},
+		{"POST", "/api/newbook", `{"Title":"X","Publish_Date":"2000-01-01","Rating":1,"Authors":[{"ID":9999}]}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/newbook", `{"Title":"X","Publish_Date":"2000-01-01","Rating":1,"Authors":[{"Name":"A","Role":"ghost"}]}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/authors", `{"Name":"Ursula K. Le Guin"}`, http.StatusCreated},
+	} {
+		req, err = http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != c.status {
+			t.Errorf("%v %v returned wrong status code: got %v want %v",
+				c.method, c.url, status, c.status)
+		}
+	}
+}
+
+// getBook fetches a book through the api
+func getBook(t *testing.T, id int64) models.Book {
+	req, err := http.NewRequest("GET", fmt.Sprintf("/api/book/%d", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	var book models.Book
+	if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// ratingText writes a book's Rating as it is sent, null when it has none
+func ratingText(rating *float64) string {
+	if rating == nil {
+		return "null"
+	}
+	return strconv.FormatFloat(*rating, 'g', -1, 64)
+}
+func TestPublishers(t *testing.T) {
+	//an imprint of Bloomsbury, which came from the Publisher string of book 2, and an imprint of that
+	imprint := createPublisher(t, `{"Name":"Bloomsbury Children's","ParentID":2}`)
+	nested := createPublisher(t, fmt.Sprintf(`{"Name":"Bloomsbury Kids Early Readers","ParentID":%d}`, imprint))
+
+	jsonStr := fmt.Sprintf(`{"Title":"The Gruffalo","Author":"Julia Donaldson","Publish_Date":"1999-03-23","Rating":3,"Status":true,"PublisherID":%d}`, nested)
+	req, err := http.NewRequest("POST", "/api/newbook", strings.NewReader(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	var created struct{ ID int64 }
+	json.NewDecoder(rr.Body).Decode(&created)
+	if book := getBook(t, created.ID); book.Publisher != "Bloomsbury Kids Early Readers" {
+		t.Errorf("book publisher was not set from PublisherID: got %v", book.Publisher)
+	}
+
+	//the publisher's listing takes in the books of every imprint below it
+	req, err = http.NewRequest("GET", "/api/publishers/2/books?sort=ID", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	var page []models.Book
+	json.NewDecoder(rr.Body).Decode(&page)
+	if len(page) != 2 || page[0].ID != 2 || page[1].ID != created.ID {
+		t.Errorf("publisher listing is wrong: got %+v", page)
+	}
+	if total := rr.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("handler returned wrong total: got %v want %v", total, "2")
+	}
+
+	//renaming an imprint renames it on its books
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/api/publishers/%d", nested), strings.NewReader(fmt.Sprintf(`{"Name":"Bloomsbury Early Readers","ParentID":%d}`, imprint)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	if book := getBook(t, created.ID); book.Publisher != "Bloomsbury Early Readers" {
+		t.Errorf("book publisher was not renamed: got %v", book.Publisher)
+	}
+
+	//patching the Publisher name moves the book to that publisher
+	req, err = http.NewRequest("PATCH", fmt.Sprintf("/api/book/%d", created.ID), strings.NewReader(`{"Publisher":"macmillan"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	if book := getBook(t, created.ID); book.PublisherID == nested || book.Publisher != "macmillan" {
+		t.Errorf("book was not moved to the new publisher: got %+v", book)
+	}
+
+	//cycles, publishers in use and unknown publishers are refused
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"PUT", "/api/publishers/2", fmt.Sprintf(`{"Name":"Bloomsbury","ParentID":%d}`, nested), http.StatusUnprocessableEntity},
+		{"PUT", fmt.Sprintf("/api/publishers/%d", imprint), fmt.Sprintf(`{"Name":"Bloomsbury Children's","ParentID":%d}`, imprint), http.StatusUnprocessableEntity},
+		{"POST", "/api/publishers", `{"Name":"BLOOMSBURY"}`, http.StatusConflict},
+		{"POST", "/api/publishers", `{"Name":"Puffin","ParentID":9999}`, http.StatusUnprocessableEntity},
+		{"DELETE", "/api/publishers/2", "", http.StatusConflict},
+		{"DELETE", fmt.Sprintf("/api/publishers/%d", nested), "", http.StatusOK},
+		{"GET", "/api/publishers/9999/books", "", http.StatusNotFound},
+		{"POST", "/api/newbook", `{"Title":"X","Publish_Date":"2000-01-01","Rating":1,"PublisherID":9999}`, http.StatusUnprocessableEntity},
+	} {
+		req, err = http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != c.status {
+			t.Errorf("%v %v returned wrong status code: got %v want %v",
+				c.method, c.url, status, c.status)
+		}
+	}
+}
+
+// createPublisher adds a publisher through the api and returns its id
+func createPublisher(t *testing.T, body string) int64 {
+	req, err := http.NewRequest("POST", "/api/publishers", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("creating publisher returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var created struct{ ID int64 }
+	json.NewDecoder(rr.Body).Decode(&created)
+	return created.ID
+}
+
+func TestGenresAndTags(t *testing.T) {
+	//Fiction > Fantasy > High Fantasy, with book 2 in Fantasy and a new book in High Fantasy
+	fiction := createGenre(t, `{"Name":"Fiction"}`)
+	fantasy := createGenre(t, fmt.Sprintf(`{"Name":"Fantasy","ParentID":%d}`, fiction))
+	high := createGenre(t, fmt.Sprintf(`{"Name":"High Fantasy","ParentID":%d}`, fantasy))
+
+	req, err := http.NewRequest("POST", "/api/newbook", strings.NewReader(`{"Title":"The Hobbit","Author":"J. R. R. Tolkien","Publish_Date":"1937-09-21","Rating":3,"Status":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	var created struct{ ID int64 }
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	for _, url := range []string{
+		"/api/book/2/genres/" + fmt.Sprint(fantasy),
+		fmt.Sprintf("/api/book/%d/genres/%d", created.ID, high),
+		"/api/book/2/tags/Wizards",
+		fmt.Sprintf("/api/book/%d/tags/wizards", created.ID),
+		fmt.Sprintf("/api/book/%d/tags/%%20Dragons%%20", created.ID),
+	} {
+		req, err = http.NewRequest("PUT", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("PUT %v returned wrong status code: got %v want %v", url, status, http.StatusOK)
+		}
+	}
+	var book models.Book
+	json.NewDecoder(rr.Body).Decode(&book)
+	if len(book.Genres) != 1 || book.Genres[0].ID != high || strings.Join(book.Tags, ",") != "dragons,wizards" {
+		t.Errorf("book genres and tags are wrong: got %+v %v", book.Genres, book.Tags)
+	}
+
+	//filtering by a genre takes in the books of every genre below it
+	for _, c := range []struct {
+		query string
+		ids   []int64
+	}{
+		{fmt.Sprintf("genre=%d", fiction), []int64{2, created.ID}},
+		{fmt.Sprintf("genre=%d", high), []int64{created.ID}},
+		{"tag=WIZARDS", []int64{2, created.ID}},
+		{"tag=dragons", []int64{created.ID}},
+	} {
+		req, err = http.NewRequest("GET", "/api/book?sort=ID&"+c.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		var page []models.Book
+		json.NewDecoder(rr.Body).Decode(&page)
+		var ids []int64
+		for _, b := range page {
+			ids = append(ids, b.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(c.ids) {
+			t.Errorf("?%v returned the wrong books: got %v want %v", c.query, ids, c.ids)
+		}
+	}
+
+	req, err = http.NewRequest("GET", "/api/tags", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	serve(rr, req)
+	expected := `[{"Name":"dragons","Books":1},{"Name":"wizards","Books":2}]`
+	if strings.TrimSpace(rr.Body.String()) != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	//cycles, genres in use and unknown books are refused
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"PUT", fmt.Sprintf("/api/genres/%d", fiction), fmt.Sprintf(`{"Name":"Fiction","ParentID":%d}`, high), http.StatusUnprocessableEntity},
+		{"POST", "/api/genres", `{"Name":"high fantasy"}`, http.StatusConflict},
+		{"DELETE", fmt.Sprintf("/api/genres/%d", fantasy), "", http.StatusConflict},
+		{"PUT", fmt.Sprintf("/api/book/9999/genres/%d", fiction), "", http.StatusNotFound},
+		{"PUT", "/api/book/2/genres/9999", "", http.StatusNotFound},
+		{"PUT", "/api/book/9999/tags/wizards", "", http.StatusNotFound},
+		{"DELETE", "/api/book/2/tags/dragons", "", http.StatusNotFound},
+		{"DELETE", "/api/book/2/tags/wizards", "", http.StatusOK},
+		{"DELETE", fmt.Sprintf("/api/genres/%d", high), "", http.StatusOK},
+		{"GET", "/api/book?genre=fantasy", "", http.StatusBadRequest},
+	} {
+		req, err = http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		serve(rr, req)
+		if status := rr.Code; status != c.status {
+			t.Errorf("%v %v returned wrong status code: got %v want %v",
+				c.method, c.url, status, c.status)
+		}
+	}
+	if book := getBook(t, created.ID); len(book.Genres) != 0 {
+		t.Errorf("deleted genre was not taken off its books: got %+v", book.Genres)
+	}
+}
+
+// createGenre adds a genre through the api and returns its id
+func createGenre(t *testing.T, body string) int64 {
+	req, err := http.NewRequest("POST", "/api/genres", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("creating genre returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var created struct{ ID int64 }
+	json.NewDecoder(rr.Body).Decode(&created)
+	return created.ID
+}
+
+func TestLoans(t *testing.T) {
+	//a copy can only be lent once, however many checkouts race for it
+	doRequest(t, "POST", "/api/book/2/copies", `{"Barcode":"HP-0001"}`, http.StatusCreated)
+	body := `{"BookID":2,"Patron":" card-1001 "}`
+	codes := make(chan int, 10)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			req := httptest.NewRequest("POST", "/api/loans", strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			serve(rr, req)
+			codes <- rr.Code
+		}()
+	}
+	created, conflicts := 0, 0
+	for i := 0; i < cap(codes); i++ {
+		switch <-codes {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+			conflicts++
+		}
+	}
+	if created != 1 || conflicts != cap(codes)-1 {
+		t.Errorf("concurrent checkouts of one copy gave %v loans and %v conflicts, want 1 and %v", created, conflicts, cap(codes)-1)
+	}
+	if book := getBook(t, 2); !book.Status {
+		t.Errorf("book on loan does not have Status true")
+	}
+
+	loan := doRequest(t, "GET", "/api/loans?open=true&book_id=2", "", http.StatusOK)
+	var open []models.Loan
+	json.Unmarshal(loan, &open)
+	if len(open) != 1 || open[0].Patron != "card-1001" || open[0].ReturnedAt != nil || open[0].Overdue {
+		t.Fatalf("open loans are wrong: got %+v", open)
+	}
+	id := open[0].ID
+	if days := open[0].DueAt.Sub(open[0].CheckedOutAt).Hours() / 24; days < 13.9 || days > 14.1 {
+		t.Errorf("loan is due after %v days, want 14", days)
+	}
+
+	//renewing works up to the policy's limit
+	doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/renew", id), "", http.StatusOK)
+	var renewed models.Loan
+	json.Unmarshal(doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/renew", id), "", http.StatusOK), &renewed)
+	if renewed.Renewals != 2 || renewed.DueAt.Before(open[0].DueAt) {
+		t.Errorf("renewed loan is wrong: got %+v", renewed)
+	}
+	doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/renew", id), "", http.StatusConflict)
+	doRequest(t, "DELETE", "/api/deletebook/2", "", http.StatusConflict)
+
+	//returning closes the loan once and frees the book for the next patron
+	var returned models.Loan
+	json.Unmarshal(doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/return", id), "", http.StatusOK), &returned)
+	if returned.ReturnedAt == nil {
+		t.Errorf("returned loan has no ReturnedAt: got %+v", returned)
+	}
+	if book := getBook(t, 2); book.Status {
+		t.Errorf("returned book still has Status true")
+	}
+	doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/return", id), "", http.StatusConflict)
+	doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/renew", id), "", http.StatusConflict)
+	doRequest(t, "POST", "/api/loans", `{"BookID":2,"Patron":"card-2002","DueAt":"2999-01-01T00:00:00Z"}`, http.StatusCreated)
+
+	//history per book, newest first, and per patron
+	var history []models.Loan
+	json.Unmarshal(doRequest(t, "GET", "/api/book/2/loans", "", http.StatusOK), &history)
+	if len(history) != 2 || history[0].Patron != "card-2002" || history[1].ID != id {
+		t.Errorf("book loan history is wrong: got %+v", history)
+	}
+	json.Unmarshal(doRequest(t, "GET", "/api/patrons/card-1001/loans", "", http.StatusOK), &history)
+	if len(history) != 1 || history[0].ID != id {
+		t.Errorf("patron loan history is wrong: got %+v", history)
+	}
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/api/loans", `{"BookID":9999,"Patron":"card-1001"}`, http.StatusNotFound},
+		{"POST", "/api/loans", `{"BookID":2}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/loans", `{"BookID":3,"Patron":"card-1001","DueAt":"2000-01-01T00:00:00Z"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/loans/9999/return", "", http.StatusNotFound},
+		{"GET", "/api/book/9999/loans", "", http.StatusNotFound},
+		{"GET", "/api/loans?open=maybe", "", http.StatusBadRequest},
+	} {
+		doRequest(t, c.method, c.url, c.body, c.status)
+	}
+}
+
+// doRequest sends a request, checks the status code and returns the body
+func doRequest(t *testing.T, method, url, body string, status int) []byte {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	if rr.Code != status {
+		t.Errorf("%v %v returned wrong status code: got %v want %v", method, url, rr.Code, status)
+	}
+	return rr.Body.Bytes()
+}
+
+func TestCopies(t *testing.T) {
+	//book 2 has HP-0001 out on loan from TestLoans; add a copy to lend and one that cannot be
+	doRequest(t, "POST", "/api/book/2/copies", `{"Barcode":"HP-0002","Condition":"New","AcquiredOn":"2021-03-04","Location":"Shelf F3"}`, http.StatusCreated)
+	doRequest(t, "POST", "/api/book/2/copies", `{"Barcode":"HP-0003","Condition":"damaged"}`, http.StatusCreated)
+	if book := getBook(t, 2); book.Copies != 3 || book.AvailableCopies != 1 || book.Status {
+		t.Errorf("book copy counts are wrong: got %v copies, %v available, status %v", book.Copies, book.AvailableCopies, book.Status)
+	}
+
+	var list []models.Copy
+	json.Unmarshal(doRequest(t, "GET", "/api/book/2/copies", "", http.StatusOK), &list)
+	if len(list) != 3 || list[0].Available || !list[1].Available || list[2].Available {
+		t.Fatalf("copies are wrong: got %+v", list)
+	}
+	if c := list[1]; c.Condition != "new" || c.AcquiredOn != "2021-03-04" || c.Location != "Shelf F3" {
+		t.Errorf("copy fields are wrong: got %+v", c)
+	}
+
+	//lending the last copy checks the book out
+	var loan models.Loan
+	json.Unmarshal(doRequest(t, "POST", "/api/loans", `{"BookID":2,"Patron":"card-3003"}`, http.StatusCreated), &loan)
+	if loan.CopyID != list[1].ID {
+		t.Errorf("checkout lent copy %v, want the available copy %v", loan.CopyID, list[1].ID)
+	}
+	if book := getBook(t, 2); book.AvailableCopies != 0 || !book.Status {
+		t.Errorf("book with every copy out is wrong: got %v available, status %v", book.AvailableCopies, book.Status)
+	}
+	doRequest(t, "POST", "/api/loans", `{"BookID":2,"Patron":"card-3003"}`, http.StatusConflict)
+
+	//and editing the book cannot check it back in
+	book := getBook(t, 2)
+	book.Status = false
+	body, _ := json.Marshal(book)
+	doRequest(t, "PUT", "/api/book/2", string(body), http.StatusOK)
+	doRequest(t, "PATCH", "/api/book/2", `{"Status":false}`, http.StatusOK)
+	if book := getBook(t, 2); book.AvailableCopies != 0 || !book.Status {
+		t.Errorf("edited book with every copy out is wrong: got %v available, status %v", book.AvailableCopies, book.Status)
+	}
+
+	//a repaired copy can be lent by barcode
+	doRequest(t, "PUT", fmt.Sprintf("/api/copies/%d", list[2].ID), `{"Barcode":"HP-0003","Condition":"fair"}`, http.StatusOK)
+	json.Unmarshal(doRequest(t, "POST", "/api/loans", `{"BookID":2,"Barcode":"HP-0003","Patron":"card-3003"}`, http.StatusCreated), &loan)
+	if loan.CopyID != list[2].ID {
+		t.Errorf("checkout by barcode lent copy %v, want %v", loan.CopyID, list[2].ID)
+	}
+
+	//a returned copy can be removed, leaving its loans in the book's history
+	doRequest(t, "DELETE", fmt.Sprintf("/api/copies/%d", list[2].ID), "", http.StatusConflict)
+	doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/return", loan.ID), "", http.StatusOK)
+	doRequest(t, "DELETE", fmt.Sprintf("/api/copies/%d", list[2].ID), "", http.StatusOK)
+	json.Unmarshal(doRequest(t, "GET", fmt.Sprintf("/api/loans/%d", loan.ID), "", http.StatusOK), &loan)
+	if loan.CopyID != 0 || loan.BookID != 2 {
+		t.Errorf("loan of a removed copy is wrong: got %+v", loan)
+	}
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/api/book/3/copies", `{"Barcode":"HP-0001"}`, http.StatusConflict},
+		{"POST", "/api/book/3/copies", `{"Barcode":"D-1","Condition":"mint"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/book/3/copies", `{"Barcode":"D-1","AcquiredOn":"last week"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/book/9999/copies", `{"Barcode":"X-1"}`, http.StatusNotFound},
+		{"GET", "/api/book/9999/copies", "", http.StatusNotFound},
+		{"POST", "/api/loans", `{"BookID":3,"Patron":"card-3003"}`, http.StatusConflict},
+		{"POST", "/api/loans", `{"BookID":2,"Barcode":"D-1","Patron":"card-3003"}`, http.StatusNotFound},
+		{"GET", "/api/copies/9999", "", http.StatusNotFound},
+	} {
+		doRequest(t, c.method, c.url, c.body, c.status)
+	}
+}
+
+// doRequestAs is doRequest with the given access token, or none when it is empty
+func doRequestAs(t *testing.T, accessToken, method, url, body string, status int) []byte {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rr := httptest.NewRecorder()
+	router.Router().ServeHTTP(rr, req)
+	if rr.Code != status {
+		t.Errorf("%v %v returned wrong status code: got %v want %v", method, url, rr.Code, status)
+	}
+	return rr.Body.Bytes()
+}
+
+func TestAuth(t *testing.T) {
+	var user models.User
+	json.Unmarshal(doRequest(t, "POST", "/api/auth/register", `{"Username":"Reader.One","Password":"open sesame"}`, http.StatusCreated), &user)
+	if user.ID == 0 || user.Username != "Reader.One" || user.Role != "reader" {
+		t.Errorf("register returned %+v", user)
+	}
+
+	var tokens struct{ AccessToken, RefreshToken, TokenType string }
+	json.Unmarshal(doRequestAs(t, "", "POST", "/api/auth/login", `{"Username":"reader.one","Password":"open sesame"}`, http.StatusOK), &tokens)
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" {
+		t.Fatalf("login returned %+v", tokens)
+	}
+	var me models.User
+	json.Unmarshal(doRequestAs(t, tokens.AccessToken, "GET", "/api/auth/me", "", http.StatusOK), &me)
+	if me != user {
+		t.Errorf("me returned %+v, want %+v", me, user)
+	}
+
+	//reads are open to everyone, writes need a valid token
+	doRequestAs(t, "", "GET", "/api/book/2", "", http.StatusOK)
+	doRequestAs(t, "", "POST", "/api/genres", `{"Name":"Anonymous"}`, http.StatusUnauthorized)
+	doRequestAs(t, "not-a-token", "GET", "/api/book/2", "", http.StatusUnauthorized)
+	//a new account is only a reader
+	doRequestAs(t, tokens.AccessToken, "POST", "/api/genres", `{"Name":"Signed In"}`, http.StatusForbidden)
+
+	//a refresh token works once; using it again revokes the ones it was swapped for
+	first := tokens.RefreshToken
+	json.Unmarshal(doRequestAs(t, "", "POST", "/api/auth/refresh", `{"RefreshToken":"`+first+`"}`, http.StatusOK), &tokens)
+	if tokens.RefreshToken == first {
+		t.Errorf("refresh did not rotate the refresh token")
+	}
+	doRequestAs(t, "", "POST", "/api/auth/refresh", `{"RefreshToken":"`+first+`"}`, http.StatusUnauthorized)
+	doRequestAs(t, "", "POST", "/api/auth/refresh", `{"RefreshToken":"`+tokens.RefreshToken+`"}`, http.StatusUnauthorized)
+
+	//logging out revokes the access token and the refresh token sent with it
+	json.Unmarshal(doRequestAs(t, "", "POST", "/api/auth/login", `{"Username":"Reader.One","Password":"open sesame"}`, http.StatusOK), &tokens)
+	doRequestAs(t, tokens.AccessToken, "POST", "/api/auth/logout", `{"RefreshToken":"`+tokens.RefreshToken+`"}`, http.StatusOK)
+	doRequestAs(t, tokens.AccessToken, "GET", "/api/auth/me", "", http.StatusUnauthorized)
+	doRequestAs(t, "", "POST", "/api/auth/refresh", `{"RefreshToken":"`+tokens.RefreshToken+`"}`, http.StatusUnauthorized)
+
+	for _, c := range []struct {
+		url, body string
+		status    int
+	}{
+		{"/api/auth/register", `{"Username":"READER.ONE","Password":"another one"}`, http.StatusConflict},
+		{"/api/auth/register", `{"Username":"ab","Password":"long enough"}`, http.StatusUnprocessableEntity},
+		{"/api/auth/register", `{"Username":"has space","Password":"long enough"}`, http.StatusUnprocessableEntity},
+		{"/api/auth/register", `{"Username":"shortpass","Password":"short"}`, http.StatusUnprocessableEntity},
+		{"/api/auth/login", `{"Username":"Reader.One","Password":"wrong password"}`, http.StatusUnauthorized},
+		{"/api/auth/login", `{"Username":"nobody","Password":"open sesame"}`, http.StatusUnauthorized},
+		{"/api/auth/logout", ``, http.StatusUnauthorized},
+	} {
+		doRequestAs(t, "", "POST", c.url, c.body, c.status)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	//the admin signed in by TestSetUp
+	var admin models.User
+	json.Unmarshal(doRequest(t, "GET", "/api/auth/me", "", http.StatusOK), &admin)
+	if admin.Role != "admin" {
+		t.Errorf("librarian has role %q, want admin", admin.Role)
+	}
+
+	var user models.User
+	json.Unmarshal(doRequest(t, "POST", "/api/auth/register", `{"Username":"desk","Password":"open sesame"}`, http.StatusCreated), &user)
+	var tokens struct{ AccessToken string }
+	json.Unmarshal(doRequestAs(t, "", "POST", "/api/auth/login", `{"Username":"desk","Password":"open sesame"}`, http.StatusOK), &tokens)
+
+	//a reader may only read
+	doRequestAs(t, tokens.AccessToken, "GET", "/api/genres", "", http.StatusOK)
+	body := doRequestAs(t, tokens.AccessToken, "POST", "/api/authors", `{"Name":"Staff Only"}`, http.StatusForbidden)
+	var p struct{ Type string }
+	json.Unmarshal(body, &p)
+	if p.Type != "/problems/forbidden" {
+		t.Errorf("forbidden response has problem type %q: %s", p.Type, body)
+	}
+	doRequestAs(t, tokens.AccessToken, "GET", "/api/users", "", http.StatusForbidden)
+	doRequestAs(t, tokens.AccessToken, "GET", "/api/stats/pool", "", http.StatusForbidden)
+	doRequestAs(t, "", "GET", "/api/stats/pool", "", http.StatusUnauthorized)
+
+	//staff may create and update but not delete, and the new role applies to the same token
+	json.Unmarshal(doRequest(t, "PUT", fmt.Sprintf("/api/users/%d/role", user.ID), `{"Role":"Staff"}`, http.StatusOK), &user)
+	if user.Role != "staff" {
+		t.Errorf("role was set to %q, want staff", user.Role)
+	}
+	var created struct{ ID int64 }
+	json.Unmarshal(doRequestAs(t, tokens.AccessToken, "POST", "/api/genres", `{"Name":"Staff Picks"}`, http.StatusCreated), &created)
+	doRequestAs(t, tokens.AccessToken, "PUT", fmt.Sprintf("/api/genres/%d", created.ID), `{"Name":"Staff Favourites"}`, http.StatusOK)
+	doRequestAs(t, tokens.AccessToken, "DELETE", fmt.Sprintf("/api/genres/%d", created.ID), "", http.StatusForbidden)
+	doRequestAs(t, tokens.AccessToken, "PUT", fmt.Sprintf("/api/users/%d/role", user.ID), `{"Role":"admin"}`, http.StatusForbidden)
+
+	//an admin may delete and manage users
+	doRequest(t, "DELETE", fmt.Sprintf("/api/genres/%d", created.ID), "", http.StatusOK)
+	var list []models.User
+	json.Unmarshal(doRequest(t, "GET", "/api/users", "", http.StatusOK), &list)
+	if len(list) < 2 {
+		t.Errorf("user list is too short: %+v", list)
+	}
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"PUT", fmt.Sprintf("/api/users/%d/role", admin.ID), `{"Role":"reader"}`, http.StatusConflict},
+		{"PUT", fmt.Sprintf("/api/users/%d/role", user.ID), `{"Role":"owner"}`, http.StatusUnprocessableEntity},
+		{"PUT", "/api/users/9999/role", `{"Role":"staff"}`, http.StatusNotFound},
+		{"GET", "/api/users/9999", "", http.StatusNotFound},
+	} {
+		doRequest(t, c.method, c.url, c.body, c.status)
+	}
+	doRequestAs(t, "", "POST", "/api/genres", `{"Name":"Anonymous"}`, http.StatusUnauthorized)
+	doRequestAs(t, "", "OPTIONS", "/api/genres", "", http.StatusOK)
+}
+
+// doRequestWithKey is doRequest with the given api key instead of the test user's token
+func doRequestWithKey(t *testing.T, key, method, url, body string, status int) []byte {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", key)
+	rr := httptest.NewRecorder()
+	router.Router().ServeHTTP(rr, req)
+	if rr.Code != status {
+		t.Errorf("%v %v returned wrong status code: got %v want %v", method, url, rr.Code, status)
+	}
+	return rr.Body.Bytes()
+}
+
+func TestAPIKeys(t *testing.T) {
+	var key models.APIKey
+	json.Unmarshal(doRequest(t, "POST", "/api/apikeys", `{"Name":"Warehouse","Role":"staff","Scopes":["Copies"," genres","copies"]}`, http.StatusCreated), &key)
+	if !strings.HasPrefix(key.Key, key.Prefix+".") || key.Role != "staff" || key.LastUsedAt != nil ||
+		strings.Join(key.Scopes, ",") != "genres,copies" {
+		t.Fatalf("created api key is wrong: %+v", key)
+	}
+
+	//the key is only shown once, and is never sent back in a listing
+	var list []models.APIKey
+	json.Unmarshal(doRequest(t, "GET", "/api/apikeys", "", http.StatusOK), &list)
+	if len(list) != 1 || list[0].ID != key.ID || list[0].Key != "" || list[0].Prefix != key.Prefix {
+		t.Errorf("api key list is wrong: %+v", list)
+	}
+
+	//a key acts with its role within its scopes and records when it was used
+	doRequestWithKey(t, key.Key, "POST", "/api/genres", `{"Name":"Warehouse Stock"}`, http.StatusCreated)
+	doRequestWithKey(t, key.Key, "DELETE", "/api/copies/1", "", http.StatusForbidden)
+	doRequestWithKey(t, key.Key, "POST", "/api/authors", `{"Name":"Out Of Scope"}`, http.StatusForbidden)
+	doRequestWithKey(t, key.Key, "GET", "/api/apikeys", "", http.StatusForbidden)
+	var used models.APIKey
+	json.Unmarshal(doRequest(t, "GET", fmt.Sprintf("/api/apikeys/%d", key.ID), "", http.StatusOK), &used)
+	if used.LastUsedAt == nil || used.Key != "" {
+		t.Errorf("api key use was not recorded: %+v", used)
+	}
+
+	//a key alongside a user token is refused, and so is a revoked or made up key
+	req, _ := http.NewRequest("GET", "/api/genres", nil)
+	req.Header.Set("X-API-Key", key.Key)
+	rr := httptest.NewRecorder()
+	serve(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("request with a key and a token returned %v, want %v", rr.Code, http.StatusUnauthorized)
+	}
+	//even an admin key with every scope cannot manage keys, users or roles
+	var admin models.APIKey
+	json.Unmarshal(doRequest(t, "POST", "/api/apikeys", `{"Name":"Everything","Role":"admin","Scopes":["books","authors","publishers","genres","copies","loans","reviews","stats"]}`, http.StatusCreated), &admin)
+	doRequestWithKey(t, admin.Key, "GET", "/api/loans", "", http.StatusOK)
+	doRequestWithKey(t, admin.Key, "POST", "/api/apikeys", `{"Name":"Sneaky","Role":"admin","Scopes":["books"]}`, http.StatusForbidden)
+	doRequestWithKey(t, admin.Key, "DELETE", fmt.Sprintf("/api/apikeys/%d", key.ID), "", http.StatusForbidden)
+	doRequestWithKey(t, admin.Key, "GET", "/api/users", "", http.StatusForbidden)
+	doRequestWithKey(t, admin.Key, "PUT", "/api/users/1/role", `{"Role":"admin"}`, http.StatusForbidden)
+	doRequest(t, "DELETE", fmt.Sprintf("/api/apikeys/%d", admin.ID), "", http.StatusOK)
+
+	doRequest(t, "DELETE", fmt.Sprintf("/api/apikeys/%d", key.ID), "", http.StatusOK)
+	doRequest(t, "DELETE", fmt.Sprintf("/api/apikeys/%d", key.ID), "", http.StatusConflict)
+	doRequestWithKey(t, key.Key, "GET", "/api/genres", "", http.StatusUnauthorized)
+	doRequestWithKey(t, "bk_madeup.nothing", "GET", "/api/genres", "", http.StatusUnauthorized)
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/api/apikeys", `{"Name":"","Role":"staff","Scopes":["books"]}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/apikeys", `{"Name":"POS","Role":"root","Scopes":["books"]}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/apikeys", `{"Name":"POS","Role":"staff"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/apikeys", `{"Name":"POS","Role":"staff","Scopes":["books","users"]}`, http.StatusUnprocessableEntity},
+		{"GET", "/api/apikeys/9999", "", http.StatusNotFound},
+		{"DELETE", "/api/apikeys/9999", "", http.StatusNotFound},
+	} {
+		doRequest(t, c.method, c.url, c.body, c.status)
+	}
+}
+
+func TestReviews(t *testing.T) {
+	doRequest(t, "POST", "/api/auth/register", `{"Username":"critic","Password":"open sesame"}`, http.StatusCreated)
+	var tokens struct{ AccessToken string }
+	json.Unmarshal(doRequestAs(t, "", "POST", "/api/auth/login", `{"Username":"critic","Password":"open sesame"}`, http.StatusOK), &tokens)
+	critic := tokens.AccessToken
+
+	//a book without reviews shows the rating it was given
+	if book := getBook(t, 2); ratingText(book.Rating) != "2.65" || book.RatingCount != 0 {
+		t.Errorf("book rating before reviews is wrong: got %v from %v reviews", ratingText(book.Rating), book.RatingCount)
+	}
+
+	//any signed in user can review a book once
+	var mine models.Review
+	json.Unmarshal(doRequestAs(t, critic, "POST", "/api/book/2/reviews", `{"Rating":3,"Text":" Loved it "}`, http.StatusCreated), &mine)
+	if mine.BookID != 2 || mine.Username != "critic" || mine.Rating != 3 || mine.Text != "Loved it" {
+		t.Errorf("created review is wrong: %+v", mine)
+	}
+	doRequestAs(t, critic, "POST", "/api/book/2/reviews", `{"Rating":1}`, http.StatusConflict)
+	var theirs models.Review
+	json.Unmarshal(doRequest(t, "POST", "/api/book/2/reviews", `{"Rating":2}`, http.StatusCreated), &theirs)
+
+	//the book's rating is the average of its reviews
+	if book := getBook(t, 2); ratingText(book.Rating) != "2.5" || book.RatingCount != 2 {
+		t.Errorf("book rating is wrong: got %v from %v reviews", ratingText(book.Rating), book.RatingCount)
+	}
+	var page []models.Book
+	json.Unmarshal(doRequest(t, "GET", "/api/book?rating_min=2.5&rating_max=2.5", "", http.StatusOK), &page)
+	if len(page) != 2 || page[0].ID != 2 || page[1].ID != 4 {
+		t.Errorf("rating filter is wrong: got %+v", page)
+	}
+	//a rating given to a reviewed book is kept for when it has none
+	doRequest(t, "PATCH", "/api/book/2", `{"Rating":1}`, http.StatusOK)
+	if book := getBook(t, 2); ratingText(book.Rating) != "2.5" {
+		t.Errorf("a given rating replaced the reviews' average: got %v", ratingText(book.Rating))
+	}
+
+	//only the author can edit a review, and the rating follows
+	doRequestAs(t, critic, "PUT", fmt.Sprintf("/api/reviews/%d", mine.ID), `{"Rating":1,"Text":"Less on a second read"}`, http.StatusOK)
+	if book := getBook(t, 2); ratingText(book.Rating) != "1.5" || book.RatingCount != 2 {
+		t.Errorf("book rating after an edit is wrong: got %v from %v reviews", ratingText(book.Rating), book.RatingCount)
+	}
+	doRequestAs(t, critic, "PUT", fmt.Sprintf("/api/reviews/%d", theirs.ID), `{"Rating":1}`, http.StatusForbidden)
+	doRequestAs(t, critic, "DELETE", fmt.Sprintf("/api/reviews/%d", theirs.ID), "", http.StatusForbidden)
+
+	var list []models.Review
+	json.Unmarshal(doRequestAs(t, critic, "GET", "/api/auth/me/reviews", "", http.StatusOK), &list)
+	if len(list) != 1 || list[0].ID != mine.ID || list[0].Text != "Less on a second read" {
+		t.Errorf("own review list is wrong: %+v", list)
+	}
+	json.Unmarshal(doRequestAs(t, "", "GET", "/api/book/2/reviews", "", http.StatusOK), &list)
+	if len(list) != 2 || list[0].ID != theirs.ID {
+		t.Errorf("book review list is wrong: %+v", list)
+	}
+
+	//the author or an admin can remove a review
+	doRequestAs(t, critic, "DELETE", fmt.Sprintf("/api/reviews/%d", mine.ID), "", http.StatusOK)
+	doRequest(t, "DELETE", fmt.Sprintf("/api/reviews/%d", theirs.ID), "", http.StatusOK)
+	if book := getBook(t, 2); ratingText(book.Rating) != "1" || book.RatingCount != 0 {
+		t.Errorf("book rating without reviews is wrong: got %v from %v reviews", ratingText(book.Rating), book.RatingCount)
+	}
+	doRequest(t, "PATCH", "/api/book/2", `{"Rating":2.65}`, http.StatusOK)
+
+	var key models.APIKey
+	json.Unmarshal(doRequest(t, "POST", "/api/apikeys", `{"Name":"Reviewer","Role":"staff","Scopes":["reviews"]}`, http.StatusCreated), &key)
+	doRequestWithKey(t, key.Key, "POST", "/api/book/2/reviews", `{"Rating":2}`, http.StatusForbidden)
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/api/book/2/reviews", `{"Rating":4}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/book/2/reviews", `{"Text":"No rating"}`, http.StatusUnprocessableEntity},
+		{"POST", "/api/book/9999/reviews", `{"Rating":2}`, http.StatusNotFound},
+		{"GET", "/api/book/9999/reviews", "", http.StatusNotFound},
+		{"GET", "/api/reviews/9999", "", http.StatusNotFound},
+		{"PUT", "/api/reviews/9999", `{"Rating":2}`, http.StatusNotFound},
+	} {
+		doRequest(t, c.method, c.url, c.body, c.status)
+	}
+	doRequestAs(t, "", "POST", "/api/book/2/reviews", `{"Rating":2}`, http.StatusUnauthorized)
+}
+
+func TestRatingScale(t *testing.T) {
+	defer middleware.SetRatingScale(store.DefaultRatingScale)
+
+	var review models.Review
+	json.Unmarshal(doRequest(t, "POST", "/api/book/2/reviews", `{"Rating":2}`, http.StatusCreated), &review)
+	url := fmt.Sprintf("/api/reviews/%d", review.ID)
+	defer doRequest(t, "DELETE", url, "", http.StatusOK)
+
+	//half stars out of five
+	if err := middleware.SetRatingScale(store.RatingScale{Min: 1, Max: 5, Step: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	body := doRequest(t, "PUT", url, `{"Rating":4.25}`, http.StatusUnprocessableEntity)
+	if !strings.Contains(string(body), "Rating needs to be in range 1-5 in steps of 0.5") {
+		t.Errorf("rating scale error is wrong: %s", body)
+	}
+	doRequest(t, "PUT", url, `{"Rating":5.5}`, http.StatusUnprocessableEntity)
+	doRequest(t, "PATCH", "/api/book/3", `{"Rating":4.25}`, http.StatusUnprocessableEntity)
+	doRequest(t, "PUT", url, `{"Rating":4.5}`, http.StatusOK)
+	if book := getBook(t, 2); ratingText(book.Rating) != "4.5" {
+		t.Errorf("book rating is wrong: got %v", ratingText(book.Rating))
+	}
+
+	for _, sc := range []store.RatingScale{{Min: 5, Max: 1}, {Min: 1, Max: 5, Step: -1}, {Min: 0, Max: 10, Step: 3}} {
+		if err := middleware.SetRatingScale(sc); err == nil {
+			t.Errorf("rating scale %s was accepted", sc)
+		}
+	}
+
+	//0 is a rating like any other on a scale that starts at it
+	if err := middleware.SetRatingScale(store.RatingScale{Min: 0, Max: 10, Step: 1}); err != nil {
+		t.Fatal(err)
+	}
+	before := ratingText(getBook(t, 3).Rating)
+	doRequest(t, "PATCH", "/api/book/3", `{"Rating":0}`, http.StatusOK)
+	if book := getBook(t, 3); ratingText(book.Rating) != "0" {
+		t.Errorf("a rating of 0 was not kept: got %v", ratingText(book.Rating))
+	}
+	doRequest(t, "PATCH", "/api/book/3", `{"Title":"Dune"}`, http.StatusOK)
+	if book := getBook(t, 3); ratingText(book.Rating) != "0" {
+		t.Errorf("a patch without a rating changed it: got %v", ratingText(book.Rating))
+	}
+	doRequest(t, "PATCH", "/api/book/3", `{"Rating":null}`, http.StatusOK)
+	if book := getBook(t, 3); book.Rating != nil {
+		t.Errorf("a null rating did not clear it: got %v", ratingText(book.Rating))
+	}
+	doRequest(t, "PATCH", "/api/book/3", `{"Rating":`+before+`}`, http.StatusOK)
+
+	setenv(t, "RATING_MIN", "0")
+	setenv(t, "RATING_MAX", "10")
+	setenv(t, "RATING_STEP", "1")
+	if sc, err := middleware.RatingScaleFromEnv(); err != nil || sc != (store.RatingScale{Min: 0, Max: 10, Step: 1}) {
+		t.Errorf("rating scale from env is wrong: got %s, %v", sc, err)
+	}
+	setenv(t, "RATING_STEP", "ten")
+	if _, err := middleware.RatingScaleFromEnv(); err == nil {
+		t.Error("a rating step that is not a number was accepted")
+	}
+
+	//a store records the scale its ratings are on and only rescales from that one
+	ms := store.NewMemoryStore()
+	five := store.RatingScale{Min: 1, Max: 5, Step: 1}
+	if err := ms.UseRatingScale(store.DefaultRatingScale); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.UseRatingScale(five); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("using another scale than the recorded one returned %v, want a conflict", err)
+	}
+	if _, err := ms.RescaleRatings(five, store.DefaultRatingScale); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("rescaling from another scale than the recorded one returned %v, want a conflict", err)
+	}
+	if _, err := ms.RescaleRatings(store.DefaultRatingScale, five); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.UseRatingScale(five); err != nil {
+		t.Errorf("the rescaled to scale was not recorded: %v", err)
+	}
+
+	//books without a legacy rating keep none, and one rescaled to 0 keeps it
+	ms = store.NewMemoryStore()
+	ten := store.RatingScale{Min: 0, Max: 10, Step: 1}
+	zero := 0.0
+	unrated, _ := ms.InsertBook(models.Book{Title: "Unrated", Publish_Date: "2001"})
+	rated, _ := ms.InsertBook(models.Book{Title: "Rated", Publish_Date: "2001", Rating: &zero})
+	if _, err := ms.RescaleRatings(ten, five); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.RescaleRatings(five, ten); err != nil {
+		t.Fatal(err)
+	}
+	if book, _ := ms.GetBook(unrated); book.Rating != nil {
+		t.Errorf("rescaling gave a book without a rating one: %v", ratingText(book.Rating))
+	}
+	if book, _ := ms.GetBook(rated); ratingText(book.Rating) != "0" {
+		t.Errorf("rescaling lost a rating of 0: got %v", ratingText(book.Rating))
+	}
+
+	//rescaling keeps a rating's place on the scale
+	for _, c := range []struct {
+		from, to store.RatingScale
+		rating   float64
+		want     float64
+	}{
+		{store.DefaultRatingScale, store.RatingScale{Min: 1, Max: 5, Step: 0.5}, 2, 3},
+		{store.DefaultRatingScale, store.RatingScale{Min: 1, Max: 5, Step: 1}, 2.5, 4},
+		{store.RatingScale{Min: 0, Max: 10, Step: 1}, store.DefaultRatingScale, 7, 2.4},
+		{store.RatingScale{Min: 1, Max: 5}, store.RatingScale{Min: 0, Max: 10, Step: 1}, 1.4, 1},
+	} {
+		if got := c.from.Convert(c.rating, c.to); got != c.want {
+			t.Errorf("%v on %s is %v on %s, want %v", c.rating, c.from, got, c.to, c.want)
+		}
+	}
+}
+
+func TestPublishDate(t *testing.T) {
+	//each accepted form is stored as precisely as it was given and returned in one canonical form
+	for _, c := range []struct {
+		date, want, precision string
+	}{
+		{"1886", "1886", "year"},
+		{"1886-02", "1886-02", "month"},
+		{"1886-02-15", "1886-02-15", "day"},
+		{"1886-02-15T23:30:00-05:00", "1886-02-15", "day"},
+	} {
+		var res struct{ ID int64 }
+		json.Unmarshal(doRequest(t, "POST", "/api/newbook", `{"Title":"Dated","Publish_Date":"`+c.date+`"}`, http.StatusOK), &res)
+		book := getBook(t, res.ID)
+		if book.Publish_Date != c.want || book.PublishDatePrecision != c.precision {
+			t.Errorf("Publish_Date %q was returned as %q with %q precision, want %q with %q", c.date, book.Publish_Date, book.PublishDatePrecision, c.want, c.precision)
+		}
+
+		//a partial date counts as its first day when filtering
+		var page []models.Book
+		json.Unmarshal(doRequest(t, "GET", "/api/book?title=Dated&published_from=1886-02-15&published_to=1886-02-15", "", http.StatusOK), &page)
+		if matched := len(page) == 1; matched != (c.precision == "day") {
+			t.Errorf("Publish_Date %q matched the 1886-02-15 filter: %v", c.date, matched)
+		}
+		doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", res.ID), "", http.StatusOK)
+	}
+
+	for _, date := range []string{"", "86", "1886-13", "1886-02-30", "15/02/1886", "1886-02-15 12:00"} {
+		doRequest(t, "POST", "/api/newbook", `{"Title":"Dated","Publish_Date":"`+date+`"}`, http.StatusUnprocessableEntity)
+		doRequest(t, "PUT", "/api/book/2", `{"Title":"Dated","Publish_Date":"`+date+`"}`, http.StatusUnprocessableEntity)
+	}
+
+	//patching the date changes its precision
+	doRequest(t, "PATCH", "/api/book/2", `{"Publish_Date":"1998"}`, http.StatusOK)
+	if book := getBook(t, 2); book.Publish_Date != "1998" || book.PublishDatePrecision != "year" {
+		t.Errorf("patched Publish_Date is wrong: %q with %q precision", book.Publish_Date, book.PublishDatePrecision)
+	}
+	doRequest(t, "PATCH", "/api/book/2", `{"Publish_Date":"1998-07-02"}`, http.StatusOK)
+}
+
+func TestTrash(t *testing.T) {
+	var res struct{ ID int64 }
+	json.Unmarshal(doRequest(t, "POST", "/api/newbook", `{"Title":"Mistaken","Author":"Nobody","Publish_Date":"2001"}`, http.StatusOK), &res)
+	book := fmt.Sprintf("/api/book/%d", res.ID)
+	doRequest(t, "PUT", book+"/tags/mistaken", "", http.StatusOK)
+
+	//a deleted book disappears from every read
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", res.ID), "", http.StatusOK)
+	doRequest(t, "GET", book, "", http.StatusNotFound)
+	for _, url := range []string{"/api/book?title=Mistaken", "/api/book/search?q=Mistaken", "/api/book/export?format=json&author=Nobody"} {
+		if body := doRequest(t, "GET", url, "", http.StatusOK); strings.Contains(string(body), "Mistaken") {
+			t.Errorf("GET %s shows a deleted book: %s", url, body)
+		}
+	}
+	if body := doRequest(t, "GET", "/api/tags", "", http.StatusOK); strings.Contains(string(body), "mistaken") {
+		t.Errorf("tags count a deleted book: %s", body)
+	}
+	doRequest(t, "PUT", book, `{"Title":"Mistaken","Publish_Date":"2001"}`, http.StatusNotFound)
+	doRequest(t, "POST", book+"/reviews", `{"Rating":2}`, http.StatusNotFound)
+	doRequest(t, "PUT", book+"/tags/another", "", http.StatusNotFound)
+	doRequest(t, "PUT", book+"/genres/1", "", http.StatusNotFound)
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", res.ID), "", http.StatusNotFound)
+
+	//but stays in the trash
+	var trash []models.Book
+	json.Unmarshal(doRequest(t, "GET", "/api/book/trash?title=Mistaken", "", http.StatusOK), &trash)
+	if len(trash) != 1 || trash[0].ID != res.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("trash is wrong: %+v", trash)
+	}
+	doRequestAs(t, "", "GET", "/api/book/trash", "", http.StatusUnauthorized)
+
+	//until it is restored, with its tags
+	var restored models.Book
+	json.Unmarshal(doRequest(t, "POST", book+"/restore", "", http.StatusOK), &restored)
+	if restored.ID != res.ID || restored.DeletedAt != nil || len(restored.Tags) != 1 || restored.Tags[0] != "mistaken" {
+		t.Errorf("restored book is wrong: %+v", restored)
+	}
+	doRequest(t, "POST", book+"/restore", "", http.StatusNotFound)
+	doRequest(t, "GET", book, "", http.StatusOK)
+
+	//or purged once it has been there longer than the retention period
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", res.ID), "", http.StatusOK)
+	if n, err := middleware.PurgeTrash(time.Hour); err != nil || n != 0 {
+		t.Errorf("purge with an hour's retention removed %d books, %v", n, err)
+	}
+	if n, err := middleware.PurgeTrash(0); err != nil || n == 0 {
+		t.Errorf("purge with no retention removed %d books, %v", n, err)
+	}
+	json.Unmarshal(doRequest(t, "GET", "/api/book/trash", "", http.StatusOK), &trash)
+	if len(trash) != 0 {
+		t.Errorf("trash is not empty after a purge: %+v", trash)
+	}
+	doRequest(t, "POST", book+"/restore", "", http.StatusNotFound)
+
+	setenv(t, "TRASH_RETENTION_DAYS", "7")
+	setenv(t, "TRASH_PURGE_INTERVAL", "10m")
+	if p, err := middleware.TrashPolicyFromEnv(); err != nil || p.Retention != 7*24*time.Hour || p.PurgeInterval != 10*time.Minute {
+		t.Errorf("trash policy from env is wrong: %+v, %v", p, err)
+	}
+	setenv(t, "TRASH_PURGE_INTERVAL", "0s")
+	if _, err := middleware.TrashPolicyFromEnv(); err == nil {
+		t.Error("a purge interval of 0 was accepted")
+	}
+}
+
+func TestLoanPrivacy(t *testing.T) {
+	var user models.User
+	json.Unmarshal(doRequest(t, "POST", "/api/auth/register", `{"Username":"borrower","Password":"open sesame"}`, http.StatusCreated), &user)
+	var tokens struct{ AccessToken string }
+	json.Unmarshal(doRequestAs(t, "", "POST", "/api/auth/login", `{"Username":"borrower","Password":"open sesame"}`, http.StatusOK), &tokens)
+	borrower := tokens.AccessToken
+
+	var res struct{ ID int64 }
+	json.Unmarshal(doRequest(t, "POST", "/api/newbook", `{"Title":"Lent Out","Publish_Date":"2010"}`, http.StatusOK), &res)
+	doRequest(t, "POST", fmt.Sprintf("/api/book/%d/copies", res.ID), `{"Barcode":"LENT-0001"}`, http.StatusCreated)
+	doRequest(t, "POST", fmt.Sprintf("/api/book/%d/copies", res.ID), `{"Barcode":"LENT-0002"}`, http.StatusCreated)
+	doRequest(t, "POST", "/api/loans", fmt.Sprintf(`{"BookID":%d,"UserID":9999}`, res.ID), http.StatusUnprocessableEntity)
+	var loan models.Loan
+	json.Unmarshal(doRequest(t, "POST", "/api/loans", fmt.Sprintf(`{"BookID":%d,"UserID":%d}`, res.ID, user.ID), http.StatusCreated), &loan)
+	defer doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/return", loan.ID), "", http.StatusOK)
+	if loan.UserID != user.ID || loan.Patron != "borrower" {
+		t.Errorf("loan to a user is wrong: %+v", loan)
+	}
+	//a patron that happens to match the username is not the account
+	var other models.Loan
+	json.Unmarshal(doRequest(t, "POST", "/api/loans", fmt.Sprintf(`{"BookID":%d,"Patron":"borrower"}`, res.ID), http.StatusCreated), &other)
+	defer doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/return", other.ID), "", http.StatusOK)
+
+	//readers see only their own loans
+	var mine []models.Loan
+	json.Unmarshal(doRequestAs(t, borrower, "GET", "/api/auth/me/loans", "", http.StatusOK), &mine)
+	if len(mine) != 1 || mine[0].ID != loan.ID {
+		t.Errorf("own loans are wrong: %+v", mine)
+	}
+
+	//and nobody but staff sees anyone else's
+	for _, url := range []string{"/api/loans", fmt.Sprintf("/api/loans/%d", loan.ID), "/api/patrons/borrower/loans", fmt.Sprintf("/api/book/%d/loans", res.ID)} {
+		doRequestAs(t, "", "GET", url, "", http.StatusUnauthorized)
+		doRequestAs(t, borrower, "GET", url, "", http.StatusForbidden)
+		doRequest(t, "GET", url, "", http.StatusOK)
+	}
+	doRequestAs(t, "", "GET", "/api/auth/me/loans", "", http.StatusUnauthorized)
+}
+
+func TestSearchHighlightEscaping(t *testing.T) {
+	//the field is escaped so only the marks are html
+	var res struct{ ID int64 }
+	json.Unmarshal(doRequest(t, "POST", "/api/newbook", `{"Title":"Less <script> & More","Author":"Nobody","Publish_Date":"2001"}`, http.StatusOK), &res)
+	data := string(doRequest(t, "GET", "/api/book/search?q=script", "", http.StatusOK))
+	expected := `"Title":"Less &lt;<mark>script</mark>&gt; &amp; More"`
+	if !strings.Contains(data, expected) {
+		t.Errorf("Handler response %v did not contain %v",
+			data, expected)
+	}
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", res.ID), "", http.StatusOK)
+}
+
+func TestTrashedBookRecords(t *testing.T) {
+	var res struct{ ID int64 }
+	json.Unmarshal(doRequest(t, "POST", "/api/newbook", `{"Title":"Shelved","Publish_Date":"1951","ISBN_13":"9780316769488"}`, http.StatusOK), &res)
+	book := fmt.Sprintf("/api/book/%d", res.ID)
+	var item models.Copy
+	json.Unmarshal(doRequest(t, "POST", book+"/copies", `{"Barcode":"SHELF-0001"}`, http.StatusCreated), &item)
+	var review models.Review
+	json.Unmarshal(doRequest(t, "POST", book+"/reviews", `{"Rating":2}`, http.StatusCreated), &review)
+	var loan models.Loan
+	json.Unmarshal(doRequest(t, "POST", "/api/loans", fmt.Sprintf(`{"BookID":%d,"Patron":"shelver"}`, res.ID), http.StatusCreated), &loan)
+	doRequest(t, "POST", fmt.Sprintf("/api/loans/%d/return", loan.ID), "", http.StatusOK)
+
+	//the copies, reviews and loans of a trashed book go with it
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", res.ID), "", http.StatusOK)
+	doRequest(t, "GET", fmt.Sprintf("/api/copies/%d", item.ID), "", http.StatusNotFound)
+	doRequest(t, "GET", fmt.Sprintf("/api/reviews/%d", review.ID), "", http.StatusNotFound)
+	doRequest(t, "GET", fmt.Sprintf("/api/loans/%d", loan.ID), "", http.StatusNotFound)
+	for _, url := range []string{"/api/loans", "/api/patrons/shelver/loans"} {
+		var loans []models.Loan
+		json.Unmarshal(doRequest(t, "GET", url, "", http.StatusOK), &loans)
+		for _, l := range loans {
+			if l.ID == loan.ID {
+				t.Errorf("GET %s shows the loan of a trashed book: %+v", url, l)
+			}
+		}
+	}
+
+	//its isbn is free for another book
+	var other struct{ ID int64 }
+	json.Unmarshal(doRequest(t, "POST", "/api/newbook", `{"Title":"Shelved Again","Publish_Date":"1991","ISBN_13":"9780316769488"}`, http.StatusOK), &other)
+
+	//so it can only come back once that book is gone
+	doRequest(t, "POST", book+"/restore", "", http.StatusConflict)
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", other.ID), "", http.StatusOK)
+	doRequest(t, "POST", book+"/restore", "", http.StatusOK)
+	doRequest(t, "GET", fmt.Sprintf("/api/copies/%d", item.ID), "", http.StatusOK)
+	doRequest(t, "GET", fmt.Sprintf("/api/reviews/%d", review.ID), "", http.StatusOK)
+	doRequest(t, "GET", fmt.Sprintf("/api/loans/%d", loan.ID), "", http.StatusOK)
+	doRequest(t, "POST", "/api/newbook", `{"Title":"Shelved Again","Publish_Date":"1991","ISBN_13":"9780316769488"}`, http.StatusConflict)
+}
+
+func TestImportISBNs(t *testing.T) {
+	//a dry run finds the isbns the import would be refused, naming each row
+	dune := getBook(t, 3).ISBN_13
+	csvStr := "Title,Publish_Date,ISBN_13\n" +
+		"Dune Again,1965," + dune + "\n" +
+		"Catcher,1951,9780140449136\n" +
+		"Catcher Again,1951,9780140449136\n"
+	expected := `{"dry_run":true,"rows":3,"imported":1,"rejected":2,"ids":[],"errors":[` +
+		`{"line":2,"errors":[{"field":"ISBN_13","message":"ISBN ` + dune + ` is already used by book 3"}]},` +
+		`{"line":4,"errors":[{"field":"ISBN_13","message":"ISBN 9780140449136 is already used on line 3"}]}]}`
+	if data := strings.TrimRight(string(doRequest(t, "POST", "/api/book/import?dry_run=true", csvStr, http.StatusOK)), "\n"); data != expected {
+		t.Errorf("dry run report is wrong: got %v want %v", data, expected)
+	}
+
+	//and the import itself gets the same report, importing the rest
+	var report struct {
+		Imported int
+		Rejected int
+		IDs      []int64
+	}
+	json.Unmarshal(doRequest(t, "POST", "/api/book/import?batch_size=1", csvStr, http.StatusOK), &report)
+	if report.Imported != 1 || report.Rejected != 2 || len(report.IDs) != 1 {
+		t.Fatalf("import report is wrong: %+v", report)
+	}
+	doRequest(t, "DELETE", fmt.Sprintf("/api/deletebook/%d", report.IDs[0]), "", http.StatusOK)
+}
+
+func TestSortFields(t *testing.T) {
+	//the column-backed fields sort, with missing isbns and publishers first
+	for _, c := range []struct {
+		sort string
+		less func(a, b models.Book) bool
+	}{
+		{"ISBN_13", func(a, b models.Book) bool { return a.ISBN_13 < b.ISBN_13 }},
+		{"-RatingCount", func(a, b models.Book) bool { return a.RatingCount > b.RatingCount }},
+		{"PublisherID:desc", func(a, b models.Book) bool { return a.PublisherID > b.PublisherID }},
+	} {
+		var books []models.Book
+		json.Unmarshal(doRequest(t, "GET", "/api/book?sort="+c.sort, "", http.StatusOK), &books)
+		if len(books) < 2 {
+			t.Fatalf("sort=%s returned too few books: %v", c.sort, books)
+		}
+		for i := 1; i < len(books); i++ {
+			if c.less(books[i], books[i-1]) {
+				t.Errorf("sort=%s put book %d before book %d", c.sort, books[i-1].ID, books[i].ID)
+			}
+		}
+	}
+
+	//fields without a column are refused, naming the ones that can be sorted by
+	data := string(doRequest(t, "GET", "/api/book?sort=Tags", "", http.StatusBadRequest))
+	if !strings.Contains(data, "sort must be one of Author, ID, ISBN_13, Publish_Date, Publisher, PublisherID, Rating, RatingCount, Status, Title") {
+		t.Errorf("sort=Tags returned unexpected errors: %v", data)
+	}
+}
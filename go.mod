@@ -3,8 +3,9 @@ module go-postgres
 go 1.16
 
 require (
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/joho/godotenv v1.3.0 // indirect
-	github.com/lib/pq v1.10.2 // indirect
-	github.com/stretchr/testify v1.7.0
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/gorilla/mux v1.8.0
+	github.com/joho/godotenv v1.3.0
+	github.com/lib/pq v1.10.2
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
@@ -0,0 +1,124 @@
+// Package isbn validates, normalizes and converts ISBN-10 and ISBN-13 numbers.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrLength means the number is not 10 or 13 characters once hyphens and spaces are removed
+	ErrLength = errors.New("ISBN must have 10 or 13 digits")
+	// ErrCharacter means the number holds something other than digits (and a final X for ISBN-10)
+	ErrCharacter = errors.New("ISBN may only contain digits, hyphens, spaces and a final X on an ISBN-10")
+	// ErrChecksum means the check digit does not match the rest of the number
+	ErrChecksum = errors.New("ISBN check digit is wrong")
+)
+
+// Clean removes hyphens and spaces and upper cases a trailing x
+func Clean(s string) string {
+	s = strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s))
+	return strings.ToUpper(s)
+}
+
+// Valid10 reports whether s is a cleaned ISBN-10 with a correct check digit
+func Valid10(s string) error {
+	if len(s) != 10 {
+		return ErrLength
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		c := s[i]
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c == 'X' && i == 9:
+			d = 10
+		default:
+			return ErrCharacter
+		}
+		sum += (10 - i) * d
+	}
+	if sum%11 != 0 {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// Valid13 reports whether s is a cleaned ISBN-13 with a correct check digit
+func Valid13(s string) error {
+	if len(s) != 13 {
+		return ErrLength
+	}
+	for i := 0; i < 13; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return ErrCharacter
+		}
+	}
+	if checkDigit13(s[:12]) != s[12] {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// checkDigit13 computes the ISBN-13 check digit for the first 12 digits
+func checkDigit13(s string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(s[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// checkDigit10 computes the ISBN-10 check digit for the first 9 digits
+func checkDigit10(s string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(s[i]-'0')
+	}
+	d := (11 - sum%11) % 11
+	if d == 10 {
+		return 'X'
+	}
+	return byte('0' + d)
+}
+
+// To13 converts a valid cleaned ISBN-10 to its ISBN-13 under the 978 prefix
+func To13(isbn10 string) string {
+	s := "978" + isbn10[:9]
+	return s + string(checkDigit13(s))
+}
+
+// To10 converts a valid cleaned ISBN-13 to ISBN-10. Only 978 numbers have an
+// ISBN-10, so ok is false for anything else.
+func To10(isbn13 string) (string, bool) {
+	if !strings.HasPrefix(isbn13, "978") || len(isbn13) != 13 {
+		return "", false
+	}
+	s := isbn13[3:12]
+	return s + string(checkDigit10(s)), true
+}
+
+// Normalize cleans s, checks it and returns it as an ISBN-13, whichever form it was given in
+func Normalize(s string) (string, error) {
+	s = Clean(s)
+	switch len(s) {
+	case 10:
+		if err := Valid10(s); err != nil {
+			return "", err
+		}
+		return To13(s), nil
+	case 13:
+		if err := Valid13(s); err != nil {
+			return "", err
+		}
+		return s, nil
+	default:
+		return "", ErrLength
+	}
+}
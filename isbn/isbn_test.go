@@ -0,0 +1,35 @@
+package isbn
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"0-7475-3849-2", "9780747538493", nil},
+		{"978-0-7475-3849-3", "9780747538493", nil},
+		{"0 8044 2957 x", "9780804429573", nil},
+		{"0-7475-3849-3", "", ErrChecksum},
+		{"978-0-7475-3849-4", "", ErrChecksum},
+		{"07475A8492", "", ErrCharacter},
+		{"12345", "", ErrLength},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in)
+		if got != tt.want || err != tt.err {
+			t.Errorf("Normalize(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestTo10(t *testing.T) {
+	if got, ok := To10("9780804429573"); !ok || got != "080442957X" {
+		t.Errorf("To10(9780804429573) = %q, %v; want 080442957X, true", got, ok)
+	}
+	//979 numbers have no ISBN-10
+	if _, ok := To10("9791034304977"); ok {
+		t.Errorf("To10(9791034304977) should not convert")
+	}
+}
@@ -1,17 +1,108 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"go-postgres/auth"
+	"go-postgres/middleware"
 	"go-postgres/router"
+	"go-postgres/store"
 	"log"
 	"net/http"
+	"os"
 )
 
 func main() {
+	// pick the book store with -store or BOOK_STORE, postgres unless told otherwise
+	defaultStore := os.Getenv("BOOK_STORE")
+	if defaultStore == "" {
+		defaultStore = "postgres"
+	}
+	storeName := flag.String("store", defaultStore, "book store to use: postgres or memory")
+	migrate := flag.Bool("migrate", true, "apply pending schema migrations at startup (postgres only)")
+	flag.Usage = usage
+	flag.Parse()
+
+	// "migrate ..." manages the schema and exits instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	s, err := store.Open(*storeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pg, ok := s.(*store.PostgresStore); ok && *migrate {
+		if err := migrateUp(pg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	middleware.SetStore(s)
+
+	policy, err := middleware.LoanPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	middleware.SetLoanPolicy(policy)
+
+	scale, err := middleware.RatingScaleFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := middleware.SetRatingScale(scale); err != nil {
+		log.Fatal(err)
+	}
+	// the stored ratings must be on the configured scale, see migrate rescale-ratings
+	if err := s.UseRatingScale(scale); err != nil {
+		log.Fatal(err)
+	}
+
+	trash, err := middleware.TrashPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// books deleted longer ago than the retention period are purged in the background
+	middleware.StartTrashPurge(trash)
+
+	authConfig, fromEnv, err := auth.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !fromEnv {
+		log.Print("JWT_SECRET is not set, signing tokens with a random key that only lasts until the server stops")
+	}
+	middleware.SetAuthConfig(authConfig)
+
+	// accounts that register themselves are readers, so the first admin comes from the environment
+	if name := os.Getenv("ADMIN_USERNAME"); name != "" {
+		if _, err := middleware.EnsureAdmin(name, os.Getenv("ADMIN_PASSWORD")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	r := router.Router()
 	// fs := http.FileServer(http.Dir("build"))
 	// http.Handle("/", fs)
-	fmt.Println("Starting server on the port 8080...")
+	fmt.Printf("Starting server on the port 8080 using the %s store...\n", *storeName)
 
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  %[1]s [flags]                 serve the API on port 8080
+  %[1]s migrate up              apply every pending migration
+  %[1]s migrate down [n]        roll back the last n migrations (default 1)
+  %[1]s migrate status          list migrations and whether they are applied
+  %[1]s migrate rescale-ratings min max [step]
+                               convert review ratings from the min-max scale they
+                               are stored on to the one set by RATING_MIN,
+                               RATING_MAX and RATING_STEP
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
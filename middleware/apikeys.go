@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"encoding/json"
+	"go-postgres/auth"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxAPIKeyNameLength is the longest api key name, in characters
+const maxAPIKeyNameLength = 100
+
+// validateAPIKey checks a key sent to POST, trimming the name, role and scopes
+// and putting the scopes in the order of store.Scopes
+func validateAPIKey(key *models.APIKey) []fieldError {
+	var errs []fieldError
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" || utf8.RuneCountInString(key.Name) > maxAPIKeyNameLength {
+		errs = append(errs, fieldError{Field: "Name", Message: "Name must be 1 to " + strconv.Itoa(maxAPIKeyNameLength) + " characters"})
+	}
+	key.Role = strings.ToLower(strings.TrimSpace(key.Role))
+	if _, ok := rolePermissions[key.Role]; !ok {
+		errs = append(errs, fieldError{Field: "Role", Message: "Role must be one of " + strings.Join(store.Roles, ", ")})
+	}
+	given := make(map[string]bool, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		given[strings.ToLower(strings.TrimSpace(scope))] = true
+	}
+	key.Scopes = nil
+	for _, scope := range store.Scopes {
+		if given[scope] {
+			key.Scopes = append(key.Scopes, scope)
+			delete(given, scope)
+		}
+	}
+	if len(key.Scopes) == 0 || len(given) > 0 {
+		errs = append(errs, fieldError{Field: "Scopes", Message: "Scopes must list one or more of " + strings.Join(store.Scopes, ", ")})
+	}
+	return errs
+}
+
+// hasScope reports whether key was given scope
+func hasScope(key models.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyID reads the id from an /api/apikeys/{id} url
+func apiKeyID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/apikeys/"), 10, 64)
+}
+
+// CreateAPIKey makes a new api key with the given Name, Role and Scopes. The
+// key itself is in the response and cannot be read again.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var key models.APIKey
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateAPIKey(&key); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	secret, prefix, hash := auth.NewAPIKey()
+	key.Prefix = prefix
+	id, err := apiKeys.InsertAPIKey(key, hash)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	key, err = apiKeys.GetAPIKey(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	key.Key = secret
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// GetAPIKeys lists every api key, revoked ones included, newest first
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, err := apiKeys.ListAPIKeys()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.APIKey{}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetAPIKey returns one api key, without the key itself
+func GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := apiKeyID(r)
+	if err != nil {
+		badRequest(w, r, "Api key id must be an integer")
+		return
+	}
+
+	key, err := apiKeys.GetAPIKey(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(key)
+}
+
+// RevokeAPIKey stops an api key from working. The key stays in the list with
+// its RevokedAt set; revoking it again is a 409.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := apiKeyID(r)
+	if err != nil {
+		badRequest(w, r, "Api key id must be an integer")
+		return
+	}
+
+	key, err := apiKeys.RevokeAPIKey(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(key)
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"go-postgres/auth"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"strings"
+)
+
+// authConfig signs and checks access tokens
+var authConfig = auth.DefaultConfig()
+
+// SetAuthConfig changes the key and lifetimes used for tokens
+func SetAuthConfig(cfg auth.Config) {
+	authConfig = cfg
+}
+
+// contextKey keeps the values this package puts in a request context apart from any other package's
+type contextKey int
+
+const (
+	// claimsKey holds the auth.Claims of the signed in caller
+	claimsKey contextKey = iota
+	// apiKeyKey holds the models.APIKey a script called with
+	apiKeyKey
+)
+
+// apiKeyHeader is the header scripts send their api key in
+const apiKeyHeader = "X-API-Key"
+
+// Permission is the least a caller needs to be to use a route
+type Permission int
+
+const (
+	// Anyone may call the route, signed in or not
+	Anyone Permission = iota
+	// Reader needs a signed in user with any role
+	Reader
+	// Staff needs a staff member or an admin
+	Staff
+	// Admin needs an admin
+	Admin
+)
+
+// rolePermissions is what each user role grants
+var rolePermissions = map[string]Permission{
+	store.RoleReader: Reader,
+	store.RoleStaff:  Staff,
+	store.RoleAdmin:  Admin,
+}
+
+// callerClaims returns the claims of the signed in caller, if there is one
+func callerClaims(r *http.Request) (auth.Claims, bool) {
+	claims, ok := r.Context().Value(claimsKey).(auth.Claims)
+	return claims, ok
+}
+
+// callerAPIKey returns the api key the caller sent, if they sent one
+func callerAPIKey(r *http.Request) (models.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyKey).(models.APIKey)
+	return key, ok
+}
+
+// Authenticate identifies the caller of every request that carries a bearer
+// access token or an X-API-Key header and puts their claims or key in the
+// request context. A token or key that is malformed, expired or revoked is
+// rejected whatever the route; a request without either carries on
+// anonymously and is left to Require.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			if header != "" {
+				unauthorized(w, r, "Send either an access token or an api key, not both")
+				return
+			}
+			apiKey, err := apiKeys.UseAPIKey(auth.HashToken(strings.TrimSpace(key)))
+			if errors.Is(err, store.ErrNotFound) {
+				unauthorized(w, r, "The api key is invalid or has been revoked")
+				return
+			}
+			if err != nil {
+				writeStoreError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyKey, apiKey)))
+			return
+		}
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		const scheme = "bearer "
+		if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+			unauthorized(w, r, "Authorization must be a Bearer token")
+			return
+		}
+		claims, err := authConfig.ParseAccessToken(strings.TrimSpace(header[len(scheme):]))
+		if err != nil {
+			unauthorized(w, r, "The access token is invalid or has expired")
+			return
+		}
+		revoked, err := users.AccessTokenRevoked(claims.ID)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if revoked {
+			unauthorized(w, r, "The access token has been revoked")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	})
+}
+
+// Require lets only callers with at least permission p through to next. An
+// api key grants its own role, and only on routes whose scope is one of the
+// key's Scopes; routes with no scope, such as user and api key management,
+// refuse every key. A user's role is read from the store on every request
+// rather than from the token, so a role change applies at once. CORS
+// preflights are always let through as browsers send them without credentials.
+func Require(p Permission, scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p == Anyone || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		if key, ok := callerAPIKey(r); ok {
+			if scope == "" {
+				forbidden(w, r, "Api keys may not "+r.Method+" "+r.URL.Path+"; sign in as a user instead")
+				return
+			}
+			if !hasScope(key, scope) {
+				forbidden(w, r, "The api key "+key.Prefix+" does not have the "+scope+" scope needed to "+r.Method+" "+r.URL.Path)
+				return
+			}
+			if rolePermissions[key.Role] < p {
+				forbidden(w, r, "The api key "+key.Prefix+" with the "+key.Role+" role may not "+r.Method+" "+r.URL.Path)
+				return
+			}
+			next(w, r)
+			return
+		}
+		claims, ok := callerClaims(r)
+		if !ok {
+			unauthorized(w, r, "Sign in to use this endpoint")
+			return
+		}
+		user, err := users.GetUser(claims.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			unauthorized(w, r, "The signed in account no longer exists")
+			return
+		}
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if rolePermissions[user.Role] < p {
+			forbidden(w, r, "The "+user.Role+" role may not "+r.Method+" "+r.URL.Path)
+			return
+		}
+		next(w, r)
+	})
+}
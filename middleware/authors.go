@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// validateCredits checks the Authors of a book, giving any credit without a
+// role the author role
+func validateCredits(credits []models.BookAuthor) []fieldError {
+	var errs []fieldError
+	for i := range credits {
+		c := &credits[i]
+		field := fmt.Sprintf("Authors[%d]", i)
+		if c.ID == 0 && strings.TrimSpace(c.Name) == "" {
+			errs = append(errs, fieldError{Field: field, Message: "an author needs an ID or a Name"})
+		}
+		if c.Role == "" {
+			c.Role = store.RoleAuthor
+		}
+		if !validRole(c.Role) {
+			errs = append(errs, fieldError{Field: field, Message: "Role must be one of " + strings.Join(store.AuthorRoles, ", ")})
+		}
+	}
+	return errs
+}
+
+// validRole reports whether role is one of store.AuthorRoles
+func validRole(role string) bool {
+	for _, r := range store.AuthorRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAuthor checks an author sent to POST or PUT, trimming the name
+func validateAuthor(author *models.Author) []fieldError {
+	author.Name = strings.TrimSpace(author.Name)
+	if author.Name == "" {
+		return []fieldError{{Field: "Name", Message: "Name is required"}}
+	}
+	return nil
+}
+
+// authorID reads the id at the end of an /api/authors/{id} url
+func authorID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/authors/"), 10, 64)
+}
+
+// CreateAuthor adds an author. A name that matches an existing author,
+// ignoring case, spaces and full stops, is a conflict.
+func CreateAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var author models.Author
+	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateAuthor(&author); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	id, err := authors.InsertAuthor(author)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Author added successfully"})
+}
+
+// GetAuthors lists the authors, narrowed to names containing ?name= when given
+func GetAuthors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, err := authors.ListAuthors(strings.TrimSpace(r.URL.Query().Get("name")))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.Author{}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetAuthor returns one author
+func GetAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := authorID(r)
+	if err != nil {
+		badRequest(w, r, "Author id must be an integer")
+		return
+	}
+
+	author, err := authors.GetAuthor(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(author)
+}
+
+// UpdateAuthor renames an author. Books crediting them pick up the new name.
+func UpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := authorID(r)
+	if err != nil {
+		badRequest(w, r, "Author id must be an integer")
+		return
+	}
+	var author models.Author
+	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateAuthor(&author); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	if _, err := authors.UpdateAuthor(id, author); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Author updated successfully"})
+}
+
+// DeleteAuthor removes an author who is not credited on any book
+func DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := authorID(r)
+	if err != nil {
+		badRequest(w, r, "Author id must be an integer")
+		return
+	}
+
+	if _, err := authors.DeleteAuthor(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Author deleted successfully"})
+}
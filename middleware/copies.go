@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"encoding/json"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateCopy checks a copy sent to POST or PUT, trimming its text fields and
+// defaulting the condition to good
+func validateCopy(item *models.Copy) []fieldError {
+	var errs []fieldError
+	item.Barcode = strings.TrimSpace(item.Barcode)
+	if item.Barcode == "" {
+		errs = append(errs, fieldError{Field: "Barcode", Message: "Barcode is required"})
+	}
+	item.Condition = strings.ToLower(strings.TrimSpace(item.Condition))
+	if item.Condition == "" {
+		item.Condition = "good"
+	}
+	valid := false
+	for _, c := range store.Conditions {
+		valid = valid || c == item.Condition
+	}
+	if !valid {
+		errs = append(errs, fieldError{Field: "Condition", Message: "Condition must be one of " + strings.Join(store.Conditions, ", ")})
+	}
+	item.AcquiredOn = strings.TrimSpace(item.AcquiredOn)
+	if item.AcquiredOn != "" {
+		if _, err := time.Parse("2006-01-02", item.AcquiredOn); err != nil {
+			errs = append(errs, fieldError{Field: "AcquiredOn", Message: "AcquiredOn must be a date in the form YYYY-MM-DD"})
+		}
+	}
+	item.Location = strings.TrimSpace(item.Location)
+	return errs
+}
+
+// copyID reads the id from an /api/copies/{id} url
+func copyID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/copies/"), 10, 64)
+}
+
+// CreateCopy adds a copy to the book in the url
+func CreateCopy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	bookID, err := bookPathID(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+	var item models.Copy
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateCopy(&item); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+	item.BookID = bookID
+
+	id, err := copies.InsertCopy(item)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Copy added successfully"})
+}
+
+// GetBookCopies lists the copies of one book by barcode
+func GetBookCopies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	bookID, err := bookPathID(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+
+	//an unknown book is a 404 rather than an empty list
+	if _, err := books.GetBook(bookID); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	list, err := copies.ListCopies(bookID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.Copy{}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetCopy returns one copy
+func GetCopy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := copyID(r)
+	if err != nil {
+		badRequest(w, r, "Copy id must be an integer")
+		return
+	}
+
+	item, err := copies.GetCopy(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(item)
+}
+
+// UpdateCopy changes a copy's barcode, condition, acquisition date or location
+func UpdateCopy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := copyID(r)
+	if err != nil {
+		badRequest(w, r, "Copy id must be an integer")
+		return
+	}
+	var item models.Copy
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateCopy(&item); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	if _, err := copies.UpdateCopy(id, item); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Copy updated successfully"})
+}
+
+// DeleteCopy removes a copy that is not on loan from the inventory
+func DeleteCopy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := copyID(r)
+	if err != nil {
+		badRequest(w, r, "Copy id must be an integer")
+		return
+	}
+
+	if _, err := copies.DeleteCopy(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Copy deleted successfully"})
+}
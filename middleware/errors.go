@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"go-postgres/store"
+	"log"
+	"net/http"
+)
+
+// problem types clients can branch on, relative to the api root
+const (
+	problemInvalidRequest = "/problems/invalid-request"
+	problemValidation     = "/problems/validation-error"
+	problemConflict       = "/problems/conflict"
+	problemMediaType      = "/problems/unsupported-media-type"
+	problemNotFound       = "/problems/not-found"
+	problemUnauthorized   = "/problems/unauthorized"
+	problemForbidden      = "/problems/forbidden"
+	problemInternal       = "/problems/internal-error"
+)
+
+// fieldError describes one field that failed validation
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// problem is an RFC 7807 problem details document, sent as application/problem+json
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// writeProblem sends p back to the client, filling in the instance from the request
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// badRequest reports a request that could not be understood at all
+func badRequest(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, problem{
+		Type:   problemInvalidRequest,
+		Title:  "Invalid request",
+		Status: http.StatusBadRequest,
+		Detail: detail,
+	})
+}
+
+// invalidQuery reports query string parameters that could not be understood
+func invalidQuery(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeProblem(w, r, problem{
+		Type:   problemInvalidRequest,
+		Title:  "Invalid request",
+		Status: http.StatusBadRequest,
+		Detail: "One or more query parameters are invalid",
+		Errors: errs,
+	})
+}
+
+// validationFailed reports a well formed book whose fields break the rules
+func validationFailed(w http.ResponseWriter, r *http.Request, errs []fieldError) {
+	writeProblem(w, r, problem{
+		Type:   problemValidation,
+		Title:  "Validation failed",
+		Status: http.StatusUnprocessableEntity,
+		Detail: "One or more fields are invalid",
+		Errors: errs,
+	})
+}
+
+// invalidBook reports a book that could not be built from the request, with detail saying why
+func invalidBook(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, problem{
+		Type:   problemValidation,
+		Title:  "Validation failed",
+		Status: http.StatusUnprocessableEntity,
+		Detail: detail,
+	})
+}
+
+// unauthorized reports a request that needs a valid access token, with detail saying what was wrong
+func unauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	writeProblem(w, r, problem{
+		Type:   problemUnauthorized,
+		Title:  "Unauthorized",
+		Status: http.StatusUnauthorized,
+		Detail: detail,
+	})
+}
+
+// forbidden reports a signed in caller whose role does not allow the request
+func forbidden(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, problem{
+		Type:   problemForbidden,
+		Title:  "Forbidden",
+		Status: http.StatusForbidden,
+		Detail: detail,
+	})
+}
+
+// writeStoreError picks the problem for an error returned by the book store.
+// Anything the store does not recognise is logged and reported as a 500 without
+// leaking the database error to the client.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeProblem(w, r, problem{
+			Type:   problemNotFound,
+			Title:  "Not found",
+			Status: http.StatusNotFound,
+			Detail: err.Error(),
+		})
+	case errors.Is(err, store.ErrConflict):
+		writeProblem(w, r, problem{
+			Type:   problemConflict,
+			Title:  "Conflict",
+			Status: http.StatusConflict,
+			Detail: err.Error(),
+		})
+	case errors.Is(err, store.ErrInvalid):
+		writeProblem(w, r, problem{
+			Type:   problemValidation,
+			Title:  "Validation failed",
+			Status: http.StatusUnprocessableEntity,
+			Detail: err.Error(),
+		})
+	default:
+		log.Printf("book store error: %v", err)
+		writeProblem(w, r, problem{
+			Type:   problemInternal,
+			Title:  "Internal server error",
+			Status: http.StatusInternalServerError,
+			Detail: "Unable to complete the request",
+		})
+	}
+}
+
+// NotFound answers requests for routes that do not exist
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problem{
+		Type:   problemNotFound,
+		Title:  "Not found",
+		Status: http.StatusNotFound,
+		Detail: "No route matches " + r.Method + " " + r.URL.Path,
+	})
+}
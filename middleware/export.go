@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"go-postgres/models"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportFlushEvery is how many books are written between flushes to the client
+const exportFlushEvery = 500
+
+// exportFormats maps the format parameter to the content type sent back
+var exportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json",
+}
+
+// exportHeader is the csv header, using the field names the import accepts
+var exportHeader = []string{"ID", "Title", "Author", "Publisher", "Publish_Date", "Rating", "Status", "ISBN_10", "ISBN_13"}
+
+// bookWriter writes books one at a time in an export format
+type bookWriter interface {
+	begin() error
+	write(book models.Book) error
+	end() error
+}
+
+// ExportBooks streams every book matching the listing filters (and sort) to the
+// client as csv, ndjson or a json array, chosen with ?format= or the Accept
+// header. Books go from the store to the response as they are read, so the
+// catalog is never held in memory.
+func ExportBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := r.URL.Query()
+	filter, errs := parseBookFilter(q)
+	srt, ok := parseSort(q.Get("sort"))
+	if !ok {
+		errs = append(errs, sortError)
+	}
+	format := exportFormat(r)
+	if _, ok := exportFormats[format]; !ok {
+		errs = append(errs, fieldError{Field: "format", Message: "format must be csv, ndjson or json"})
+	}
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	var bw bookWriter
+	switch format {
+	case "csv":
+		bw = &csvBookWriter{w: csv.NewWriter(w)}
+	case "ndjson":
+		bw = &jsonBookWriter{w: w, enc: json.NewEncoder(w)}
+	default:
+		bw = &jsonBookWriter{w: w, enc: json.NewEncoder(w), array: true}
+	}
+
+	w.Header().Set("Content-Type", exportFormats[format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "books." + format}))
+
+	flusher, _ := w.(http.Flusher)
+	written := 0
+	started := false
+	err := books.EachBook(filter, srt, func(book models.Book) error {
+		if !started {
+			started = true
+			if err := bw.begin(); err != nil {
+				return err
+			}
+		}
+		if err := bw.write(book); err != nil {
+			return err
+		}
+		written++
+		if flusher != nil && written%exportFlushEvery == 0 {
+			if cw, ok := bw.(*csvBookWriter); ok {
+				cw.w.Flush()
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	// nothing has been sent yet, so a failure can still be reported properly
+	if err != nil && !started {
+		w.Header().Del("Content-Disposition")
+		writeStoreError(w, r, err)
+		return
+	}
+	if err != nil {
+		// the status line is already gone, all we can do is cut the stream short
+		log.Printf("export stopped after %d books: %v", written, err)
+		return
+	}
+	if !started {
+		if err := bw.begin(); err != nil {
+			return
+		}
+	}
+	bw.end()
+}
+
+// exportFormat picks the format from ?format= or else from the Accept header, defaulting to csv
+func exportFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return strings.ToLower(f)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+		switch mediaType {
+		case "application/x-ndjson":
+			return "ndjson"
+		case "application/json":
+			return "json"
+		case "text/csv":
+			return "csv"
+		}
+	}
+	return "csv"
+}
+
+// csvBookWriter writes a header row then one row per book
+type csvBookWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvBookWriter) begin() error {
+	return c.w.Write(exportHeader)
+}
+
+func (c *csvBookWriter) write(book models.Book) error {
+	//a book without a rating has an empty Rating cell
+	var rating string
+	if book.Rating != nil {
+		rating = strconv.FormatFloat(*book.Rating, 'g', -1, 64)
+	}
+	return c.w.Write([]string{
+		strconv.FormatInt(book.ID, 10),
+		book.Title,
+		book.Author,
+		book.Publisher,
+		book.Publish_Date,
+		rating,
+		strconv.FormatBool(book.Status),
+		book.ISBN_10,
+		book.ISBN_13,
+	})
+}
+
+func (c *csvBookWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonBookWriter writes one json object per line, wrapped in [ ] when array is set
+type jsonBookWriter struct {
+	w     http.ResponseWriter
+	enc   *json.Encoder
+	array bool
+	count int
+}
+
+func (j *jsonBookWriter) begin() error {
+	if j.array {
+		_, err := j.w.Write([]byte("["))
+		return err
+	}
+	return nil
+}
+
+func (j *jsonBookWriter) write(book models.Book) error {
+	if j.array && j.count > 0 {
+		if _, err := j.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	j.count++
+	return j.enc.Encode(book)
+}
+
+func (j *jsonBookWriter) end() error {
+	if j.array {
+		_, err := j.w.Write([]byte("]\n"))
+		return err
+	}
+	return nil
+}
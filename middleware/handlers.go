@@ -1,20 +1,20 @@
 package middleware
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/json" // package to encode and decode the json into struct and vice versa
+	"errors"
 	"fmt"
+	"go-postgres/isbn"
 	"go-postgres/models" // models package where User schema is defined
+	"go-postgres/store"  // book store implementations (postgres and in-memory)
 	"log"
+	"mime"
 	"net/http" // used to access the request and response object of the api
 	"os"       // used to read the environment variable
+	"reflect"
 	"strconv"  // package used to covert string into int type
 	"strings"
-
-	// used to get the params from the route
-
-	"github.com/joho/godotenv" // package used to read the .env file
-	_ "github.com/lib/pq"      // postgres golang driver
 )
 
 // response format
@@ -23,35 +23,124 @@ type response struct {
 	Message string `json:"message,omitempty"`
 }
 
-// create connection with postgres db
-func createConnection() *sql.DB {
-	// load .env file
-	err := godotenv.Load(".env")
+// books, authors, publishers, taxonomy, loans, copies, users, apiKeys and reviews are the stores the handlers read from and write to
+var (
+	books      store.BookStore
+	authors    store.AuthorStore
+	publishers store.PublisherStore
+	taxonomy   store.TaxonomyStore
+	loans      store.LoanStore
+	copies     store.CopyStore
+	users      store.UserStore
+	apiKeys    store.APIKeyStore
+	reviews    store.ReviewStore
+)
+
+// SetStore sets the store used by the handlers, called once at startup
+func SetStore(s store.Store) {
+	books = s
+	authors = s
+	publishers = s
+	taxonomy = s
+	loans = s
+	copies = s
+	users = s
+	apiKeys = s
+	reviews = s
+}
 
-	if err != nil {
-		log.Fatalf("Error loading .env file")
+// PrepForTesting empties the book store and resets the id sequence. If no store
+// was set it uses the one named by BOOK_STORE, defaulting to the in-memory store
+// so the endpoint tests can run without a database.
+func PrepForTesting() {
+	if books == nil {
+		name := os.Getenv("BOOK_STORE")
+		if name == "" {
+			name = "memory"
+		}
+		s, err := store.Open(name)
+		if err != nil {
+			log.Fatalf("Unable to open the book store. %v", err)
+		}
+		SetStore(s)
+	}
+	if err := books.Reset(); err != nil {
+		log.Fatalf("Unable to reset the book store. %v", err)
 	}
+}
 
-	// Open the connection
-	db, err := sql.Open("postgres", os.Getenv("POSTGRES_URL"))
+// poolStats is the json form of sql.DBStats, durations are in milliseconds
+type poolStats struct {
+	MaxOpenConnections int   `json:"maxOpenConnections"`
+	OpenConnections    int   `json:"openConnections"`
+	InUse              int   `json:"inUse"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"waitCount"`
+	WaitDurationMs     int64 `json:"waitDurationMs"`
+	MaxIdleClosed      int64 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64 `json:"maxLifetimeClosed"`
+}
 
-	if err != nil {
-		panic(err)
+// validateBook checks the rules every new or updated book must follow and
+// returns one fieldError per broken rule. ISBNs are normalized in place.
+func validateBook(book *models.Book) []fieldError {
+	errs := normalizeISBN(book)
+	errs = append(errs, validateCredits(book.Authors)...)
+	if book.PublisherID < 0 {
+		errs = append(errs, fieldError{Field: "PublisherID", Message: "PublisherID must be a publisher id"})
 	}
 
-	// check the connection
-	err = db.Ping()
+	//a rating given with the book is shown until it has reviews, so it must be on the scale reviews use
+	if book.Rating != nil && !ratingScale.Allows(*book.Rating) {
+		errs = append(errs, fieldError{Field: "Rating", Message: "Rating needs to be in range " + ratingScale.String()})
+	}
 
-	if err != nil {
-		panic(err)
+	//check the publish date is a date, a month or a year, see store.ParsePublishDate
+	if _, _, err := store.ParsePublishDate(book.Publish_Date); err != nil {
+		errs = append(errs, fieldError{Field: "Publish_Date", Message: "Publish_Date must be a date in the form YYYY-MM-DD, YYYY-MM or YYYY, or an RFC 3339 timestamp"})
 	}
 
-	//fmt.Println("Successfully connected!")
-	// return the connection
-	return db
+	return errs
 }
-func PrepForTesting() {
-	clearDB()
+
+// normalizeISBN checks whichever of ISBN_10 and ISBN_13 were given and fills in
+// both: ISBN_13 always, ISBN_10 when the number has one. When both are given
+// they must be the same number.
+func normalizeISBN(book *models.Book) []fieldError {
+	var errs []fieldError
+	var from10, from13 string
+	if book.ISBN_10 != "" {
+		s := isbn.Clean(book.ISBN_10)
+		err := isbn.Valid10(s)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "ISBN_10", Message: err.Error()})
+		} else {
+			from10 = isbn.To13(s)
+		}
+	}
+	if book.ISBN_13 != "" {
+		s := isbn.Clean(book.ISBN_13)
+		err := isbn.Valid13(s)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "ISBN_13", Message: err.Error()})
+		} else {
+			from13 = s
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	if from10 != "" && from13 != "" && from10 != from13 {
+		return []fieldError{{Field: "ISBN_10", Message: "ISBN_10 and ISBN_13 are different books"}}
+	}
+
+	book.ISBN_13 = from13
+	if book.ISBN_13 == "" {
+		book.ISBN_13 = from10
+	}
+	book.ISBN_10, _ = isbn.To10(book.ISBN_13)
+	return nil
 }
 
 //Creates a new book object and adds to postgres db
@@ -62,7 +151,7 @@ func CreateBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 	//create new book model
 	var book models.Book
@@ -72,32 +161,61 @@ func CreateBook(w http.ResponseWriter, r *http.Request) {
 
 	//check if any errors
 	if err != nil {
-		log.Fatalf("Unable to decode the request body.  %v", err)
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
 	}
 
-	//call the insert book function and relay success message
-	insertID := insertBook(book)
-	message := "Book added successfully"
+	//check the book follows the rules before touching the store
+	if errs := validateBook(&book); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
 
-	//check to see if there was error (Was not sure what/how to handle this the right way so I just checked to see if error id was 400 and if so display message to http)
-	if insertID == -1 {
-		message = "Rating needs to be in range 1-3"
+	//call the insert book function and relay success message
+	insertID, err := books.InsertBook(book)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
 	}
+
 	//create response object
 	res := response{
 		ID:      insertID,
-		Message: message,
+		Message: "Book added successfully",
 	}
 
 	// send the response
 	json.NewEncoder(w).Encode(res)
 }
 
+// GetBookByISBN returns the book with the given ISBN, which may be sent as an
+// ISBN-10 or ISBN-13 with or without hyphens
+func GetBookByISBN(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	isbn13, err := isbn.Normalize(strings.TrimPrefix(r.URL.Path, "/api/book/isbn/"))
+	if err != nil {
+		badRequest(w, r, "Not a valid ISBN. "+err.Error())
+		return
+	}
+
+	book, err := books.GetBookByISBN(isbn13)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(book)
+}
+
 //Get Book will return book object based on ID
 func GetBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
 	w.Header().Set("Access-Control-Allow-Methods", "GET")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	stringid := strings.ReplaceAll(r.URL.String(), "/api/book/", "")
@@ -107,35 +225,100 @@ func GetBook(w http.ResponseWriter, r *http.Request) {
 
 	//check if any errors and display message
 	if err != nil {
-		log.Fatalf("Unable to convert the string into int.  %v", err)
+		badRequest(w, r, "Book id must be an integer")
+		return
 	}
 
 	// call the getbookbyID function to get user object and any errors
-	book, err := getBookByID(int64(id))
+	book, err := books.GetBook(int64(id))
 
 	//check if any errors and display error message
 	if err != nil {
-		log.Fatalf("Unable to get user. %v", err)
+		writeStoreError(w, r, err)
+		return
 	}
 
 	// send the response
 	json.NewEncoder(w).Encode(book)
 }
 
-// GetAllBooks will return all the books from database
+// GetAllBooks will return one page of books from database, narrowed by any filters in the query string.
+// The total number of matches is sent in X-Total-Count and the neighbouring pages in the Link header.
 func GetAllBooks(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	//call get all books method to get all book objects and errors
-	books, err := getAllBooks()
+	w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count")
+	//read the filters, sort and page from the query string
+	q, errs := parseBookQuery(r.URL.Query())
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	listBooks(w, r, q)
+}
+
+// listBooks sends the page of books q asks for, with the X-Total-Count and Link headers
+func listBooks(w http.ResponseWriter, r *http.Request, q store.BookQuery) {
+	//ask for one extra book to find out if there is another page
+	limit := q.Limit
+	q.Limit++
+
+	//call list books method to get the matching book objects and errors
+	page, total, err := books.ListBooks(q)
 
 	//if there are any errors, display error message
 	if err != nil {
-		log.Fatalf("Unable to get all user. %v", err)
+		writeStoreError(w, r, err)
+		return
+	}
+
+	more := len(page) > limit
+	if more {
+		if q.Before != nil {
+			page = page[1:]
+		} else {
+			page = page[:limit]
+		}
+	}
+	q.Limit = limit
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if links := pageLinks(r, q, page, total, more); links != "" {
+		w.Header().Set("Link", links)
+	}
+
+	// send the page of books as response
+	json.NewEncoder(w).Encode(page)
+}
+
+// SearchBooks runs a full-text search over title, author and publisher.
+// q takes websearch syntax: words, "quoted phrases", OR and -excluded words.
+func SearchBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	//read the search text and page from the query string
+	text, limit, offset, errs := parseSearchQuery(r.URL.Query())
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	matches, err := books.SearchBooks(text, limit, offset)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
 	}
 
-	// send all the books as response
-	json.NewEncoder(w).Encode(books)
+	//always send an array, even when nothing matched
+	if matches == nil {
+		matches = []models.BookMatch{}
+	}
+	//keep the <mark> tags readable instead of \u003c escapes
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(matches)
 }
 
 //function that allows editing/updating of book object information
@@ -144,13 +327,14 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "PUT")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 	stringid := strings.ReplaceAll(r.URL.String(), "/api/book/", "")
 
 	id, err := strconv.Atoi(stringid)
 	if err != nil {
-		log.Fatalf("Unable to convert the string into int.  %v", err)
+		badRequest(w, r, "Book id must be an integer")
+		return
 	}
 	//create new book model
 	var book models.Book
@@ -159,20 +343,26 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 
 	//check if any errors and if so display error message
 	if err != nil {
-		log.Fatalf("Unable to decode the request body.  %v", err)
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	//check the book follows the rules before touching the store
+	if errs := validateBook(&book); errs != nil {
+		validationFailed(w, r, errs)
+		return
 	}
+
 	//call update book function that will update book object corresponding to id and new book details
-	updatedRows := updateBook(int64(id), book)
+	updatedRows, err := books.UpdateBook(int64(id), book)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 
 	//set message to success message and show how many rows were affected
 	msg := fmt.Sprintf("User updated successfully. Total rows/record affected %v ", updatedRows)
 
-	//check to see if there was error (Was not sure what/how to handle this the right way so I just checked to see if error id was 400 and if so display message to http)
-	if updatedRows == -1 {
-		msg = "Rating needs to be in range 1-3"
-	}
-
-	//create response object and set error id and message
+	//create response object and set id and message
 	res := response{
 		ID:      int64(id),
 		Message: msg,
@@ -181,227 +371,192 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-//Delete book will delete book object from database given book id
-func DeleteBook(w http.ResponseWriter, r *http.Request) {
-
-	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
+// PatchBook applies a partial update to a book. The body is a JSON Merge Patch
+// (application/merge-patch+json or application/json) or a JSON Patch
+// (application/json-patch+json). The patched book must pass the same validation
+// as a new one and is sent back in full.
+func PatchBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
-	stringid := strings.ReplaceAll(r.URL.String(), "/api/deletebook/", "")
-
-	id, err := strconv.Atoi(stringid)
-
-	//check if any errors and return message
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/book/"), 10, 64)
 	if err != nil {
-		log.Fatalf("Unable to convert the string into int.  %v", err)
+		badRequest(w, r, "Book id must be an integer")
+		return
 	}
 
-	// call the deletebook function
-	deletedRows := deleteBook(int64(id))
-
-	// format the message string
-	msg := fmt.Sprintf("User updated successfully. Total rows/record affected %v", deletedRows)
-
-	// format the reponse message
-	res := response{
-		ID:      int64(id),
-		Message: msg,
+	//decode the patch according to its content type
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var merge interface{}
+	var ops []patchOp
+	switch mediaType {
+	case "application/merge-patch+json", "application/json", "":
+		err = json.NewDecoder(r.Body).Decode(&merge)
+	case "application/json-patch+json":
+		err = json.NewDecoder(r.Body).Decode(&ops)
+	default:
+		writeProblem(w, r, problem{
+			Type:   problemMediaType,
+			Title:  "Unsupported media type",
+			Status: http.StatusUnsupportedMediaType,
+			Detail: "PATCH accepts application/merge-patch+json or application/json-patch+json",
+		})
+		return
 	}
-
-	//send the response
-	json.NewEncoder(w).Encode(res)
-}
-
-//------------------------- Implementation functions ----------------
-//insert book function takes in book model and returns id of book created/inserted
-func insertBook(book models.Book) int64 {
-	//create connection
-	db := createConnection()
-	//close the db connection
-	defer db.Close()
-	//create sql query statement that inserts book into postgres db based on user input data
-	sqlStatement := `INSERT INTO book (Title, Author, Publisher, Publish_Date, Rating, Status) VALUES ($1, $2, $3, $4, $5, $6) RETURNING ID`
-	//create id variable
-	var id int64
-	//check to see if rating is within range, if not set return error id of -1 (that way we never actually would return this normally)
-	if book.Rating < 1 || book.Rating > 3 {
-		return -1
-	}
-	//query rows based on user input and store into err
-	err := db.QueryRow(sqlStatement, book.Title, book.Author, book.Publisher, book.Publish_Date, book.Rating, book.Status).Scan(&id)
-	//if there are any errors, return error statement
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
-	}
-	//success message
-	//fmt.Println("Inserted a single record ", id)
-
-	//return the inserted id
-	return id
-}
-
-// get one book from the DB by its id
-func getBookByID(id int64) (models.Book, error) {
-	// create the postgres db connection
-	db := createConnection()
-
-	// close the db connection
-	defer db.Close()
-
-	// create a new book model
-	var book models.Book
-
-	// create the select sql query
-	sqlStatement := `SELECT * FROM book WHERE id=$1`
-
-	// execute the sql statement
-	row := db.QueryRow(sqlStatement, id)
-
-	// unmarshal the row object to book
-	err := row.Scan(&book.ID, &book.Title, &book.Author, &book.Publisher, &book.Publish_Date, &book.Rating, &book.Status)
-
-	switch err {
-	case sql.ErrNoRows:
-		//fmt.Println("No rows were returned!")
-		return book, nil
-	case nil:
-		return book, nil
-	default:
-		log.Fatalf("Unable to scan the row. %v", err)
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
 	}
 
-	return book, err
-}
-
-//get every book from database
-func getAllBooks() ([]models.Book, error) {
-	// create the postgres db connection
-	db := createConnection()
-
-	// close the db connection
-	defer db.Close()
-
-	var books []models.Book
-
-	// create the select sql query
-	sqlStatement := `SELECT * FROM book`
-
-	// execute the sql statement
-	rows, err := db.Query(sqlStatement)
-
+	//load the current book and turn it into a generic json document
+	current, err := books.GetBook(id)
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+		writeStoreError(w, r, err)
+		return
 	}
-
-	// close the statement
-	defer rows.Close()
-
-	// iterate over the rows
-	for rows.Next() {
-		var book models.Book
-
-		// unmarshal the row object to book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.Publisher, &book.Publish_Date, &book.Rating, &book.Status)
-
+	//the Rating a patch works on is the legacy rating, not the reviews' average the book shows
+	current.Rating = current.LegacyRating
+	var doc interface{}
+	b, _ := json.Marshal(current)
+	json.Unmarshal(b, &doc)
+
+	//apply the patch
+	if mediaType == "application/json-patch+json" {
+		doc, err = jsonPatch(doc, ops)
+		if errors.Is(err, errPatchTestFailed) {
+			writeProblem(w, r, problem{
+				Type:   problemConflict,
+				Title:  "Conflict",
+				Status: http.StatusConflict,
+				Detail: err.Error(),
+			})
+			return
+		}
 		if err != nil {
-			log.Fatalf("Unable to scan the row. %v", err)
+			invalidBook(w, r, "Unable to apply the patch. "+err.Error())
+			return
 		}
-
-		//append the book to the books list
-		books = append(books, book)
-
+	} else {
+		doc = mergePatch(doc, merge)
 	}
 
-	return books, err
-}
-
-// update book from the DB
-func updateBook(id int64, book models.Book) int64 {
-
-	// create the postgres db connection
-	db := createConnection()
-
-	// close the db connection
-	defer db.Close()
-
-	// create the update sql query
-	sqlStatement := `UPDATE book SET Title=$2, Author=$3, Publisher=$4, Publish_Date =$5, Rating = $6, Status = $7 WHERE id=$1`
+	//turn the patched document back into a book, rejecting fields a book does not have
+	var book models.Book
+	b, _ = json.Marshal(doc)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&book); err != nil {
+		invalidBook(w, r, "The patched document is not a valid book. "+err.Error())
+		return
+	}
 
-	//check to see if rating is within correct range and return -1 as error id if out of range
-	if book.Rating < 1 || book.Rating > 3 {
-		return -1
+	//the current book carries both isbn forms, so when the patch changes only
+	//one of them the other is stale and is recomputed from the changed one
+	if book.ISBN_13 != current.ISBN_13 && book.ISBN_10 == current.ISBN_10 {
+		book.ISBN_10 = ""
+	} else if book.ISBN_10 != current.ISBN_10 && book.ISBN_13 == current.ISBN_13 {
+		book.ISBN_13 = ""
+	}
+	//likewise a patch to the Author string alone replaces the credits with its names
+	if book.Author != current.Author && reflect.DeepEqual(book.Authors, current.Authors) {
+		book.Authors = nil
+	}
+	//and a patch to the Publisher name alone links the book to that publisher
+	if book.Publisher != current.Publisher && book.PublisherID == current.PublisherID {
+		book.PublisherID = 0
 	}
-	// execute the sql statement
-	res, err := db.Exec(sqlStatement, id, book.Title, book.Author, book.Publisher, book.Publish_Date, book.Rating, book.Status)
 
-	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+	//the id comes from the url and cannot be patched
+	errs := validateBook(&book)
+	if book.ID != id {
+		errs = append(errs, fieldError{Field: "ID", Message: "ID cannot be changed"})
+	}
+	if errs != nil {
+		validationFailed(w, r, errs)
+		return
 	}
 
-	//check how many rows affected
-	rowsAffected, err := res.RowsAffected()
+	if _, err := books.UpdateBook(id, book); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
 
+	//send back the book as it is now stored
+	updated, err := books.GetBook(id)
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v", err)
+		writeStoreError(w, r, err)
+		return
 	}
-
-	//fmt.Printf("Total rows/record affected %v", rowsAffected)
-
-	return rowsAffected
+	json.NewEncoder(w).Encode(updated)
 }
 
-// delete book in the DB by id
-func deleteBook(id int64) int64 {
-
-	// create the postgres db connection
-	db := createConnection()
+//Delete book will delete book object from database given book id
+func DeleteBook(w http.ResponseWriter, r *http.Request) {
 
-	// close the db connection
-	defer db.Close()
+	w.Header().Set("Context-Type", "application/x-www-form-urlencoded")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
-	// create the delete sql query
-	sqlStatement := `DELETE FROM book WHERE id=$1`
+	stringid := strings.ReplaceAll(r.URL.String(), "/api/deletebook/", "")
 
-	// execute the sql statement
-	res, err := db.Exec(sqlStatement, id)
+	id, err := strconv.Atoi(stringid)
 
+	//check if any errors and return message
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+		badRequest(w, r, "Book id must be an integer")
+		return
 	}
 
-	// check how many rows affected
-	rowsAffected, err := res.RowsAffected()
-
+	// call the deletebook function
+	deletedRows, err := books.DeleteBook(int64(id))
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v", err)
+		writeStoreError(w, r, err)
+		return
 	}
 
-	//fmt.Printf("Total rows/record affected %v", rowsAffected)
-
-	return rowsAffected
-}
-func clearDB() {
-	// create the postgres db connection
-	db := createConnection()
+	// format the message string
+	msg := fmt.Sprintf("User updated successfully. Total rows/record affected %v", deletedRows)
 
-	// close the db connection
-	defer db.Close()
+	// format the reponse message
+	res := response{
+		ID:      int64(id),
+		Message: msg,
+	}
 
-	// create the delete sql query
-	sqlStatement := `
-	TRUNCATE book;
-	DELETE FROM book;
-	ALTER SEQUENCE book_id_seq RESTART WITH 1;`
+	//send the response
+	json.NewEncoder(w).Encode(res)
+}
 
-	// execute the sql statement
-	rows, err := db.Query(sqlStatement)
+// PoolStats reports the state of the database connection pool
+func PoolStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+	//only database backed stores have a pool to report on
+	ps, ok := books.(store.PoolStatser)
+	if !ok {
+		writeProblem(w, r, problem{
+			Type:   problemNotFound,
+			Title:  "Not found",
+			Status: http.StatusNotFound,
+			Detail: "The book store has no connection pool",
+		})
+		return
 	}
-	// close the statement
-	defer rows.Close()
 
+	st := ps.Stats()
+	json.NewEncoder(w).Encode(poolStats{
+		MaxOpenConnections: st.MaxOpenConnections,
+		OpenConnections:    st.OpenConnections,
+		InUse:              st.InUse,
+		Idle:               st.Idle,
+		WaitCount:          st.WaitCount,
+		WaitDurationMs:     st.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      st.MaxIdleClosed,
+		MaxIdleTimeClosed:  st.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  st.MaxLifetimeClosed,
+	})
 }
@@ -0,0 +1,355 @@
+package middleware
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-postgres/models"
+	"go-postgres/store"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxImportBytes caps the size of an uploaded csv file
+const maxImportBytes = 10 << 20
+
+// importColumns maps the header names a csv may use, compared ignoring case,
+// spaces and underscores, to the models.Book field they fill
+var importColumns = map[string]string{
+	"id":          "ID",
+	"title":       "Title",
+	"author":      "Author",
+	"publisher":   "Publisher",
+	"publishdate": "Publish_Date",
+	"rating":      "Rating",
+	"status":      "Status",
+	"isbn10":      "ISBN_10",
+	"isbn13":      "ISBN_13",
+}
+
+// rejectedRow is one csv row that was not imported
+type rejectedRow struct {
+	Line   int          `json:"line"`
+	Errors []fieldError `json:"errors"`
+}
+
+// importReport is the result of a csv import. Error is only set when the
+// import stopped part way; IDs then holds the books imported before it did.
+type importReport struct {
+	DryRun   bool          `json:"dry_run"`
+	Rows     int           `json:"rows"`
+	Imported int           `json:"imported"`
+	Rejected int           `json:"rejected"`
+	IDs      []int64       `json:"ids"`
+	Errors   []rejectedRow `json:"errors"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// importRow is a book read from the csv and the line it started on
+type importRow struct {
+	line int
+	book models.Book
+}
+
+// ImportBooks creates books from a csv file. The first row is a header naming
+// models.Book fields (Title, Author, Publisher, Publish_Date, Rating, Status,
+// ISBN_10, ISBN_13) in any order. ID and Status columns are accepted and ignored so an export can be imported
+// again; imported books always get new ids and start without reviews, showing their Rating until they
+// have some. The csv is the request body (text/csv) or the "file" field of a multipart form.
+//
+// Every row is validated like POST /api/newbook and checked against the store
+// for an ISBN another book, or an earlier row, already has. Valid rows are
+// inserted in one transaction, or in transactions of batch_size rows when that
+// is given, and the report lists every rejected row with its line number and
+// reasons. dry_run=true runs the same checks and reports without writing
+// anything. If the store fails part way the report is still sent, with a 500,
+// the error and the ids of the books imported before it.
+func ImportBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	q := r.URL.Query()
+	var errs []fieldError
+	dryRun := false
+	if v := q.Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			errs = append(errs, fieldError{Field: "dry_run", Message: "dry_run must be true or false"})
+		}
+	}
+	batchSize := 0
+	if v := q.Get("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs = append(errs, fieldError{Field: "batch_size", Message: "batch_size must be a whole number of at least 1"})
+		}
+		batchSize = n
+	}
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	body, err := importBody(w, r)
+	if err != nil {
+		badRequest(w, r, err.Error())
+		return
+	}
+	defer body.Close()
+
+	rows, rejected, err := readImport(body)
+	if err != nil {
+		badRequest(w, r, err.Error())
+		return
+	}
+	report := importReport{DryRun: dryRun, Rows: len(rows) + len(rejected), IDs: []int64{}}
+
+	rows, taken, err := checkImportISBNs(rows)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	report.Errors = append(rejected, taken...)
+
+	if !dryRun {
+		if batchSize == 0 {
+			batchSize = len(rows)
+		}
+		for start := 0; start < len(rows); start += batchSize {
+			end := start + batchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			batch := make([]models.Book, 0, end-start)
+			for _, row := range rows[start:end] {
+				batch = append(batch, row.book)
+			}
+
+			ids, err := books.InsertBooks(batch)
+			if err != nil {
+				// any other failure stops the import, reporting what was imported before it
+				if !errors.Is(err, store.ErrInvalid) && !errors.Is(err, store.ErrConflict) {
+					log.Printf("import stopped at line %d: %v", rows[start].line, err)
+					report.Error = fmt.Sprintf("Unable to import the rows from line %d on; the books in ids were imported", rows[start].line)
+					writeImportReport(w, report, http.StatusInternalServerError)
+					return
+				}
+				// a batch the store refuses is rolled back, so report each of its rows
+				for _, row := range rows[start:end] {
+					report.Errors = append(report.Errors, rejectedRow{
+						Line:   row.line,
+						Errors: []fieldError{{Field: "batch", Message: "rolled back with its batch: " + err.Error()}},
+					})
+				}
+				continue
+			}
+			report.IDs = append(report.IDs, ids...)
+		}
+	}
+
+	if dryRun {
+		report.Imported = len(rows)
+	}
+	writeImportReport(w, report, http.StatusOK)
+}
+
+// writeImportReport counts the imported and rejected rows, sorts the rejected
+// ones and sends the report. A dry run's count of rows it would import is kept.
+func writeImportReport(w http.ResponseWriter, report importReport, status int) {
+	if !report.DryRun {
+		report.Imported = len(report.IDs)
+	}
+	report.Rejected = len(report.Errors)
+	if report.Errors == nil {
+		report.Errors = []rejectedRow{}
+	}
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// checkImportISBNs rejects the rows whose ISBN a book in the store or an
+// earlier row already has, which the store would otherwise refuse with the
+// whole batch. It returns the rows left and the rejected ones.
+func checkImportISBNs(rows []importRow) ([]importRow, []rejectedRow, error) {
+	var valid []importRow
+	var rejected []rejectedRow
+	seen := make(map[string]int)
+	for _, row := range rows {
+		isbn13 := row.book.ISBN_13
+		if isbn13 == "" {
+			valid = append(valid, row)
+			continue
+		}
+		if line, ok := seen[isbn13]; ok {
+			rejected = append(rejected, rejectedRow{Line: row.line, Errors: []fieldError{
+				{Field: "ISBN_13", Message: fmt.Sprintf("ISBN %s is already used on line %d", isbn13, line)},
+			}})
+			continue
+		}
+		seen[isbn13] = row.line
+		book, err := books.GetBookByISBN(isbn13)
+		if err == nil {
+			rejected = append(rejected, rejectedRow{Line: row.line, Errors: []fieldError{
+				{Field: "ISBN_13", Message: fmt.Sprintf("ISBN %s is already used by book %d", isbn13, book.ID)},
+			}})
+			continue
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return nil, nil, err
+		}
+		valid = append(valid, row)
+	}
+	return valid, rejected, nil
+}
+
+// importBody returns the csv from the body or from the "file" field of a multipart form
+func importBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+		return nil, errors.New("Unable to read the multipart form. " + err.Error())
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, errors.New("The multipart form needs the csv in a field named file")
+	}
+	return file, nil
+}
+
+// readImport parses the csv into valid rows and rejected rows. It only fails
+// outright when the csv itself cannot be read or the header is unusable.
+func readImport(body io.Reader) ([]importRow, []rejectedRow, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("The csv is empty")
+	}
+	if err != nil {
+		return nil, nil, errors.New("Unable to read the csv header. " + err.Error())
+	}
+	fields := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		key := strings.NewReplacer(" ", "", "_", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+		field, ok := importColumns[key]
+		if !ok {
+			return nil, nil, errors.New("Unknown csv column " + strconv.Quote(name) + ", columns must be ID, Title, Author, Publisher, Publish_Date, Rating, Status, ISBN_10 or ISBN_13")
+		}
+		if seen[field] {
+			return nil, nil, errors.New("The csv has more than one " + field + " column")
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+
+	var rows []importRow
+	var rejected []rejectedRow
+	// line numbers are counted by hand because a quoted value may span lines
+	line := 1 + newlines(header)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, nil, errors.New("Unable to read the csv. " + err.Error())
+		}
+
+		book, errs := bookFromRecord(fields, record)
+		errs = append(errs, validateBook(&book)...)
+		if errs != nil {
+			rejected = append(rejected, rejectedRow{Line: line, Errors: dedupe(errs)})
+		} else {
+			rows = append(rows, importRow{line: line, book: book})
+		}
+		line += newlines(record)
+	}
+
+	return rows, rejected, nil
+}
+
+// bookFromRecord fills a book from one csv record laid out as fields
+func bookFromRecord(fields []string, record []string) (models.Book, []fieldError) {
+	var book models.Book
+	var errs []fieldError
+	if len(record) != len(fields) {
+		errs = append(errs, fieldError{Field: "row", Message: "row has " + strconv.Itoa(len(record)) + " values but the header has " + strconv.Itoa(len(fields))})
+	}
+
+	for i, field := range fields {
+		if i >= len(record) {
+			break
+		}
+		value := strings.TrimSpace(record[i])
+		switch field {
+		case "ID":
+			// ids are assigned by the store
+		case "Title":
+			book.Title = value
+		case "Author":
+			book.Author = value
+		case "Publisher":
+			book.Publisher = value
+		case "Publish_Date":
+			book.Publish_Date = value
+		case "ISBN_10":
+			book.ISBN_10 = value
+		case "ISBN_13":
+			book.ISBN_13 = value
+		case "Rating":
+			if value == "" {
+				continue
+			}
+			rating, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fieldError{Field: "Rating", Message: "Rating must be a number"})
+				continue
+			}
+			book.Rating = &rating
+		case "Status":
+			//read only, it follows from the book's loans, so an exported Status is ignored
+		}
+	}
+
+	return book, errs
+}
+
+// dedupe drops repeat errors for a field, keeping the first, so a value that
+// cannot be parsed is not also reported as invalid
+func dedupe(errs []fieldError) []fieldError {
+	seen := make(map[string]bool)
+	var out []fieldError
+	for _, e := range errs {
+		if !seen[e.Field] {
+			seen[e.Field] = true
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// newlines counts the line breaks inside the values of a record
+func newlines(record []string) int {
+	n := 0
+	for _, v := range record {
+		n += strings.Count(v, "\n")
+	}
+	return n
+}
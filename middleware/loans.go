@@ -0,0 +1,312 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxPatronLength is the longest patron identifier, in characters
+const maxPatronLength = 100
+
+// LoanPolicy sets how long books go out for and how often a loan may be renewed
+type LoanPolicy struct {
+	Period      time.Duration
+	MaxRenewals int
+}
+
+// DefaultLoanPolicy is used for any setting not given in the environment
+var DefaultLoanPolicy = LoanPolicy{
+	Period:      14 * 24 * time.Hour,
+	MaxRenewals: 2,
+}
+
+// loanPolicy is the policy the circulation handlers apply
+var loanPolicy = DefaultLoanPolicy
+
+// SetLoanPolicy changes the policy applied to new checkouts and renewals
+func SetLoanPolicy(p LoanPolicy) {
+	loanPolicy = p
+}
+
+// LoanPolicyFromEnv reads LOAN_DAYS and LOAN_MAX_RENEWALS, falling back to DefaultLoanPolicy
+func LoanPolicyFromEnv() (LoanPolicy, error) {
+	p := DefaultLoanPolicy
+
+	if v := os.Getenv("LOAN_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 {
+			return p, fmt.Errorf("LOAN_DAYS: must be a whole number of at least 1, got %q", v)
+		}
+		p.Period = time.Duration(days) * 24 * time.Hour
+	}
+	if v := os.Getenv("LOAN_MAX_RENEWALS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("LOAN_MAX_RENEWALS: must be a whole number of at least 0, got %q", v)
+		}
+		p.MaxRenewals = n
+	}
+
+	return p, nil
+}
+
+// checkoutRequest is the body of POST /api/loans. Barcode picks the copy to
+// lend, any available copy otherwise. UserID makes the loan out to a user
+// account, whose username is the Patron unless one is given. DueAt is optional
+// and defaults to the loan period from now.
+type checkoutRequest struct {
+	BookID  int64      `json:"BookID"`
+	Barcode string     `json:"Barcode"`
+	UserID  int64      `json:"UserID"`
+	Patron  string     `json:"Patron"`
+	DueAt   *time.Time `json:"DueAt"`
+}
+
+// validatePatron trims a patron identifier and checks it is usable
+func validatePatron(patron *string) []fieldError {
+	*patron = strings.TrimSpace(*patron)
+	if *patron == "" || utf8.RuneCountInString(*patron) > maxPatronLength {
+		return []fieldError{{Field: "Patron", Message: "Patron must be 1 to " + strconv.Itoa(maxPatronLength) + " characters"}}
+	}
+	return nil
+}
+
+// loanID reads the id from an /api/loans/{id} url and anything under it
+func loanID(r *http.Request) (int64, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/loans/")
+	return strconv.ParseInt(strings.SplitN(rest, "/", 2)[0], 10, 64)
+}
+
+// writeLoans sends a list of loans, [] rather than null when there are none
+func writeLoans(w http.ResponseWriter, r *http.Request, f store.LoanFilter) {
+	list, err := loans.ListLoans(f)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.Loan{}
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// CheckOutBook lends a copy of a book to a patron. A book with no copy
+// available, or a chosen copy that is not available, is a 409.
+func CheckOutBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var req checkoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if req.UserID != 0 {
+		user, err := users.GetUser(req.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			validationFailed(w, r, []fieldError{{Field: "UserID", Message: "UserID must be the id of a user"}})
+			return
+		}
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if strings.TrimSpace(req.Patron) == "" {
+			req.Patron = user.Username
+		}
+	}
+	errs := validatePatron(&req.Patron)
+	if req.BookID < 1 {
+		errs = append(errs, fieldError{Field: "BookID", Message: "BookID is required"})
+	}
+	due := time.Now().Add(loanPolicy.Period)
+	if req.DueAt != nil {
+		if !req.DueAt.After(time.Now()) {
+			errs = append(errs, fieldError{Field: "DueAt", Message: "DueAt must be in the future"})
+		}
+		due = *req.DueAt
+	}
+	if errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	loan, err := loans.CheckOut(req.BookID, strings.TrimSpace(req.Barcode), req.Patron, req.UserID, due)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(loan)
+}
+
+// ReturnLoan checks a book back in
+func ReturnLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := loanID(r)
+	if err != nil {
+		badRequest(w, r, "Loan id must be an integer")
+		return
+	}
+
+	loan, err := loans.ReturnLoan(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(loan)
+}
+
+// RenewLoan gives an open loan another loan period from now, up to the
+// policy's number of renewals
+func RenewLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := loanID(r)
+	if err != nil {
+		badRequest(w, r, "Loan id must be an integer")
+		return
+	}
+
+	loan, err := loans.RenewLoan(id, time.Now().Add(loanPolicy.Period), loanPolicy.MaxRenewals)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(loan)
+}
+
+// GetLoan returns one loan
+func GetLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := loanID(r)
+	if err != nil {
+		badRequest(w, r, "Loan id must be an integer")
+		return
+	}
+
+	loan, err := loans.GetLoan(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(loan)
+}
+
+// GetLoans lists loans, most recent first, narrowed by any of:
+//
+//	book_id      loans of one book
+//	patron       loans of one patron
+//	open         true for books still out, false for returned ones
+//	overdue      true for books still out past their due date
+func GetLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := r.URL.Query()
+	var f store.LoanFilter
+	var errs []fieldError
+	if v := q.Get("book_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "book_id", Message: "book_id must be an integer"})
+		} else {
+			f.BookID = &id
+		}
+	}
+	f.Patron = strings.TrimSpace(q.Get("patron"))
+	if v := q.Get("open"); v != "" {
+		open, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "open", Message: "open must be true or false"})
+		} else {
+			f.Open = &open
+		}
+	}
+	if v := q.Get("overdue"); v != "" {
+		overdue, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "overdue", Message: "overdue must be true or false"})
+		} else {
+			f.Overdue = overdue
+		}
+	}
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	writeLoans(w, r, f)
+}
+
+// GetBookLoans lists the loan history of one book, most recent first
+func GetBookLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := bookPathID(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+
+	//an unknown book is a 404 rather than an empty history
+	if _, err := books.GetBook(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeLoans(w, r, store.LoanFilter{BookID: &id})
+}
+
+// GetMyLoans lists the loans of the signed in user, most recent first. A
+// user's loans are those made out to their account, whatever the Patron says.
+func GetMyLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	claims, ok := callerClaims(r)
+	if !ok {
+		forbidden(w, r, "Loans belong to signed in users, not api keys")
+		return
+	}
+	writeLoans(w, r, store.LoanFilter{UserID: &claims.UserID})
+}
+
+// GetPatronLoans lists the loan history of one patron, most recent first
+func GetPatronLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	patron := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/patrons/"), "/loans")
+	if errs := validatePatron(&patron); errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	writeLoans(w, r, store.LoanFilter{Patron: patron})
+}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// This file applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to a generic json value decoded with encoding/json, so they work
+// on whatever a book serialises to rather than being tied to models.Book.
+
+// errPatchTestFailed is returned when a JSON Patch "test" operation does not hold
+var errPatchTestFailed = errors.New("test operation failed")
+
+// mergePatch applies an RFC 7396 merge patch to target and returns the result
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+	return t
+}
+
+// patchOp is one RFC 6902 operation. Value is kept raw so a missing value can
+// be told apart from an explicit null.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies the RFC 6902 operations in order. Any failing operation
+// aborts the whole patch and doc should then be discarded.
+func jsonPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyOp(doc interface{}, op patchOp) (interface{}, error) {
+	if op.Path == nil {
+		return nil, errors.New("path is required")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("value is required")
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, errors.New("from is required")
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addAt(doc, path, value)
+	case "remove":
+		doc, _, err = removeAt(doc, path)
+		return doc, err
+	case "replace":
+		if doc, _, err = removeAt(doc, path); err != nil {
+			return nil, err
+		}
+		return addAt(doc, path, value)
+	case "move":
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, errors.New("cannot move a value into one of its own children")
+		}
+		doc, moved, err := removeAt(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addAt(doc, path, moved)
+	case "copy":
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		original, err := getAt(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		// round trip through json so the copy shares nothing with the original
+		b, _ := json.Marshal(original)
+		var copied interface{}
+		json.Unmarshal(b, &copied)
+		return addAt(doc, path, copied)
+	case "test":
+		current, err := getAt(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, errPatchTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 json pointer into unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex reads an array index token; "-" is only valid when adding
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	max := length - 1
+	if adding {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// getAt returns the value at path
+func getAt(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path member %q does not exist", token)
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", token)
+		}
+	}
+	return node, nil
+}
+
+// addAt adds value at path and returns the updated node
+func addAt(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("path member %q does not exist", token)
+		}
+		updated, err := addAt(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n), len(path) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		updated, err := addAt(n[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	default:
+		return nil, fmt.Errorf("cannot add inside %q", token)
+	}
+}
+
+// removeAt removes the value at path and returns the updated node and the removed value
+func removeAt(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+	token := path[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path member %q does not exist", token)
+		}
+		if len(path) == 1 {
+			delete(n, token)
+			return n, child, nil
+		}
+		updated, removed, err := removeAt(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = updated
+		return n, removed, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		updated, removed, err := removeAt(n[i], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = updated
+		return n, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("cannot remove inside %q", token)
+	}
+}
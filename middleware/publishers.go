@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"encoding/json"
+	"go-postgres/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// validatePublisher checks a publisher sent to POST or PUT, trimming the name
+func validatePublisher(publisher *models.Publisher) []fieldError {
+	var errs []fieldError
+	publisher.Name = strings.TrimSpace(publisher.Name)
+	if publisher.Name == "" {
+		errs = append(errs, fieldError{Field: "Name", Message: "Name is required"})
+	}
+	if publisher.ParentID != nil && *publisher.ParentID < 1 {
+		errs = append(errs, fieldError{Field: "ParentID", Message: "ParentID must be a publisher id or null"})
+	}
+	return errs
+}
+
+// publisherID reads the id from an /api/publishers/{id} url and anything under it
+func publisherID(r *http.Request) (int64, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/publishers/")
+	return strconv.ParseInt(strings.SplitN(rest, "/", 2)[0], 10, 64)
+}
+
+// CreatePublisher adds a publisher, or an imprint when ParentID is given
+func CreatePublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var publisher models.Publisher
+	if err := json.NewDecoder(r.Body).Decode(&publisher); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validatePublisher(&publisher); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	id, err := publishers.InsertPublisher(publisher)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Publisher added successfully"})
+}
+
+// GetPublishers lists the publishers, narrowed to names containing ?name= and
+// to the imprints of ?parent_id= when given
+func GetPublishers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var parentID *int64
+	if v := r.URL.Query().Get("parent_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			invalidQuery(w, r, []fieldError{{Field: "parent_id", Message: "parent_id must be an integer"}})
+			return
+		}
+		parentID = &id
+	}
+
+	list, err := publishers.ListPublishers(strings.TrimSpace(r.URL.Query().Get("name")), parentID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.Publisher{}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetPublisher returns one publisher
+func GetPublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := publisherID(r)
+	if err != nil {
+		badRequest(w, r, "Publisher id must be an integer")
+		return
+	}
+
+	publisher, err := publishers.GetPublisher(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(publisher)
+}
+
+// GetPublisherBooks lists the books of a publisher and of all its imprints,
+// taking the same filter, sort and paging parameters as GET /api/book
+func GetPublisherBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count")
+
+	id, err := publisherID(r)
+	if err != nil {
+		badRequest(w, r, "Publisher id must be an integer")
+		return
+	}
+	q, errs := parseBookQuery(r.URL.Query())
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	//an unknown publisher is a 404 rather than an empty list
+	if _, err := publishers.GetPublisher(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	q.Filter.PublisherID = &id
+	listBooks(w, r, q)
+}
+
+// UpdatePublisher renames a publisher or moves it under another. Its books
+// pick up the new name.
+func UpdatePublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := publisherID(r)
+	if err != nil {
+		badRequest(w, r, "Publisher id must be an integer")
+		return
+	}
+	var publisher models.Publisher
+	if err := json.NewDecoder(r.Body).Decode(&publisher); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validatePublisher(&publisher); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	if _, err := publishers.UpdatePublisher(id, publisher); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Publisher updated successfully"})
+}
+
+// DeletePublisher removes a publisher that has no books and no imprints
+func DeletePublisher(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := publisherID(r)
+	if err != nil {
+		badRequest(w, r, "Publisher id must be an integer")
+		return
+	}
+
+	if _, err := publishers.DeletePublisher(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Publisher deleted successfully"})
+}
@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPageSize is used when the listing is asked for without a limit
+	defaultPageSize = 100
+	// maxPageSize is the largest limit a client may ask for
+	maxPageSize = 1000
+)
+
+// cursorToken is the json inside an opaque after/before cursor. It carries the
+// sort it was made for so it cannot be replayed against a different order.
+type cursorToken struct {
+	Field string `json:"f"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+// encodeCursor turns a position in a listing sorted by srt into an opaque token
+func encodeCursor(srt store.Sort, c store.Cursor) string {
+	b, _ := json.Marshal(cursorToken{Field: srt.Field, Desc: srt.Desc, Value: c.Value, ID: c.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reads a token made by encodeCursor for the same sort
+func decodeCursor(token string, srt store.Sort) (*store.Cursor, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, false
+	}
+	var t cursorToken
+	if err := json.Unmarshal(b, &t); err != nil || t.Field != srt.Field || t.Desc != srt.Desc {
+		return nil, false
+	}
+	return &store.Cursor{Value: t.Value, ID: t.ID}, true
+}
+
+// sortError is reported for a sort parameter parseSort cannot read
+var sortError = fieldError{Field: "sort", Message: "sort must be one of " + strings.Join(store.SortFields(), ", ") + ", optionally prefixed with - or suffixed with :asc or :desc"}
+
+// parseSort reads sort=Field, sort=-Field, sort=Field:asc or sort=Field:desc
+// where Field is one of store.SortFields, matched ignoring case
+func parseSort(v string) (store.Sort, bool) {
+	var srt store.Sort
+	if v == "" {
+		return store.Sort{Field: "ID"}, true
+	}
+	if strings.HasPrefix(v, "-") {
+		srt.Desc = true
+		v = v[1:]
+	} else if i := strings.LastIndex(v, ":"); i >= 0 {
+		switch strings.ToLower(v[i+1:]) {
+		case "asc":
+		case "desc":
+			srt.Desc = true
+		default:
+			return srt, false
+		}
+		v = v[:i]
+	}
+	field, ok := store.SortField(v)
+	srt.Field = field
+	return srt, ok
+}
+
+// parseBookQuery reads the filters from parseBookFilter plus paging and sorting:
+//
+//	limit                books per page, 1-1000 (default 100)
+//	offset               books to skip, for offset pagination
+//	after, before        opaque cursors from the Link header, for keyset pagination
+//	sort                 Field, -Field, Field:asc or Field:desc
+//
+// offset, after and before cannot be combined.
+func parseBookQuery(q url.Values) (store.BookQuery, []fieldError) {
+	var bq store.BookQuery
+	var errs []fieldError
+
+	bq.Filter, errs = parseBookFilter(q)
+
+	srt, ok := parseSort(q.Get("sort"))
+	if !ok {
+		errs = append(errs, sortError)
+	}
+	bq.Sort = srt
+
+	bq.Limit = defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			errs = append(errs, fieldError{Field: "limit", Message: "limit must be a whole number from 1 to " + strconv.Itoa(maxPageSize)})
+		} else {
+			bq.Limit = limit
+		}
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			errs = append(errs, fieldError{Field: "offset", Message: "offset must be a whole number of at least 0"})
+		} else {
+			bq.Offset = offset
+		}
+	}
+
+	paging := 0
+	for _, name := range []string{"offset", "after", "before"} {
+		if q.Get(name) != "" {
+			paging++
+		}
+	}
+	if paging > 1 {
+		errs = append(errs, fieldError{Field: "offset", Message: "only one of offset, after and before may be given"})
+	}
+
+	if v := q.Get("after"); v != "" && ok {
+		if bq.After, ok = decodeCursor(v, srt); !ok {
+			errs = append(errs, fieldError{Field: "after", Message: "after is not a cursor for this sort order"})
+		}
+	}
+	if v := q.Get("before"); v != "" && ok {
+		if bq.Before, ok = decodeCursor(v, srt); !ok {
+			errs = append(errs, fieldError{Field: "before", Message: "before is not a cursor for this sort order"})
+		}
+	}
+
+	return bq, errs
+}
+
+// pageLinks builds the Link header for a listing page. Requests that paged with
+// offset get offset links (first, prev, next, last); everything else gets keyset
+// links (prev, next) carrying cursors for the first and last book on the page.
+// more reports whether the store had books beyond the page in the direction read.
+func pageLinks(r *http.Request, q store.BookQuery, page []models.Book, total int64, more bool) string {
+	var links []string
+	link := func(rel string, set map[string]string) {
+		u := *r.URL
+		v := u.Query()
+		for _, name := range []string{"offset", "after", "before"} {
+			v.Del(name)
+		}
+		for name, value := range set {
+			v.Set(name, value)
+		}
+		u.RawQuery = v.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel))
+	}
+
+	if r.URL.Query().Get("offset") != "" {
+		limit := int64(q.Limit)
+		offset := int64(q.Offset)
+		link("first", map[string]string{"offset": "0"})
+		if offset > 0 {
+			prev := offset - limit
+			if prev < 0 {
+				prev = 0
+			}
+			link("prev", map[string]string{"offset": strconv.FormatInt(prev, 10)})
+		}
+		if offset+limit < total {
+			link("next", map[string]string{"offset": strconv.FormatInt(offset+limit, 10)})
+		}
+		if total > 0 {
+			link("last", map[string]string{"offset": strconv.FormatInt((total-1)/limit*limit, 10)})
+		}
+		return strings.Join(links, ", ")
+	}
+
+	if len(page) == 0 {
+		return ""
+	}
+	// reading backwards means there is always a next page (where the cursor came from)
+	// and more tells us about the previous one, reading forwards it is the other way round
+	hasPrev := q.After != nil || (q.Before != nil && more)
+	hasNext := q.Before != nil || more
+	if hasPrev {
+		link("prev", map[string]string{"before": encodeCursor(q.Sort, store.CursorFor(page[0], q.Sort.Field))})
+	}
+	if hasNext {
+		link("next", map[string]string{"after": encodeCursor(q.Sort, store.CursorFor(page[len(page)-1], q.Sort.Field))})
+	}
+	return strings.Join(links, ", ")
+}
+
+// parseSearchQuery reads q (required), limit (1-100, default 20) and offset
+func parseSearchQuery(q url.Values) (string, int, int, []fieldError) {
+	var errs []fieldError
+
+	text := strings.TrimSpace(q.Get("q"))
+	if text == "" {
+		errs = append(errs, fieldError{Field: "q", Message: "q is required"})
+	}
+
+	limit := 20
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			errs = append(errs, fieldError{Field: "limit", Message: "limit must be a whole number from 1 to 100"})
+		} else {
+			limit = n
+		}
+	}
+
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			errs = append(errs, fieldError{Field: "offset", Message: "offset must be a whole number of at least 0"})
+		} else {
+			offset = n
+		}
+	}
+
+	return text, limit, offset, errs
+}
+
+// parseBookFilter reads the listing filters from the query string:
+//
+//	author, publisher    exact match, ignoring case
+//	title                title contains the text, ignoring case
+//	status               true or false
+//	rating_min/max       inclusive rating range
+//	published_from/to    inclusive publish date range (YYYY-MM-DD)
+//	publisher_id         a publisher or any of its imprints
+//	genre                a genre id, matching that genre or any genre below it
+//	tag                  a tag, ignoring case
+//
+// Every malformed parameter is reported as its own fieldError.
+func parseBookFilter(q url.Values) (store.BookFilter, []fieldError) {
+	var f store.BookFilter
+	var errs []fieldError
+
+	f.Author = q.Get("author")
+	f.Publisher = q.Get("publisher")
+	f.TitleContains = q.Get("title")
+
+	if v := q.Get("publisher_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "publisher_id", Message: "publisher_id must be an integer"})
+		} else {
+			f.PublisherID = &id
+		}
+	}
+
+	if v := q.Get("genre"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "genre", Message: "genre must be a genre id"})
+		} else {
+			f.Genre = &id
+		}
+	}
+	if v := q.Get("tag"); v != "" {
+		f.Tag = normalizeTag(v)
+	}
+
+	if v := q.Get("status"); v != "" {
+		status, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fieldError{Field: "status", Message: "status must be true or false"})
+		} else {
+			f.Status = &status
+		}
+	}
+
+	parseRating := func(name string) *float64 {
+		v := q.Get(name)
+		if v == "" {
+			return nil
+		}
+		rating, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fieldError{Field: name, Message: name + " must be a number"})
+			return nil
+		}
+		return &rating
+	}
+	f.MinRating = parseRating("rating_min")
+	f.MaxRating = parseRating("rating_max")
+	if f.MinRating != nil && f.MaxRating != nil && *f.MinRating > *f.MaxRating {
+		errs = append(errs, fieldError{Field: "rating_min", Message: "rating_min must not be greater than rating_max"})
+	}
+
+	parseDate := func(name string) *time.Time {
+		v := q.Get(name)
+		if v == "" {
+			return nil
+		}
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			errs = append(errs, fieldError{Field: name, Message: name + " must be a date in the form YYYY-MM-DD"})
+			return nil
+		}
+		return &date
+	}
+	f.PublishedFrom = parseDate("published_from")
+	f.PublishedTo = parseDate("published_to")
+	if f.PublishedFrom != nil && f.PublishedTo != nil && f.PublishedFrom.After(*f.PublishedTo) {
+		errs = append(errs, fieldError{Field: "published_from", Message: "published_from must not be after published_to"})
+	}
+
+	return f, errs
+}
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxReviewLength is the longest review text, in characters
+const maxReviewLength = 5000
+
+// ratingScale is the scale reviews and book ratings are checked against
+var ratingScale = store.DefaultRatingScale
+
+// SetRatingScale changes the scale new and edited reviews and book ratings must
+// be on. Ratings already given are not changed, see store.ReviewStore.RescaleRatings.
+func SetRatingScale(sc store.RatingScale) error {
+	if err := sc.Check(); err != nil {
+		return err
+	}
+	ratingScale = sc
+	return nil
+}
+
+// RatingScaleFromEnv reads RATING_MIN, RATING_MAX and RATING_STEP, falling back
+// to store.DefaultRatingScale for any not set
+func RatingScaleFromEnv() (store.RatingScale, error) {
+	sc := store.DefaultRatingScale
+	for _, setting := range []struct {
+		name  string
+		value *float64
+	}{
+		{"RATING_MIN", &sc.Min},
+		{"RATING_MAX", &sc.Max},
+		{"RATING_STEP", &sc.Step},
+	} {
+		v := os.Getenv(setting.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return sc, fmt.Errorf("%s: must be a number, got %q", setting.name, v)
+		}
+		*setting.value = n
+	}
+
+	return sc, sc.Check()
+}
+
+// reviewRequest is the body of POST /api/book/{id}/reviews and PUT /api/reviews/{id}
+type reviewRequest struct {
+	Rating *float64 `json:"Rating"`
+	Text   string   `json:"Text"`
+}
+
+// validateReview checks a review sent to POST or PUT, trimming the text
+func validateReview(req *reviewRequest) []fieldError {
+	var errs []fieldError
+	if req.Rating == nil || !ratingScale.Allows(*req.Rating) {
+		errs = append(errs, fieldError{Field: "Rating", Message: "Rating needs to be in range " + ratingScale.String()})
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if utf8.RuneCountInString(req.Text) > maxReviewLength {
+		errs = append(errs, fieldError{Field: "Text", Message: "Text must be at most " + strconv.Itoa(maxReviewLength) + " characters"})
+	}
+	return errs
+}
+
+// reviewID reads the id from an /api/reviews/{id} url
+func reviewID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/reviews/"), 10, 64)
+}
+
+// reviewer returns the signed in user making the request. Reviews belong to
+// users, so a caller using an api key is refused.
+func reviewer(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	claims, ok := callerClaims(r)
+	if !ok {
+		forbidden(w, r, "Reviews are written by signed in users, not api keys")
+		return models.User{}, false
+	}
+	user, err := users.GetUser(claims.UserID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ownReview loads the review in the url and checks the caller wrote it.
+// Admins may also remove other users' reviews when allowAdmin is set.
+func ownReview(w http.ResponseWriter, r *http.Request, allowAdmin bool) (models.Review, bool) {
+	id, err := reviewID(r)
+	if err != nil {
+		badRequest(w, r, "Review id must be an integer")
+		return models.Review{}, false
+	}
+	user, ok := reviewer(w, r)
+	if !ok {
+		return models.Review{}, false
+	}
+	review, err := reviews.GetReview(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return models.Review{}, false
+	}
+	if review.UserID != user.ID && !(allowAdmin && user.Role == store.RoleAdmin) {
+		forbidden(w, r, "Only the user who wrote a review can change it")
+		return models.Review{}, false
+	}
+	return review, true
+}
+
+// writeReviews sends a list of reviews, [] rather than null when there are none
+func writeReviews(w http.ResponseWriter, r *http.Request, f store.ReviewFilter) {
+	list, err := reviews.ListReviews(f)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.Review{}
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateReview rates and reviews the book in the url as the signed in user.
+// A user reviews a book once; a second review is a 409, edit the first instead.
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	bookID, err := bookPathID(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+	user, ok := reviewer(w, r)
+	if !ok {
+		return
+	}
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateReview(&req); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	id, err := reviews.InsertReview(models.Review{BookID: bookID, UserID: user.ID, Rating: *req.Rating, Text: req.Text})
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	review, err := reviews.GetReview(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+}
+
+// GetBookReviews lists the reviews of one book, newest first
+func GetBookReviews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := bookPathID(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+
+	//an unknown book is a 404 rather than an empty list
+	if _, err := books.GetBook(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeReviews(w, r, store.ReviewFilter{BookID: &id})
+}
+
+// GetMyReviews lists the signed in user's reviews, newest first
+func GetMyReviews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	user, ok := reviewer(w, r)
+	if !ok {
+		return
+	}
+
+	writeReviews(w, r, store.ReviewFilter{UserID: &user.ID})
+}
+
+// GetReview returns one review
+func GetReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := reviewID(r)
+	if err != nil {
+		badRequest(w, r, "Review id must be an integer")
+		return
+	}
+
+	review, err := reviews.GetReview(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(review)
+}
+
+// UpdateReview changes the rating and text of one of the caller's own reviews
+func UpdateReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	review, ok := ownReview(w, r, false)
+	if !ok {
+		return
+	}
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateReview(&req); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	if _, err := reviews.UpdateReview(review.ID, models.Review{Rating: *req.Rating, Text: req.Text}); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	review, err := reviews.GetReview(review.ID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(review)
+}
+
+// DeleteReview removes one of the caller's own reviews; admins may remove any
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	review, ok := ownReview(w, r, true)
+	if !ok {
+		return
+	}
+
+	if _, err := reviews.DeleteReview(review.ID); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: review.ID, Message: "Review deleted successfully"})
+}
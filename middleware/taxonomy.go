@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"encoding/json"
+	"go-postgres/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTagLength is the longest tag, in characters, a book may carry
+const maxTagLength = 50
+
+// normalizeTag trims a tag and lower cases it so "SciFi " and "scifi" are one tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// validateGenre checks a genre sent to POST or PUT, trimming the name
+func validateGenre(genre *models.Genre) []fieldError {
+	var errs []fieldError
+	genre.Name = strings.TrimSpace(genre.Name)
+	if genre.Name == "" {
+		errs = append(errs, fieldError{Field: "Name", Message: "Name is required"})
+	}
+	if genre.ParentID != nil && *genre.ParentID < 1 {
+		errs = append(errs, fieldError{Field: "ParentID", Message: "ParentID must be a genre id or null"})
+	}
+	return errs
+}
+
+// genreID reads the id from an /api/genres/{id} url
+func genreID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/genres/"), 10, 64)
+}
+
+// bookPathID reads the book id from an /api/book/{id}/... url
+func bookPathID(r *http.Request) (int64, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/book/")
+	return strconv.ParseInt(strings.SplitN(rest, "/", 2)[0], 10, 64)
+}
+
+// bookLink reads the book id and the genre id or tag from an
+// /api/book/{id}/genres/{genreId} or /api/book/{id}/tags/{tag} url
+func bookLink(r *http.Request) (int64, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/book/"), "/", 3)
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) < 3 {
+		return 0, "", strconv.ErrSyntax
+	}
+	return id, parts[2], nil
+}
+
+// CreateGenre adds a genre, under another when ParentID is given
+func CreateGenre(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var genre models.Genre
+	if err := json.NewDecoder(r.Body).Decode(&genre); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateGenre(&genre); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	id, err := taxonomy.InsertGenre(genre)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Genre added successfully"})
+}
+
+// GetGenres lists every genre, or only the direct children of ?parent_id=
+func GetGenres(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var parentID *int64
+	if v := r.URL.Query().Get("parent_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			invalidQuery(w, r, []fieldError{{Field: "parent_id", Message: "parent_id must be an integer"}})
+			return
+		}
+		parentID = &id
+	}
+
+	list, err := taxonomy.ListGenres(parentID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.Genre{}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetGenre returns one genre
+func GetGenre(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := genreID(r)
+	if err != nil {
+		badRequest(w, r, "Genre id must be an integer")
+		return
+	}
+
+	genre, err := taxonomy.GetGenre(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(genre)
+}
+
+// UpdateGenre renames a genre or moves it under another
+func UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := genreID(r)
+	if err != nil {
+		badRequest(w, r, "Genre id must be an integer")
+		return
+	}
+	var genre models.Genre
+	if err := json.NewDecoder(r.Body).Decode(&genre); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateGenre(&genre); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	if _, err := taxonomy.UpdateGenre(id, genre); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Genre updated successfully"})
+}
+
+// DeleteGenre removes a genre that has no subgenres, taking it off its books
+func DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := genreID(r)
+	if err != nil {
+		badRequest(w, r, "Genre id must be an integer")
+		return
+	}
+
+	if _, err := taxonomy.DeleteGenre(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: id, Message: "Genre deleted successfully"})
+}
+
+// GetTags lists every tag in use with the number of books carrying it
+func GetTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, err := taxonomy.ListTags()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// writeLinkedBook answers a genre or tag change with the book as it now is
+func writeLinkedBook(w http.ResponseWriter, r *http.Request, id int64) {
+	book, err := books.GetBook(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(book)
+}
+
+// AddBookGenre puts a book in a genre. Adding a genre the book already has is
+// not an error.
+func AddBookGenre(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, rest, err := bookLink(r)
+	genre, gerr := strconv.ParseInt(rest, 10, 64)
+	if err != nil || gerr != nil {
+		badRequest(w, r, "Book and genre ids must be integers")
+		return
+	}
+
+	if err := taxonomy.AddBookGenre(id, genre); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeLinkedBook(w, r, id)
+}
+
+// RemoveBookGenre takes a book out of a genre
+func RemoveBookGenre(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, rest, err := bookLink(r)
+	genre, gerr := strconv.ParseInt(rest, 10, 64)
+	if err != nil || gerr != nil {
+		badRequest(w, r, "Book and genre ids must be integers")
+		return
+	}
+
+	if err := taxonomy.RemoveBookGenre(id, genre); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeLinkedBook(w, r, id)
+}
+
+// AddBookTag tags a book. Tags are trimmed and lower cased, and tagging a
+// book twice with the same tag is not an error.
+func AddBookTag(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, tag, err := bookLink(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+	tag = normalizeTag(tag)
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		validationFailed(w, r, []fieldError{{Field: "tag", Message: "tag must be 1 to " + strconv.Itoa(maxTagLength) + " characters"}})
+		return
+	}
+
+	if err := taxonomy.AddBookTag(id, tag); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeLinkedBook(w, r, id)
+}
+
+// RemoveBookTag takes a tag off a book
+func RemoveBookTag(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, tag, err := bookLink(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+
+	if err := taxonomy.RemoveBookTag(id, normalizeTag(tag)); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeLinkedBook(w, r, id)
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// TrashPolicy sets how long deleted books stay in the trash and how often the
+// trash is checked for books past that
+type TrashPolicy struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+// DefaultTrashPolicy is used for any setting not given in the environment
+var DefaultTrashPolicy = TrashPolicy{
+	Retention:     30 * 24 * time.Hour,
+	PurgeInterval: time.Hour,
+}
+
+// TrashPolicyFromEnv reads TRASH_RETENTION_DAYS and TRASH_PURGE_INTERVAL,
+// falling back to DefaultTrashPolicy
+func TrashPolicyFromEnv() (TrashPolicy, error) {
+	p := DefaultTrashPolicy
+
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return p, fmt.Errorf("TRASH_RETENTION_DAYS: must be a whole number of at least 0, got %q", v)
+		}
+		p.Retention = time.Duration(days) * 24 * time.Hour
+	}
+	if v := os.Getenv("TRASH_PURGE_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			return p, fmt.Errorf("TRASH_PURGE_INTERVAL: must be a positive duration such as 1h, got %q", v)
+		}
+		p.PurgeInterval = interval
+	}
+
+	return p, nil
+}
+
+// PurgeTrash permanently removes the books that have been in the trash for
+// longer than retention and returns how many there were
+func PurgeTrash(retention time.Duration) (int64, error) {
+	return books.PurgeBooks(time.Now().Add(-retention))
+}
+
+// StartTrashPurge purges the trash now and then every p.PurgeInterval until
+// the returned stop function is called
+func StartTrashPurge(p TrashPolicy) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.PurgeInterval)
+		defer ticker.Stop()
+		for {
+			n, err := PurgeTrash(p.Retention)
+			if err != nil {
+				log.Printf("trash purge failed: %v", err)
+			} else if n > 0 {
+				log.Printf("purged %d books from the trash", n)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// GetTrash lists the deleted books that can still be restored, taking the same
+// filter, sort and paging parameters as GET /api/book
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count")
+
+	q, errs := parseBookQuery(r.URL.Query())
+	if errs != nil {
+		invalidQuery(w, r, errs)
+		return
+	}
+
+	q.Filter.Deleted = true
+	listBooks(w, r, q)
+}
+
+// RestoreBook takes a book out of the trash and answers with the book
+func RestoreBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := bookPathID(r)
+	if err != nil {
+		badRequest(w, r, "Book id must be an integer")
+		return
+	}
+	if _, err := books.RestoreBook(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	book, err := books.GetBook(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(book)
+}
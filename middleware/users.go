@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-postgres/auth"
+	"go-postgres/models"
+	"go-postgres/store"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usernamePattern is what a username may be made of
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,50}$`)
+
+const (
+	// minPasswordLength is the shortest password accepted, in bytes
+	minPasswordLength = 8
+	// maxPasswordLength is the longest password bcrypt can tell apart, in bytes
+	maxPasswordLength = 72
+)
+
+// credentials is the body of register and login
+type credentials struct {
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+}
+
+// roleRequest is the body of PUT /api/users/{id}/role
+type roleRequest struct {
+	Role string `json:"Role"`
+}
+
+// refreshRequest is the body of refresh and logout
+type refreshRequest struct {
+	RefreshToken string `json:"RefreshToken"`
+}
+
+// tokenResponse is a fresh pair of tokens; ExpiresIn is the access token's lifetime in seconds
+type tokenResponse struct {
+	AccessToken  string `json:"AccessToken"`
+	RefreshToken string `json:"RefreshToken"`
+	TokenType    string `json:"TokenType"`
+	ExpiresIn    int64  `json:"ExpiresIn"`
+}
+
+// validateCredentials checks a username and password sent to register
+func validateCredentials(c *credentials) []fieldError {
+	var errs []fieldError
+	c.Username = strings.TrimSpace(c.Username)
+	if !usernamePattern.MatchString(c.Username) {
+		errs = append(errs, fieldError{Field: "Username", Message: "Username must be 3 to 50 letters, digits, full stops, underscores or hyphens"})
+	}
+	if len(c.Password) < minPasswordLength || len(c.Password) > maxPasswordLength {
+		errs = append(errs, fieldError{Field: "Password", Message: "Password must be 8 to 72 bytes"})
+	}
+	return errs
+}
+
+// EnsureAdmin makes sure username is an admin, creating the account with
+// password when there is none. It is how the first admin is set up, from
+// ADMIN_USERNAME and ADMIN_PASSWORD, as accounts that register themselves are
+// always readers. An existing account keeps its password.
+func EnsureAdmin(username, password string) (models.User, error) {
+	user, _, err := users.GetLogin(strings.TrimSpace(username))
+	if err == nil {
+		if user.Role == store.RoleAdmin {
+			return user, nil
+		}
+		return users.SetUserRole(user.ID, store.RoleAdmin)
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return user, err
+	}
+
+	c := credentials{Username: username, Password: password}
+	if errs := validateCredentials(&c); errs != nil {
+		return user, fmt.Errorf("admin account: %s", errs[0].Message)
+	}
+	hash, err := auth.HashPassword(c.Password)
+	if err != nil {
+		return user, err
+	}
+	id, err := users.InsertUser(models.User{Username: c.Username, Role: store.RoleAdmin}, hash)
+	if err != nil {
+		return user, err
+	}
+	return users.GetUser(id)
+}
+
+// userID reads the id from an /api/users/{id} url and anything under it
+func userID(r *http.Request) (int64, error) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	return strconv.ParseInt(strings.SplitN(rest, "/", 2)[0], 10, 64)
+}
+
+// writeTokens signs an access token for userID and sends it with refreshToken
+func writeTokens(w http.ResponseWriter, r *http.Request, userID int64, refreshToken string) {
+	access, _, err := authConfig.NewAccessToken(userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:  access,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(authConfig.AccessTTL.Seconds()),
+	})
+}
+
+// Register creates a user account with the reader role
+func Register(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	if errs := validateCredentials(&c); errs != nil {
+		validationFailed(w, r, errs)
+		return
+	}
+
+	hash, err := auth.HashPassword(c.Password)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	id, err := users.InsertUser(models.User{Username: c.Username, Role: store.RoleReader}, hash)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	user, err := users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Login checks a username and password and starts a session, answering with
+// an access token and a refresh token
+func Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+
+	user, hash, err := users.GetLogin(strings.TrimSpace(c.Username))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		writeStoreError(w, r, err)
+		return
+	}
+	//an unknown user is checked against no hash so it takes as long as a wrong password
+	if !auth.CheckPassword(hash, c.Password) {
+		unauthorized(w, r, "The username or password is wrong")
+		return
+	}
+
+	refresh, refreshHash := auth.NewRefreshToken()
+	if err := users.InsertRefreshToken(user.ID, refreshHash, time.Now().Add(authConfig.RefreshTTL)); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeTokens(w, r, user.ID, refresh)
+}
+
+// Refresh swaps a refresh token for a new access token and a new refresh
+// token; each refresh token works once
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+
+	refresh, refreshHash := auth.NewRefreshToken()
+	userID, err := users.RotateRefreshToken(auth.HashToken(req.RefreshToken), refreshHash, time.Now().Add(authConfig.RefreshTTL))
+	if errors.Is(err, store.ErrNotFound) {
+		unauthorized(w, r, "The refresh token is invalid, expired or revoked")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	writeTokens(w, r, userID, refresh)
+}
+
+// Logout revokes the caller's access token and, when it is sent, their refresh token
+func Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	claims, ok := callerClaims(r)
+	if !ok {
+		unauthorized(w, r, "Sign in to sign out")
+		return
+	}
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			badRequest(w, r, "Unable to decode the request body. "+err.Error())
+			return
+		}
+	}
+
+	if req.RefreshToken != "" {
+		err := users.RevokeRefreshToken(claims.UserID, auth.HashToken(req.RefreshToken))
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			writeStoreError(w, r, err)
+			return
+		}
+	}
+	if err := users.RevokeAccessToken(claims.ID, claims.ExpiresAt); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response{ID: claims.UserID, Message: "Signed out successfully"})
+}
+
+// Me returns the signed in user
+func Me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	claims, ok := callerClaims(r)
+	if !ok {
+		unauthorized(w, r, "Sign in to see your account")
+		return
+	}
+
+	user, err := users.GetUser(claims.UserID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetUsers lists every user by username
+func GetUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	list, err := users.ListUsers()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []models.User{}
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetUser returns one user
+func GetUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := userID(r)
+	if err != nil {
+		badRequest(w, r, "User id must be an integer")
+		return
+	}
+
+	user, err := users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// SetUserRole gives a user the reader, staff or admin role. Taking the role
+// away from the last admin is a 409.
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+	id, err := userID(r)
+	if err != nil {
+		badRequest(w, r, "User id must be an integer")
+		return
+	}
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, r, "Unable to decode the request body. "+err.Error())
+		return
+	}
+	req.Role = strings.ToLower(strings.TrimSpace(req.Role))
+	if _, ok := rolePermissions[req.Role]; !ok {
+		validationFailed(w, r, []fieldError{{Field: "Role", Message: "Role must be one of " + strings.Join(store.Roles, ", ")}})
+		return
+	}
+
+	user, err := users.SetUserRole(id, req.Role)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
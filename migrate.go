@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"go-postgres/middleware"
+	"go-postgres/migrations"
+	"go-postgres/store"
+	"strconv"
+)
+
+// runMigrate handles the migrate subcommand against the postgres store
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs one of: up, down [n], status, rescale-ratings min max [step]")
+	}
+
+	s, err := store.Open("postgres")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	pg := s.(*store.PostgresStore)
+
+	switch args[0] {
+	case "up":
+		return migrateUp(pg)
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("migrate down needs a positive number of migrations, got %q", args[1])
+			}
+		}
+		m, err := migrations.New(pg.DB())
+		if err != nil {
+			return err
+		}
+		ran, err := m.Down(n)
+		for _, mig := range ran {
+			fmt.Printf("rolled back %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		m, err := migrations.New(pg.DB())
+		if err != nil {
+			return err
+		}
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", st.Version, st.Name, state)
+		}
+		return nil
+	case "rescale-ratings":
+		return rescaleRatings(pg, args[1:])
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// migrateUp applies every pending migration and reports what ran
+func migrateUp(pg *store.PostgresStore) error {
+	m, err := migrations.New(pg.DB())
+	if err != nil {
+		return err
+	}
+	ran, err := m.Up()
+	for _, mig := range ran {
+		fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+	}
+	return err
+}
+
+// rescaleRatings converts every review from the scale given in args, which
+// must be the one the ratings are recorded as stored on, to the one configured
+// in the environment
+func rescaleRatings(pg *store.PostgresStore, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("migrate rescale-ratings needs the min and max of the old scale and optionally its step")
+	}
+	var from store.RatingScale
+	for i, value := range []*float64{&from.Min, &from.Max, &from.Step}[:len(args)] {
+		n, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return fmt.Errorf("migrate rescale-ratings needs numbers, got %q", args[i])
+		}
+		*value = n
+	}
+	if err := from.Check(); err != nil {
+		return err
+	}
+	to, err := middleware.RatingScaleFromEnv()
+	if err != nil {
+		return err
+	}
+
+	n, err := pg.RescaleRatings(from, to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rescaled %d reviews from %s to %s\n", n, from, to)
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package migrations holds the versioned postgres schema, embedded in the
+// binary, and the runner that applies and rolls it back.
+//
+// Each version is a pair of files in sql/ named NNNN_name.up.sql and
+// NNNN_name.down.sql. Applied versions are recorded in schema_migrations along
+// with a checksum of the up file, so editing a migration after it has shipped
+// is caught instead of silently diverging.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey is the postgres advisory lock held while migrating so two servers
+// starting at once do not both run the same migration
+const lockKey = 7240517
+
+var filePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load reads the embedded migrations ordered by version
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := filePattern.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+			sum := sha256.Sum256(body)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	var migrations []Migration
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrator applies the embedded migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a migrator for db using the embedded migrations
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// applied maps every recorded version to its checksum and time applied
+type applied map[int]struct {
+	checksum string
+	at       time.Time
+}
+
+// prepare creates the version table if needed and reads what has been applied
+func (m *Migrator) prepare(conn *sql.Conn) (applied, error) {
+	_, err := conn.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		checksum   TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(context.Background(), `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(applied)
+	for rows.Next() {
+		var version int
+		var entry struct {
+			checksum string
+			at       time.Time
+		}
+		if err := rows.Scan(&version, &entry.checksum, &entry.at); err != nil {
+			return nil, err
+		}
+		done[version] = entry
+	}
+
+	return done, rows.Err()
+}
+
+// verify checks every applied migration still matches the embedded file and
+// that the database is not ahead of this binary
+func (m *Migrator) verify(done applied) error {
+	known := make(map[int]Migration)
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+	for version, entry := range done {
+		mig, ok := known[version]
+		if !ok {
+			return fmt.Errorf("database has migration %d applied but this binary does not know it", version)
+		}
+		if mig.Checksum != entry.checksum {
+			return fmt.Errorf("migration %d (%s) has been modified since it was applied", version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// locked runs fn on a single connection while holding the migration lock
+func (m *Migrator) locked(fn func(conn *sql.Conn, done applied) error) error {
+	conn, err := m.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	done, err := m.prepare(conn)
+	if err != nil {
+		return err
+	}
+	if err := m.verify(done); err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
+
+// Up applies every pending migration in order and returns the ones it ran
+func (m *Migrator) Up() ([]Migration, error) {
+	var ran []Migration
+	err := m.locked(func(conn *sql.Conn, done applied) error {
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			err := inTx(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+					mig.Version, mig.Name, mig.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) up: %v", mig.Version, mig.Name, err)
+			}
+			ran = append(ran, mig)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down rolls back the n most recently applied migrations and returns them
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	var ran []Migration
+	err := m.locked(func(conn *sql.Conn, done applied) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(ran) < n; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			err := inTx(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) down: %v", mig.Version, mig.Name, err)
+			}
+			ran = append(ran, mig)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.locked(func(conn *sql.Conn, done applied) error {
+		for _, mig := range m.migrations {
+			entry, ok := done[mig.Version]
+			statuses = append(statuses, Status{Migration: mig, Applied: ok, AppliedAt: entry.at})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// inTx runs fn in a transaction on conn, committing only if it succeeds
+func inTx(conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations were embedded")
+	}
+	//versions must start at 1 and have no gaps so the order is unambiguous
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			t.Errorf("migration %d (%s) is out of sequence, want version %d", mig.Version, mig.Name, i+1)
+		}
+		if mig.Checksum == "" {
+			t.Errorf("migration %d (%s) has no checksum", mig.Version, mig.Name)
+		}
+	}
+}
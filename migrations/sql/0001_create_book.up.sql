@@ -0,0 +1,11 @@
+-- the book table as it has existed since the first release, so IF NOT EXISTS
+-- lets databases created by hand adopt the migration history untouched
+CREATE TABLE IF NOT EXISTS book (
+	id           SERIAL PRIMARY KEY,
+	title        TEXT NOT NULL DEFAULT '',
+	author       TEXT NOT NULL DEFAULT '',
+	publisher    TEXT NOT NULL DEFAULT '',
+	publish_date DATE NOT NULL,
+	rating       DOUBLE PRECISION NOT NULL DEFAULT 0,
+	status       BOOLEAN NOT NULL DEFAULT FALSE
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS book_publish_date_idx;
+DROP INDEX IF EXISTS book_rating_idx;
+DROP INDEX IF EXISTS book_publisher_lower_idx;
+DROP INDEX IF EXISTS book_author_lower_idx;
@@ -0,0 +1,5 @@
+-- back the author, publisher, rating and publish date filters on GET /api/book
+CREATE INDEX IF NOT EXISTS book_author_lower_idx ON book (lower(author));
+CREATE INDEX IF NOT EXISTS book_publisher_lower_idx ON book (lower(publisher));
+CREATE INDEX IF NOT EXISTS book_rating_idx ON book (rating);
+CREATE INDEX IF NOT EXISTS book_publish_date_idx ON book (publish_date);
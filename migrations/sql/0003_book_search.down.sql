@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS book_search_vector_idx;
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,8 @@
+-- weighted full-text search over title (A), author (B) and publisher (C)
+ALTER TABLE book ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(author, '')), 'B') ||
+	setweight(to_tsvector('english', coalesce(publisher, '')), 'C')
+) STORED;
+
+CREATE INDEX book_search_vector_idx ON book USING GIN (search_vector);
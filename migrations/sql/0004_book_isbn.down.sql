@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS book_isbn13_idx;
+ALTER TABLE book DROP COLUMN IF EXISTS isbn13;
@@ -0,0 +1,4 @@
+-- books are identified by their ISBN-13; books without one are allowed
+ALTER TABLE book ADD COLUMN isbn13 CHAR(13);
+
+CREATE UNIQUE INDEX book_isbn13_idx ON book (isbn13) WHERE isbn13 IS NOT NULL;
@@ -0,0 +1,3 @@
+-- book.author still holds the names, so only the credits are lost
+DROP TABLE IF EXISTS book_author;
+DROP TABLE IF EXISTS author;
@@ -0,0 +1,43 @@
+-- authors are people, matched on their name ignoring case, spaces and full stops
+CREATE TABLE author (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL CHECK (name <> ''),
+	name_key TEXT GENERATED ALWAYS AS (lower(regexp_replace(name, '[[:space:].]', '', 'g'))) STORED
+);
+
+CREATE UNIQUE INDEX author_name_key_idx ON author (name_key);
+
+-- the people credited on each book, in order
+CREATE TABLE book_author (
+	book_id INTEGER NOT NULL REFERENCES book (id) ON DELETE CASCADE,
+	author_id INTEGER NOT NULL REFERENCES author (id),
+	role TEXT NOT NULL DEFAULT 'author' CHECK (role IN ('author', 'editor', 'translator', 'illustrator')),
+	position INTEGER NOT NULL,
+	PRIMARY KEY (book_id, author_id, role)
+);
+
+CREATE INDEX book_author_author_id_idx ON book_author (author_id);
+
+-- split the existing author strings the same way store.SplitAuthors does
+CREATE TEMPORARY TABLE split_author ON COMMIT DROP AS
+SELECT b.id AS book_id, trim(s.name) AS name, s.position
+FROM book b, regexp_split_to_table(b.author, '\s*(,|&|;|\s+and\s+)\s*') WITH ORDINALITY AS s(name, position)
+WHERE trim(s.name) <> '';
+
+INSERT INTO author (name)
+SELECT DISTINCT ON (lower(regexp_replace(name, '[[:space:].]', '', 'g'))) name
+FROM split_author
+ORDER BY lower(regexp_replace(name, '[[:space:].]', '', 'g')), book_id, position;
+
+INSERT INTO book_author (book_id, author_id, role, position)
+SELECT s.book_id, a.id, 'author', s.position - 1
+FROM split_author s
+JOIN author a ON a.name_key = lower(regexp_replace(s.name, '[[:space:].]', '', 'g'))
+ON CONFLICT DO NOTHING;
+
+-- rewrite the strings from the credits so every spelling of a name matches
+UPDATE book SET author = COALESCE((
+	SELECT string_agg(a.name, ', ' ORDER BY ba.position)
+	FROM book_author ba JOIN author a ON a.id = ba.author_id
+	WHERE ba.book_id = book.id AND ba.role = 'author'), '')
+WHERE id IN (SELECT book_id FROM book_author);
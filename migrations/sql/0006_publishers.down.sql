@@ -0,0 +1,3 @@
+-- book.publisher still holds the names, so only the links and imprints are lost
+ALTER TABLE book DROP COLUMN IF EXISTS publisher_id;
+DROP TABLE IF EXISTS publisher;
@@ -0,0 +1,25 @@
+-- publishers, and imprints as publishers with a parent, matched on their name
+-- ignoring case, spaces and full stops like authors are
+CREATE TABLE publisher (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL CHECK (name <> ''),
+	name_key TEXT GENERATED ALWAYS AS (lower(regexp_replace(name, '[[:space:].]', '', 'g'))) STORED,
+	parent_id INTEGER REFERENCES publisher (id) CHECK (parent_id <> id)
+);
+
+CREATE UNIQUE INDEX publisher_name_key_idx ON publisher (name_key);
+CREATE INDEX publisher_parent_id_idx ON publisher (parent_id);
+
+ALTER TABLE book ADD COLUMN publisher_id INTEGER REFERENCES publisher (id);
+CREATE INDEX book_publisher_id_idx ON book (publisher_id);
+
+-- one publisher for every spelling of the names already on books
+INSERT INTO publisher (name)
+SELECT DISTINCT ON (lower(regexp_replace(trim(publisher), '[[:space:].]', '', 'g'))) trim(publisher)
+FROM book
+WHERE trim(publisher) <> ''
+ORDER BY lower(regexp_replace(trim(publisher), '[[:space:].]', '', 'g')), id;
+
+UPDATE book SET publisher_id = p.id, publisher = p.name
+FROM publisher p
+WHERE p.name_key = lower(regexp_replace(trim(book.publisher), '[[:space:].]', '', 'g'));
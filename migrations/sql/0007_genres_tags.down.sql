@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS book_tag;
+DROP TABLE IF EXISTS book_genre;
+DROP TABLE IF EXISTS genre;
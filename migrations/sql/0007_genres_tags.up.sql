@@ -0,0 +1,27 @@
+-- a tree of genres, matched on their name like publishers are
+CREATE TABLE genre (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL CHECK (name <> ''),
+	name_key TEXT GENERATED ALWAYS AS (lower(regexp_replace(name, '[[:space:].]', '', 'g'))) STORED,
+	parent_id INTEGER REFERENCES genre (id) CHECK (parent_id <> id)
+);
+
+CREATE UNIQUE INDEX genre_name_key_idx ON genre (name_key);
+CREATE INDEX genre_parent_id_idx ON genre (parent_id);
+
+CREATE TABLE book_genre (
+	book_id INTEGER NOT NULL REFERENCES book (id) ON DELETE CASCADE,
+	genre_id INTEGER NOT NULL REFERENCES genre (id) ON DELETE CASCADE,
+	PRIMARY KEY (book_id, genre_id)
+);
+
+CREATE INDEX book_genre_genre_id_idx ON book_genre (genre_id);
+
+-- tags are free-form lower case labels, so they need no table of their own
+CREATE TABLE book_tag (
+	book_id INTEGER NOT NULL REFERENCES book (id) ON DELETE CASCADE,
+	tag TEXT NOT NULL CHECK (tag <> ''),
+	PRIMARY KEY (book_id, tag)
+);
+
+CREATE INDEX book_tag_tag_idx ON book_tag (tag);
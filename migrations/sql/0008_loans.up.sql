@@ -0,0 +1,17 @@
+-- every checkout of a book; a loan is open until returned_at is set
+CREATE TABLE loan (
+	id SERIAL PRIMARY KEY,
+	book_id INTEGER NOT NULL REFERENCES book (id) ON DELETE CASCADE,
+	patron TEXT NOT NULL CHECK (patron <> ''),
+	checked_out_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	due_at TIMESTAMPTZ NOT NULL,
+	returned_at TIMESTAMPTZ,
+	renewals INTEGER NOT NULL DEFAULT 0 CHECK (renewals >= 0),
+	CHECK (due_at > checked_out_at),
+	CHECK (returned_at >= checked_out_at)
+);
+
+-- a book can only be out on one loan at a time
+CREATE UNIQUE INDEX loan_open_book_idx ON loan (book_id) WHERE returned_at IS NULL;
+CREATE INDEX loan_book_id_idx ON loan (book_id, checked_out_at);
+CREATE INDEX loan_patron_idx ON loan (patron, checked_out_at);
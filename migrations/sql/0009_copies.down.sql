@@ -0,0 +1,9 @@
+-- a book with several copies out keeps only its most recent open loan
+UPDATE loan SET returned_at = now()
+WHERE returned_at IS NULL AND id NOT IN (
+	SELECT max(id) FROM loan WHERE returned_at IS NULL GROUP BY book_id
+);
+DROP INDEX IF EXISTS loan_open_copy_idx;
+CREATE UNIQUE INDEX loan_open_book_idx ON loan (book_id) WHERE returned_at IS NULL;
+ALTER TABLE loan DROP COLUMN IF EXISTS copy_id;
+DROP TABLE IF EXISTS copy;
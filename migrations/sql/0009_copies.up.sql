@@ -0,0 +1,25 @@
+-- the physical copies of each book
+CREATE TABLE copy (
+	id SERIAL PRIMARY KEY,
+	book_id INTEGER NOT NULL REFERENCES book (id) ON DELETE CASCADE,
+	barcode TEXT NOT NULL CHECK (barcode <> ''),
+	condition TEXT NOT NULL DEFAULT 'good'
+		CHECK (condition IN ('new', 'good', 'fair', 'poor', 'damaged', 'lost')),
+	acquired_on DATE,
+	location TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX copy_barcode_idx ON copy (barcode);
+CREATE INDEX copy_book_id_idx ON copy (book_id);
+
+-- every book so far stood for one physical copy, so give each one a copy with
+-- a placeholder barcode staff can replace with the real one
+INSERT INTO copy (book_id, barcode) SELECT id, 'BOOK-' || id FROM book;
+
+-- loans are now of copies; a copy removed from the inventory leaves its loans
+-- in the book's history
+ALTER TABLE loan ADD COLUMN copy_id INTEGER REFERENCES copy (id) ON DELETE SET NULL;
+UPDATE loan SET copy_id = c.id FROM copy c WHERE c.book_id = loan.book_id;
+
+DROP INDEX loan_open_book_idx;
+CREATE UNIQUE INDEX loan_open_copy_idx ON loan (copy_id) WHERE returned_at IS NULL;
@@ -0,0 +1,26 @@
+-- accounts that can sign in; user is a reserved word, hence app_user
+CREATE TABLE app_user (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL CHECK (username <> ''),
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX app_user_username_idx ON app_user (lower(username));
+
+-- refresh tokens handed out at login, kept as sha256 hashes
+CREATE TABLE refresh_token (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES app_user (id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX refresh_token_user_id_idx ON refresh_token (user_id);
+
+-- access tokens revoked before they expire, kept only until they would have
+CREATE TABLE revoked_token (
+	token_id TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+-- what each user may do; nobody is made an admin here, the first admin comes
+-- from ADMIN_USERNAME when the server starts
+ALTER TABLE app_user ADD COLUMN role TEXT NOT NULL DEFAULT 'reader'
+	CHECK (role IN ('reader', 'staff', 'admin'));
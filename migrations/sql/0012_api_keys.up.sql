@@ -0,0 +1,12 @@
+-- keys scripts call the api with instead of a user's password, kept as sha256
+-- hashes; prefix is the start of the key, shown so a key can be recognised
+CREATE TABLE api_key (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL CHECK (name <> ''),
+	prefix TEXT NOT NULL UNIQUE,
+	key_hash TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL CHECK (role IN ('reader', 'staff', 'admin')),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS book_rating_idx;
+ALTER TABLE book DROP COLUMN IF EXISTS rating_count, DROP COLUMN IF EXISTS rating;
+ALTER TABLE book RENAME COLUMN legacy_rating TO rating;
+CREATE INDEX IF NOT EXISTS book_rating_idx ON book (rating);
+DROP TABLE IF EXISTS review;
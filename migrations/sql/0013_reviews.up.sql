@@ -0,0 +1,24 @@
+-- each user's rating and review of a book, at most one per user per book
+CREATE TABLE review (
+	id SERIAL PRIMARY KEY,
+	book_id INTEGER NOT NULL REFERENCES book (id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES app_user (id) ON DELETE CASCADE,
+	rating DOUBLE PRECISION NOT NULL,
+	body TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (book_id, user_id)
+);
+
+CREATE INDEX review_user_id_idx ON review (user_id, created_at);
+
+-- book.rating is now the average of the book's reviews and rating_count the
+-- number of them, both kept up to date by the store. The single ratings set
+-- before reviews cannot be credited to anyone, so they are set aside in
+-- legacy_rating rather than dropped.
+ALTER TABLE book RENAME COLUMN rating TO legacy_rating;
+DROP INDEX IF EXISTS book_rating_idx;
+ALTER TABLE book
+	ADD COLUMN rating DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX book_rating_idx ON book (rating);
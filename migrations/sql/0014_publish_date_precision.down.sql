@@ -0,0 +1 @@
+ALTER TABLE book DROP COLUMN publish_date_precision;
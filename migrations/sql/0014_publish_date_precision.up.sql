@@ -0,0 +1,4 @@
+-- how precisely publish_date is known; a month or year is stored as its first
+-- day, so '1886' is 1886-01-01 with year precision. Existing dates were full dates.
+ALTER TABLE book ADD COLUMN publish_date_precision TEXT NOT NULL DEFAULT 'day'
+	CHECK (publish_date_precision IN ('day', 'month', 'year'));
@@ -0,0 +1,4 @@
+-- books still in the trash were deleted, so finish deleting them
+DELETE FROM book WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS book_deleted_at_idx;
+ALTER TABLE book DROP COLUMN deleted_at;
@@ -0,0 +1,4 @@
+-- deleting a book moves it to the trash by setting deleted_at; it is only
+-- removed for good, with its copies, loans and reviews, when the trash is purged
+ALTER TABLE book ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX book_deleted_at_idx ON book (deleted_at) WHERE deleted_at IS NOT NULL;
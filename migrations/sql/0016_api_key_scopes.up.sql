@@ -0,0 +1,7 @@
+-- the parts of the api each key may use; keys made before scopes existed keep
+-- reaching everything they could
+ALTER TABLE api_key ADD COLUMN scopes TEXT[] NOT NULL
+	DEFAULT ARRAY['books', 'authors', 'publishers', 'genres', 'copies', 'loans', 'reviews', 'stats']
+	CHECK (cardinality(scopes) > 0
+		AND scopes <@ ARRAY['books', 'authors', 'publishers', 'genres', 'copies', 'loans', 'reviews', 'stats']);
+ALTER TABLE api_key ALTER COLUMN scopes DROP DEFAULT;
@@ -0,0 +1 @@
+UPDATE book SET rating = 0 WHERE rating_count = 0;
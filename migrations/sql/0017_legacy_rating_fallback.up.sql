@@ -0,0 +1,3 @@
+-- a book without reviews shows the rating it had before reviews existed, as
+-- 0013 left every such book rated 0
+UPDATE book SET rating = legacy_rating WHERE rating_count = 0;
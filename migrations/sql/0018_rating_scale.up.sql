@@ -0,0 +1,10 @@
+-- the scale the stored ratings are on, a single row. It is recorded the first
+-- time the server starts and changed only by migrate rescale-ratings, so a
+-- server configured with another scale refuses to start.
+CREATE TABLE rating_scale (
+	id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+	min DOUBLE PRECISION NOT NULL,
+	max DOUBLE PRECISION NOT NULL,
+	step DOUBLE PRECISION NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+-- fails while a trashed book shares its ISBN with another book
+DROP INDEX IF EXISTS book_isbn13_idx;
+CREATE UNIQUE INDEX book_isbn13_idx ON book (isbn13) WHERE isbn13 IS NOT NULL;
@@ -0,0 +1,4 @@
+-- an ISBN only has to be unique among the books that are not in the trash, so
+-- a trashed book's ISBN can be given to a new book
+DROP INDEX IF EXISTS book_isbn13_idx;
+CREATE UNIQUE INDEX book_isbn13_idx ON book (isbn13) WHERE isbn13 IS NOT NULL AND deleted_at IS NULL;
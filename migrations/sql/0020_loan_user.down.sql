@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS loan_user_id_idx;
+ALTER TABLE loan DROP COLUMN IF EXISTS user_id;
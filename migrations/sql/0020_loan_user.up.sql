@@ -0,0 +1,5 @@
+-- a loan can be made out to a user account, which is what GET /api/auth/me/loans
+-- lists; the free text patron cannot be trusted to name an account, so loans
+-- made before this are not linked to any
+ALTER TABLE loan ADD COLUMN user_id INTEGER REFERENCES app_user (id) ON DELETE SET NULL;
+CREATE INDEX loan_user_id_idx ON loan (user_id) WHERE user_id IS NOT NULL;
@@ -0,0 +1,7 @@
+UPDATE book SET rating = 0 WHERE rating IS NULL;
+UPDATE book SET legacy_rating = 0 WHERE legacy_rating IS NULL;
+ALTER TABLE book
+	ALTER COLUMN rating SET DEFAULT 0,
+	ALTER COLUMN rating SET NOT NULL,
+	ALTER COLUMN legacy_rating SET DEFAULT 0,
+	ALTER COLUMN legacy_rating SET NOT NULL;
@@ -0,0 +1,10 @@
+-- a book without a rating now has a null legacy_rating and rating rather than
+-- 0, so 0 can be a real rating on scales that start at it. Until now 0 only
+-- ever meant none.
+ALTER TABLE book
+	ALTER COLUMN legacy_rating DROP NOT NULL,
+	ALTER COLUMN legacy_rating DROP DEFAULT,
+	ALTER COLUMN rating DROP NOT NULL,
+	ALTER COLUMN rating DROP DEFAULT;
+UPDATE book SET legacy_rating = NULL WHERE legacy_rating = 0;
+UPDATE book SET rating = NULL WHERE rating_count = 0 AND legacy_rating IS NULL;
@@ -1,12 +1,161 @@
 package models
 
+import "time"
+
 // User schema of the user table
 type Book struct {
-	ID           int64   `json:"ID"`
-	Title        string  `json:"Title"`
-	Author       string  `json:"Author"`
-	Publisher    string  `json:"Publisher"`
-	Publish_Date string  `json:"Publish_Date"`
-	Rating       float64 `json:"Rating"`
-	Status       bool    `json:"Status"`
+	ID        int64  `json:"ID"`
+	Title     string `json:"Title"`
+	Author    string `json:"Author"`
+	Publisher string `json:"Publisher"`
+	// Publish_Date is returned as YYYY-MM-DD, YYYY-MM or YYYY as precise as
+	// it is known, which PublishDatePrecision gives as day, month or year. The
+	// precision is read only here; it follows from the date sent.
+	Publish_Date         string `json:"Publish_Date"`
+	PublishDatePrecision string `json:"PublishDatePrecision"`
+	// Rating is the average rating of the book's reviews and RatingCount the
+	// number of them. Until it has any, Rating is the legacy rating the book
+	// was given directly, or null. A Rating sent with a book sets that legacy
+	// rating and null clears it; reviews are given through
+	// /api/book/{id}/reviews.
+	Rating      *float64 `json:"Rating"`
+	RatingCount int64    `json:"RatingCount"`
+	// LegacyRating is the legacy rating as stored, which a patch starts from
+	// because Rating may be the reviews' average. It is not part of the api.
+	LegacyRating *float64 `json:"-"`
+	// Status is true while copies of the book are out and none is left to
+	// lend. It is read only here; it follows from the book's loans.
+	Status bool `json:"Status"`
+	// ISBN_13 is what is stored; ISBN_10 is derived from it for 978 numbers.
+	// Either can be sent when creating or updating a book.
+	ISBN_10 string `json:"ISBN_10,omitempty"`
+	ISBN_13 string `json:"ISBN_13,omitempty"`
+	// Authors credits people on the book in order. Author is kept as the
+	// names credited with the author role, so a book can still be sent with
+	// only Author and it is split into credits.
+	Authors []BookAuthor `json:"Authors,omitempty"`
+	// PublisherID links the book to a publisher or imprint, whose name is then
+	// Publisher. A book sent with only Publisher is linked by that name.
+	PublisherID int64 `json:"PublisherID,omitempty"`
+	// Genres and Tags are read only here; they are assigned and removed
+	// through /api/book/{id}/genres and /api/book/{id}/tags
+	Genres []Genre  `json:"Genres,omitempty"`
+	Tags   []string `json:"Tags,omitempty"`
+	// Copies and AvailableCopies count the physical copies of the book and
+	// those that can be lent right now; copies are managed through
+	// /api/book/{id}/copies
+	Copies          int64 `json:"Copies"`
+	AvailableCopies int64 `json:"AvailableCopies"`
+	// DeletedAt is when the book was moved to the trash; it is only set on
+	// books listed from /api/book/trash
+	DeletedAt *time.Time `json:"DeletedAt,omitempty"`
+}
+
+// Author is a person who can be credited on books
+type Author struct {
+	ID   int64  `json:"ID"`
+	Name string `json:"Name"`
+}
+
+// BookAuthor credits an author on a book. Either ID or Name is enough when
+// writing a book; a Name that is not yet an author becomes one.
+type BookAuthor struct {
+	ID   int64  `json:"ID"`
+	Name string `json:"Name"`
+	Role string `json:"Role"`
+}
+
+// Publisher is a publishing house or, when ParentID is set, one of its imprints
+type Publisher struct {
+	ID       int64  `json:"ID"`
+	Name     string `json:"Name"`
+	ParentID *int64 `json:"ParentID"`
+}
+
+// Genre is a node in the genre taxonomy; ParentID is nil for a top level genre
+type Genre struct {
+	ID       int64  `json:"ID"`
+	Name     string `json:"Name"`
+	ParentID *int64 `json:"ParentID"`
+}
+
+// Tag is a free-form label and the number of books carrying it
+type Tag struct {
+	Name  string `json:"Name"`
+	Books int64  `json:"Books"`
+}
+
+// Copy is one physical copy of a book. AcquiredOn is a YYYY-MM-DD date or
+// empty when unknown. Available is read only: the copy is not on loan and its
+// Condition allows it to be lent.
+type Copy struct {
+	ID         int64  `json:"ID"`
+	BookID     int64  `json:"BookID"`
+	Barcode    string `json:"Barcode"`
+	Condition  string `json:"Condition"`
+	AcquiredOn string `json:"AcquiredOn"`
+	Location   string `json:"Location"`
+	Available  bool   `json:"Available"`
+}
+
+// Loan is one checkout of a copy of a book by a patron. CopyID is 0 once the
+// copy has been removed from the inventory, and UserID is the account the loan
+// was made out to, or 0 for a patron without one. ReturnedAt stays nil while
+// the book is out, and Overdue is worked out when the loan is read.
+type Loan struct {
+	ID           int64      `json:"ID"`
+	BookID       int64      `json:"BookID"`
+	CopyID       int64      `json:"CopyID"`
+	UserID       int64      `json:"UserID"`
+	Patron       string     `json:"Patron"`
+	CheckedOutAt time.Time  `json:"CheckedOutAt"`
+	DueAt        time.Time  `json:"DueAt"`
+	ReturnedAt   *time.Time `json:"ReturnedAt"`
+	Renewals     int        `json:"Renewals"`
+	Overdue      bool       `json:"Overdue"`
+}
+
+// User is someone who can sign in to the API. Role is reader, staff or admin.
+// The password hash never leaves the store.
+type User struct {
+	ID        int64     `json:"ID"`
+	Username  string    `json:"Username"`
+	Role      string    `json:"Role"`
+	CreatedAt time.Time `json:"CreatedAt"`
+}
+
+// Review is one user's rating of a book with an optional text review. A user
+// reviews a book at most once.
+type Review struct {
+	ID        int64     `json:"ID"`
+	BookID    int64     `json:"BookID"`
+	UserID    int64     `json:"UserID"`
+	Username  string    `json:"Username"`
+	Rating    float64   `json:"Rating"`
+	Text      string    `json:"Text"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+}
+
+// APIKey lets a script call the API with the permissions of Role instead of
+// signing in as a user, on the parts of the API named in Scopes. Key, the secret itself, is only ever sent once, when
+// the key is created; afterwards the key is known by its Prefix.
+type APIKey struct {
+	ID         int64      `json:"ID"`
+	Name       string     `json:"Name"`
+	Prefix     string     `json:"Prefix"`
+	Key        string     `json:"Key,omitempty"`
+	Role       string     `json:"Role"`
+	Scopes     []string   `json:"Scopes"`
+	CreatedAt  time.Time  `json:"CreatedAt"`
+	LastUsedAt *time.Time `json:"LastUsedAt"`
+	RevokedAt  *time.Time `json:"RevokedAt"`
+}
+
+// BookMatch is one full-text search hit. Highlights holds the Title, Author and
+// Publisher as HTML, escaped, with every matching word wrapped in <mark></mark>.
+type BookMatch struct {
+	Book       Book              `json:"Book"`
+	Rank       float64           `json:"Rank"`
+	Highlights map[string]string `json:"Highlights"`
 }
@@ -2,20 +2,119 @@ package router
 
 import (
 	"go-postgres/middleware"
+	"go-postgres/store"
+	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
+// route is one endpoint, the least a caller needs to be to use it and the
+// scope an api key needs to call it. Routes without a scope cannot be called
+// with an api key.
+type route struct {
+	method     string
+	path       string
+	handler    http.HandlerFunc
+	permission middleware.Permission
+	scope      string
+}
+
+// routes is every endpoint of the api with its permission. Anyone may read the
+// catalogue, staff may create and update, see who borrowed what and read the
+// connection pool stats, and only admins may delete or manage users and api
+// keys. Any signed in user may review books, see their own loans and export
+// the catalogue. An api key is limited to the scopes it was given and can
+// never manage users, api keys or an account.
+// Search, export and the trash come before /api/book/{id} so they are not
+// taken as ids.
+var routes = []route{
+	{"POST", "/api/auth/register", middleware.Register, middleware.Anyone, ""},
+	{"POST", "/api/auth/login", middleware.Login, middleware.Anyone, ""},
+	{"POST", "/api/auth/refresh", middleware.Refresh, middleware.Anyone, ""},
+	{"POST", "/api/auth/logout", middleware.Logout, middleware.Reader, ""},
+	{"GET", "/api/auth/me", middleware.Me, middleware.Reader, ""},
+	{"GET", "/api/auth/me/reviews", middleware.GetMyReviews, middleware.Reader, ""},
+	{"GET", "/api/auth/me/loans", middleware.GetMyLoans, middleware.Reader, ""},
+
+	{"GET", "/api/users", middleware.GetUsers, middleware.Admin, ""},
+	{"GET", "/api/users/{id}", middleware.GetUser, middleware.Admin, ""},
+	{"PUT", "/api/users/{id}/role", middleware.SetUserRole, middleware.Admin, ""},
+	{"GET", "/api/apikeys", middleware.GetAPIKeys, middleware.Admin, ""},
+	{"POST", "/api/apikeys", middleware.CreateAPIKey, middleware.Admin, ""},
+	{"GET", "/api/apikeys/{id}", middleware.GetAPIKey, middleware.Admin, ""},
+	{"DELETE", "/api/apikeys/{id}", middleware.RevokeAPIKey, middleware.Admin, ""},
+
+	{"GET", "/api/book/search", middleware.SearchBooks, middleware.Anyone, store.ScopeBooks},
+	{"GET", "/api/book/export", middleware.ExportBooks, middleware.Reader, store.ScopeBooks},
+	{"GET", "/api/book/trash", middleware.GetTrash, middleware.Staff, store.ScopeBooks},
+	{"GET", "/api/book/isbn/{isbn}", middleware.GetBookByISBN, middleware.Anyone, store.ScopeBooks},
+	{"GET", "/api/book/{id}", middleware.GetBook, middleware.Anyone, store.ScopeBooks},
+	{"GET", "/api/book", middleware.GetAllBooks, middleware.Anyone, store.ScopeBooks},
+	{"POST", "/api/newbook", middleware.CreateBook, middleware.Staff, store.ScopeBooks},
+	{"POST", "/api/book/import", middleware.ImportBooks, middleware.Staff, store.ScopeBooks},
+	{"PUT", "/api/book/{id}", middleware.UpdateBook, middleware.Staff, store.ScopeBooks},
+	{"PATCH", "/api/book/{id}", middleware.PatchBook, middleware.Staff, store.ScopeBooks},
+	{"PUT", "/api/book/{id}/genres/{genreId}", middleware.AddBookGenre, middleware.Staff, store.ScopeGenres},
+	{"DELETE", "/api/book/{id}/genres/{genreId}", middleware.RemoveBookGenre, middleware.Staff, store.ScopeGenres},
+	{"PUT", "/api/book/{id}/tags/{tag}", middleware.AddBookTag, middleware.Staff, store.ScopeBooks},
+	{"DELETE", "/api/book/{id}/tags/{tag}", middleware.RemoveBookTag, middleware.Staff, store.ScopeBooks},
+	{"GET", "/api/book/{id}/loans", middleware.GetBookLoans, middleware.Staff, store.ScopeLoans},
+	{"GET", "/api/book/{id}/copies", middleware.GetBookCopies, middleware.Anyone, store.ScopeCopies},
+	{"POST", "/api/book/{id}/copies", middleware.CreateCopy, middleware.Staff, store.ScopeCopies},
+	{"GET", "/api/book/{id}/reviews", middleware.GetBookReviews, middleware.Anyone, store.ScopeReviews},
+	{"POST", "/api/book/{id}/reviews", middleware.CreateReview, middleware.Reader, store.ScopeReviews},
+	{"DELETE", "/api/deletebook/{id}", middleware.DeleteBook, middleware.Admin, store.ScopeBooks},
+	{"POST", "/api/book/{id}/restore", middleware.RestoreBook, middleware.Admin, store.ScopeBooks},
+	{"GET", "/api/stats/pool", middleware.PoolStats, middleware.Staff, store.ScopeStats},
+
+	{"GET", "/api/authors", middleware.GetAuthors, middleware.Anyone, store.ScopeAuthors},
+	{"POST", "/api/authors", middleware.CreateAuthor, middleware.Staff, store.ScopeAuthors},
+	{"GET", "/api/authors/{id}", middleware.GetAuthor, middleware.Anyone, store.ScopeAuthors},
+	{"PUT", "/api/authors/{id}", middleware.UpdateAuthor, middleware.Staff, store.ScopeAuthors},
+	{"DELETE", "/api/authors/{id}", middleware.DeleteAuthor, middleware.Admin, store.ScopeAuthors},
+
+	{"GET", "/api/publishers", middleware.GetPublishers, middleware.Anyone, store.ScopePublishers},
+	{"POST", "/api/publishers", middleware.CreatePublisher, middleware.Staff, store.ScopePublishers},
+	{"GET", "/api/publishers/{id}", middleware.GetPublisher, middleware.Anyone, store.ScopePublishers},
+	{"GET", "/api/publishers/{id}/books", middleware.GetPublisherBooks, middleware.Anyone, store.ScopePublishers},
+	{"PUT", "/api/publishers/{id}", middleware.UpdatePublisher, middleware.Staff, store.ScopePublishers},
+	{"DELETE", "/api/publishers/{id}", middleware.DeletePublisher, middleware.Admin, store.ScopePublishers},
+
+	{"GET", "/api/genres", middleware.GetGenres, middleware.Anyone, store.ScopeGenres},
+	{"POST", "/api/genres", middleware.CreateGenre, middleware.Staff, store.ScopeGenres},
+	{"GET", "/api/genres/{id}", middleware.GetGenre, middleware.Anyone, store.ScopeGenres},
+	{"PUT", "/api/genres/{id}", middleware.UpdateGenre, middleware.Staff, store.ScopeGenres},
+	{"DELETE", "/api/genres/{id}", middleware.DeleteGenre, middleware.Admin, store.ScopeGenres},
+	{"GET", "/api/tags", middleware.GetTags, middleware.Anyone, store.ScopeBooks},
+
+	{"GET", "/api/loans", middleware.GetLoans, middleware.Staff, store.ScopeLoans},
+	{"POST", "/api/loans", middleware.CheckOutBook, middleware.Staff, store.ScopeLoans},
+	{"GET", "/api/loans/{id}", middleware.GetLoan, middleware.Staff, store.ScopeLoans},
+	{"POST", "/api/loans/{id}/return", middleware.ReturnLoan, middleware.Staff, store.ScopeLoans},
+	{"POST", "/api/loans/{id}/renew", middleware.RenewLoan, middleware.Staff, store.ScopeLoans},
+	{"GET", "/api/patrons/{patron}/loans", middleware.GetPatronLoans, middleware.Staff, store.ScopeLoans},
+
+	{"GET", "/api/copies/{id}", middleware.GetCopy, middleware.Anyone, store.ScopeCopies},
+	{"PUT", "/api/copies/{id}", middleware.UpdateCopy, middleware.Staff, store.ScopeCopies},
+	{"DELETE", "/api/copies/{id}", middleware.DeleteCopy, middleware.Admin, store.ScopeCopies},
+
+	// readers may only change their own reviews, which the handlers check
+	{"GET", "/api/reviews/{id}", middleware.GetReview, middleware.Anyone, store.ScopeReviews},
+	{"PUT", "/api/reviews/{id}", middleware.UpdateReview, middleware.Reader, store.ScopeReviews},
+	{"DELETE", "/api/reviews/{id}", middleware.DeleteReview, middleware.Reader, store.ScopeReviews},
+}
+
 // Router is exported and used in main.go
 func Router() *mux.Router {
 
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(middleware.NotFound)
+	// identify the caller from their access token or api key, see middleware.Authenticate
+	router.Use(middleware.Authenticate)
 
-	router.HandleFunc("/api/book/{id}", middleware.GetBook).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/book", middleware.GetAllBooks).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/newbook", middleware.CreateBook).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/book/{id}", middleware.UpdateBook).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/deletebook/{id}", middleware.DeleteBook).Methods("DELETE", "OPTIONS")
+	for _, rt := range routes {
+		router.Handle(rt.path, middleware.Require(rt.permission, rt.scope, rt.handler)).Methods(rt.method, "OPTIONS")
+	}
 
 	return router
 }
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"go-postgres/models"
+	"regexp"
+	"strings"
+)
+
+// RoleAuthor is the role a credit gets when none is given, and the only role
+// whose names make up a book's Author string
+const RoleAuthor = "author"
+
+// AuthorRoles are the roles a person can be credited with on a book
+var AuthorRoles = []string{RoleAuthor, "editor", "translator", "illustrator"}
+
+// authorSeparator splits an Author string such as "Terry Pratchett & Neil
+// Gaiman" or "Kernighan, Ritchie" into names. The 0005 migration uses the same
+// pattern to split the strings already in the book table.
+var authorSeparator = regexp.MustCompile(`\s*(?:,|&|;|\s+and\s+)\s*`)
+
+// SplitAuthors returns the names in an Author string, in order
+func SplitAuthors(author string) []string {
+	var names []string
+	for _, name := range authorSeparator.Split(author, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// nameKey is what makes two author or publisher names the same: case, spaces
+// and full stops are ignored so "J.K. Rowling" and "j. k. rowling" match. It
+// mirrors the name_key column of the author and publisher tables.
+func nameKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// credits returns the people to credit on book: its Authors, or when it has
+// none the names in its Author string credited as authors
+func credits(book models.Book) []models.BookAuthor {
+	var out []models.BookAuthor
+	if len(book.Authors) > 0 {
+		for _, c := range book.Authors {
+			if c.Role == "" {
+				c.Role = RoleAuthor
+			}
+			c.Name = strings.TrimSpace(c.Name)
+			out = append(out, c)
+		}
+		return out
+	}
+	for _, name := range SplitAuthors(book.Author) {
+		out = append(out, models.BookAuthor{Name: name, Role: RoleAuthor})
+	}
+	return out
+}
+
+// authorString joins the names credited with the author role, in order
+func authorString(credits []models.BookAuthor) string {
+	var names []string
+	for _, c := range credits {
+		if c.Role == RoleAuthor {
+			names = append(names, c.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,13 @@
+package store
+
+// Conditions lists the states a copy can be in, best first
+var Conditions = []string{"new", "good", "fair", "poor", "damaged", "lost"}
+
+// unlendable lists the conditions a copy cannot be lent in; the same list is
+// in the availableCopy query
+var unlendable = map[string]bool{"damaged": true, "lost": true}
+
+// lendable reports whether a copy in condition can go out on loan
+func lendable(condition string) bool {
+	return !unlendable[condition]
+}
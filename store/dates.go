@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"time"
+)
+
+// the precisions a Publish_Date can be known to
+const (
+	PrecisionDay   = "day"
+	PrecisionMonth = "month"
+	PrecisionYear  = "year"
+)
+
+// dateLayouts is how each precision is written, both when parsed and in the
+// canonical form books are returned in
+var dateLayouts = map[string]string{
+	PrecisionDay:   "2006-01-02",
+	PrecisionMonth: "2006-01",
+	PrecisionYear:  "2006",
+}
+
+// ParsePublishDate reads a Publish_Date given as an ISO 8601 full date
+// (1886-02-15), year and month (1886-02), year (1886) or RFC 3339 timestamp,
+// whose date is taken as written. It returns the first day the date covers and
+// how precise it is. Dates postgres would refuse are rejected with ErrInvalid.
+func ParsePublishDate(date string) (time.Time, string, error) {
+	for _, precision := range []string{PrecisionDay, PrecisionMonth, PrecisionYear} {
+		if t, err := time.Parse(dateLayouts[precision], date); err == nil {
+			return t, precision, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), PrecisionDay, nil
+	}
+	return time.Time{}, "", fmt.Errorf("%w: publish date %q", ErrInvalid, date)
+}
+
+// FormatPublishDate writes a date in the canonical form for its precision,
+// so a year-precise date stays "1886"
+func FormatPublishDate(t time.Time, precision string) string {
+	layout, ok := dateLayouts[precision]
+	if !ok {
+		layout = dateLayouts[PrecisionDay]
+	}
+	return t.UTC().Format(layout)
+}
+
+// normalizeDate puts a book's Publish_Date in its canonical form and records
+// its precision, as both stores return it
+func normalizeDate(book *models.Book) error {
+	t, precision, err := ParsePublishDate(book.Publish_Date)
+	if err != nil {
+		return err
+	}
+	book.Publish_Date = FormatPublishDate(t, precision)
+	book.PublishDatePrecision = precision
+	return nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound means there is no record with the requested id
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write clashes with a record that already exists
+	// or is still referenced
+	ErrConflict = errors.New("conflicts with an existing record")
+	// ErrInvalid means the database rejected the values in the record
+	ErrInvalid = errors.New("invalid record")
+)
+
+// translateError turns postgres constraint and data errors into the store's
+// sentinel errors so the handlers never need to know about lib/pq
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code.Class() {
+	case "23": // integrity constraint violation
+		if pqErr.Code.Name() == "unique_violation" {
+			return fmt.Errorf("%w: %s", ErrConflict, pqErr.Message)
+		}
+		return fmt.Errorf("%w: %s", ErrInvalid, pqErr.Message)
+	case "22": // data exception, e.g. a publish date postgres cannot parse
+		return fmt.Errorf("%w: %s", ErrInvalid, pqErr.Message)
+	}
+
+	return err
+}
+
+// isForeignKeyViolation reports whether err is postgres refusing to remove a
+// row that other rows still reference
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation"
+}
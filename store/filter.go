@@ -0,0 +1,181 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"strings"
+	"time"
+)
+
+// BookFilter narrows the books returned by ListBooks. Zero fields are
+// ignored and every set field must match (AND semantics).
+type BookFilter struct {
+	// Author and Publisher match the whole value, ignoring case
+	Author    string
+	Publisher string
+	// TitleContains matches any title containing it, ignoring case
+	TitleContains string
+	Status        *bool
+	// MinRating, MaxRating, PublishedFrom and PublishedTo are inclusive bounds
+	MinRating     *float64
+	MaxRating     *float64
+	PublishedFrom *time.Time
+	PublishedTo   *time.Time
+	// PublisherID matches books by that publisher or any of its imprints,
+	// however deeply nested
+	PublisherID *int64
+	// Genre matches books in that genre or any genre below it
+	Genre *int64
+	// Tag matches books carrying the tag
+	Tag string
+	// Deleted matches the books in the trash instead of the live ones
+	Deleted bool
+
+	// imprints holds PublisherID and every imprint under it, and subgenres
+	// Genre and every genre below it; the memory store fills them in before
+	// calling Matches
+	imprints  map[int64]bool
+	subgenres map[int64]bool
+}
+
+// Matches reports whether book passes every condition in the filter
+func (f BookFilter) Matches(book models.Book) bool {
+	if (book.DeletedAt != nil) != f.Deleted {
+		return false
+	}
+	if f.Author != "" && !strings.EqualFold(book.Author, f.Author) {
+		return false
+	}
+	if f.Publisher != "" && !strings.EqualFold(book.Publisher, f.Publisher) {
+		return false
+	}
+	if f.TitleContains != "" && !strings.Contains(strings.ToLower(book.Title), strings.ToLower(f.TitleContains)) {
+		return false
+	}
+	if f.Status != nil && book.Status != *f.Status {
+		return false
+	}
+	//a book without a rating is outside any rating range
+	if f.MinRating != nil && (book.Rating == nil || *book.Rating < *f.MinRating) {
+		return false
+	}
+	if f.MaxRating != nil && (book.Rating == nil || *book.Rating > *f.MaxRating) {
+		return false
+	}
+	if f.PublisherID != nil {
+		if f.imprints == nil && book.PublisherID != *f.PublisherID {
+			return false
+		}
+		if f.imprints != nil && !f.imprints[book.PublisherID] {
+			return false
+		}
+	}
+	if f.Genre != nil && !f.inGenre(book) {
+		return false
+	}
+	if f.Tag != "" && !hasTag(book, f.Tag) {
+		return false
+	}
+	if f.PublishedFrom != nil || f.PublishedTo != nil {
+		// a date known only to its month or year counts as its first day, as in postgres
+		published, _, err := ParsePublishDate(book.Publish_Date)
+		if err != nil {
+			return false
+		}
+		if f.PublishedFrom != nil && published.Before(*f.PublishedFrom) {
+			return false
+		}
+		if f.PublishedTo != nil && published.After(*f.PublishedTo) {
+			return false
+		}
+	}
+	return true
+}
+
+// where builds a parameterized WHERE clause for the filter, numbering the
+// placeholders from $1. It always picks either the live or the trashed books.
+func (f BookFilter) where() (string, []interface{}) {
+	conds := []string{"deleted_at IS NULL"}
+	if f.Deleted {
+		conds[0] = "deleted_at IS NOT NULL"
+	}
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Author != "" {
+		add("lower(author) = lower($%d)", f.Author)
+	}
+	if f.Publisher != "" {
+		add("lower(publisher) = lower($%d)", f.Publisher)
+	}
+	if f.TitleContains != "" {
+		add(`title ILIKE '%%' || $%d || '%%'`, escapeLike(f.TitleContains))
+	}
+	if f.Status != nil {
+		add("status = $%d", *f.Status)
+	}
+	if f.MinRating != nil {
+		add("rating >= $%d", *f.MinRating)
+	}
+	if f.MaxRating != nil {
+		add("rating <= $%d", *f.MaxRating)
+	}
+	if f.PublishedFrom != nil {
+		add("publish_date >= $%d", f.PublishedFrom.Format("2006-01-02"))
+	}
+	if f.PublishedTo != nil {
+		add("publish_date <= $%d", f.PublishedTo.Format("2006-01-02"))
+	}
+	if f.PublisherID != nil {
+		add(`publisher_id IN (WITH RECURSIVE tree (id) AS (
+			SELECT $%d::integer UNION SELECT p.id FROM publisher p JOIN tree ON p.parent_id = tree.id
+		) SELECT id FROM tree)`, *f.PublisherID)
+	}
+
+	if f.Genre != nil {
+		add(`id IN (SELECT book_id FROM book_genre WHERE genre_id IN (WITH RECURSIVE tree (id) AS (
+			SELECT $%d::integer UNION SELECT g.id FROM genre g JOIN tree ON g.parent_id = tree.id
+		) SELECT id FROM tree))`, *f.Genre)
+	}
+	if f.Tag != "" {
+		add("id IN (SELECT book_id FROM book_tag WHERE tag = $%d)", f.Tag)
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// inGenre reports whether any of the book's genres is Genre or below it
+func (f BookFilter) inGenre(book models.Book) bool {
+	for _, g := range book.Genres {
+		if f.subgenres == nil && g.ID == *f.Genre || f.subgenres[g.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTag reports whether the book carries tag
+func hasTag(book models.Book, tag string) bool {
+	for _, t := range book.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// andWhere adds cond to a clause built by where
+func andWhere(where, cond string) string {
+	if where == "" {
+		return " WHERE " + cond
+	}
+	return where + " AND " + cond
+}
+
+// escapeLike stops %, _ and \ in user input acting as LIKE wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
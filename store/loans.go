@@ -0,0 +1,45 @@
+package store
+
+import (
+	"go-postgres/models"
+	"time"
+)
+
+// LoanFilter narrows ListLoans; the zero value matches every loan
+type LoanFilter struct {
+	// BookID matches the loans of one book
+	BookID *int64
+	// Patron matches the loans of one patron exactly
+	Patron string
+	// UserID matches the loans made out to one user account
+	UserID *int64
+	// Open matches loans not yet returned when true and returned ones when false
+	Open *bool
+	// Overdue matches open loans past their due date
+	Overdue bool
+}
+
+// Matches reports whether loan passes every filter that is set, at time now
+func (f LoanFilter) Matches(loan models.Loan, now time.Time) bool {
+	if f.BookID != nil && loan.BookID != *f.BookID {
+		return false
+	}
+	if f.Patron != "" && loan.Patron != f.Patron {
+		return false
+	}
+	if f.UserID != nil && loan.UserID != *f.UserID {
+		return false
+	}
+	if f.Open != nil && (loan.ReturnedAt == nil) != *f.Open {
+		return false
+	}
+	if f.Overdue && !isOverdue(loan, now) {
+		return false
+	}
+	return true
+}
+
+// isOverdue reports whether loan is still out after its due date
+func isOverdue(loan models.Loan, now time.Time) bool {
+	return loan.ReturnedAt == nil && now.After(loan.DueAt)
+}
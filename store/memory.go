@@ -0,0 +1,405 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps books in a map guarded by a mutex. Nothing is persisted,
+// so it is meant for local development and tests.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	books        map[int64]models.Book
+	nextID       int64
+	authors      map[int64]models.Author
+	nextAuthorID int64
+
+	publishers      map[int64]models.Publisher
+	nextPublisherID int64
+
+	genres      map[int64]models.Genre
+	nextGenreID int64
+	// bookGenres and bookTags are keyed by book id
+	bookGenres map[int64]map[int64]bool
+	bookTags   map[int64]map[string]bool
+
+	copies     map[int64]models.Copy
+	nextCopyID int64
+	loans      map[int64]models.Loan
+	nextLoanID int64
+
+	users      map[int64]memoryLogin
+	nextUserID int64
+	// refreshTokens is keyed by token hash, revokedTokens maps access token
+	// ids to when they expire
+	refreshTokens map[string]refreshToken
+	revokedTokens map[string]time.Time
+
+	apiKeys      map[int64]memoryAPIKey
+	nextAPIKeyID int64
+
+	reviews      map[int64]models.Review
+	nextReviewID int64
+	// ratingScale is the scale ratings are stored on, nil until one is used
+	ratingScale *RatingScale
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.Reset()
+	return s
+}
+
+// liveBook returns ErrNotFound unless the book is there and not in the trash
+func (s *MemoryStore) liveBook(id int64) error {
+	if book, ok := s.books[id]; !ok || book.DeletedAt != nil {
+		return fmt.Errorf("book %w: id %d", ErrNotFound, id)
+	}
+	return nil
+}
+
+// isbnTaken reports whether another book than id already has isbn13, like the
+// unique index on the postgres column. Books without an isbn and books in the
+// trash never clash.
+func (s *MemoryStore) isbnTaken(isbn13 string, id int64) error {
+	if isbn13 == "" {
+		return nil
+	}
+	for _, book := range s.books {
+		if book.ISBN_13 == isbn13 && book.ID != id && book.DeletedAt == nil {
+			return fmt.Errorf("%w: isbn %s is already used by book %d", ErrConflict, isbn13, book.ID)
+		}
+	}
+	return nil
+}
+
+// insert book function takes in book model and returns id of book created/inserted
+func (s *MemoryStore) InsertBook(book models.Book) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := normalizeDate(&book); err != nil {
+		return 0, err
+	}
+	if err := s.isbnTaken(book.ISBN_13, 0); err != nil {
+		return 0, err
+	}
+	credits := credits(book)
+	if err := s.checkCredits(credits); err != nil {
+		return 0, err
+	}
+	if err := s.checkPublisher(book); err != nil {
+		return 0, err
+	}
+	book.ID = s.nextID
+	book.RatingCount = 0
+	book.Status = false
+	book.DeletedAt = nil
+	s.credit(&book, credits)
+	s.publish(&book)
+	book.LegacyRating = copyRating(book.Rating)
+	s.books[book.ID] = book
+	s.syncRating(book.ID)
+	s.nextID++
+
+	return book.ID, nil
+}
+
+// insert every book or none of them, returning the ids in order
+func (s *MemoryStore) InsertBooks(books []models.Book) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//check every date and isbn before storing anything so a bad row leaves the store untouched
+	books = append([]models.Book(nil), books...)
+	isbns := make(map[string]bool)
+	for i, book := range books {
+		if err := normalizeDate(&books[i]); err != nil {
+			return nil, err
+		}
+		if err := s.isbnTaken(book.ISBN_13, 0); err != nil {
+			return nil, err
+		}
+		if book.ISBN_13 != "" && isbns[book.ISBN_13] {
+			return nil, fmt.Errorf("%w: isbn %s appears more than once", ErrConflict, book.ISBN_13)
+		}
+		isbns[book.ISBN_13] = true
+		if err := s.checkCredits(credits(book)); err != nil {
+			return nil, err
+		}
+		if err := s.checkPublisher(book); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]int64, len(books))
+	for i, book := range books {
+		book.ID = s.nextID
+		book.RatingCount = 0
+		book.Status = false
+		book.DeletedAt = nil
+		s.credit(&book, credits(book))
+		s.publish(&book)
+		book.LegacyRating = copyRating(book.Rating)
+		s.books[book.ID] = book
+		s.syncRating(book.ID)
+		s.nextID++
+		ids[i] = book.ID
+	}
+
+	return ids, nil
+}
+
+// get one book by its id
+func (s *MemoryStore) GetBook(id int64) (models.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.liveBook(id); err != nil {
+		return models.Book{}, err
+	}
+	return s.withLinks(s.books[id]), nil
+}
+
+// get one book by its ISBN-13
+func (s *MemoryStore) GetBookByISBN(isbn13 string) (models.Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, book := range s.books {
+		if book.ISBN_13 == isbn13 && book.DeletedAt == nil {
+			return s.withLinks(book), nil
+		}
+	}
+	return models.Book{}, fmt.Errorf("book %w: isbn %s", ErrNotFound, isbn13)
+}
+
+// get one page of the books that match the query and the total that match
+func (s *MemoryStore) ListBooks(q BookQuery) ([]models.Book, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	srt := q.sortOrDefault()
+	if q.Filter.PublisherID != nil {
+		q.Filter.imprints = s.imprintsOf(*q.Filter.PublisherID)
+	}
+	if q.Filter.Genre != nil {
+		q.Filter.subgenres = s.subgenresOf(*q.Filter.Genre)
+	}
+	var books []models.Book
+	for _, book := range s.books {
+		book = s.withLinks(book)
+		if q.Filter.Matches(book) {
+			books = append(books, book)
+		}
+	}
+	total := int64(len(books))
+	sort.Slice(books, func(i, j int) bool {
+		return compareCursors(srt, CursorFor(books[i], srt.Field), CursorFor(books[j], srt.Field)) < 0
+	})
+
+	// keep only the books on the right side of a cursor
+	if q.After != nil || q.Before != nil {
+		var kept []models.Book
+		for _, book := range books {
+			at := CursorFor(book, srt.Field)
+			if q.After != nil && compareCursors(srt, at, *q.After) <= 0 {
+				continue
+			}
+			if q.Before != nil && compareCursors(srt, at, *q.Before) >= 0 {
+				continue
+			}
+			kept = append(kept, book)
+		}
+		books = kept
+	}
+
+	// a before cursor takes the page closest to the cursor
+	if q.Before != nil && q.Limit > 0 && len(books) > q.Limit {
+		books = books[len(books)-q.Limit:]
+	}
+	if q.Offset > 0 {
+		if q.Offset >= len(books) {
+			books = nil
+		} else {
+			books = books[q.Offset:]
+		}
+	}
+	if q.Limit > 0 && len(books) > q.Limit {
+		books = books[:q.Limit]
+	}
+
+	return books, total, nil
+}
+
+// call fn for every matching book, working from a copy so fn runs without the lock held
+func (s *MemoryStore) EachBook(filter BookFilter, srt Sort, fn func(models.Book) error) error {
+	books, _, err := s.ListBooks(BookQuery{Filter: filter, Sort: srt})
+	if err != nil {
+		return err
+	}
+	for _, book := range books {
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// full-text search over title, author and publisher, see search.go
+func (s *MemoryStore) SearchBooks(query string, limit, offset int) ([]models.BookMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := searchBooks(s.books, query, limit, offset)
+	for i := range matches {
+		matches[i].Book = s.withLinks(matches[i].Book)
+	}
+	return matches, nil
+}
+
+// update book with the given id and return the rows affected
+func (s *MemoryStore) UpdateBook(id int64, book models.Book) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(id); err != nil {
+		return 0, err
+	}
+	if err := normalizeDate(&book); err != nil {
+		return 0, err
+	}
+	if err := s.isbnTaken(book.ISBN_13, id); err != nil {
+		return 0, err
+	}
+	credits := credits(book)
+	if err := s.checkCredits(credits); err != nil {
+		return 0, err
+	}
+	if err := s.checkPublisher(book); err != nil {
+		return 0, err
+	}
+	book.ID = id
+	book.DeletedAt = nil
+	s.credit(&book, credits)
+	s.publish(&book)
+	book.LegacyRating = copyRating(book.Rating)
+	s.books[id] = book
+	s.syncRating(id)
+	s.syncStatus(id)
+
+	return 1, nil
+}
+
+// move the book with the given id to the trash and return the rows affected
+func (s *MemoryStore) DeleteBook(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(id); err != nil {
+		return 0, err
+	}
+	if loan, ok := s.openLoan(id); ok {
+		return 0, fmt.Errorf("%w: book %d is on loan %d", ErrConflict, id, loan.ID)
+	}
+	book := s.books[id]
+	deleted := now()
+	book.DeletedAt = &deleted
+	s.books[id] = book
+
+	return 1, nil
+}
+
+// take the book with the given id out of the trash and return the rows affected
+func (s *MemoryStore) RestoreBook(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[id]
+	if !ok || book.DeletedAt == nil {
+		return 0, fmt.Errorf("book in the trash %w: id %d", ErrNotFound, id)
+	}
+	// its isbn may have been given to another book while it was in the trash
+	if err := s.isbnTaken(book.ISBN_13, id); err != nil {
+		return 0, err
+	}
+	book.DeletedAt = nil
+	s.books[id] = book
+
+	return 1, nil
+}
+
+// remove the books trashed before cutoff with their copies, loan history and reviews
+func (s *MemoryStore) PurgeBooks(cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, book := range s.books {
+		if book.DeletedAt == nil || !book.DeletedAt.Before(cutoff) {
+			continue
+		}
+		delete(s.books, id)
+		delete(s.bookGenres, id)
+		delete(s.bookTags, id)
+		for copyID, copy := range s.copies {
+			if copy.BookID == id {
+				delete(s.copies, copyID)
+			}
+		}
+		for loanID, loan := range s.loans {
+			if loan.BookID == id {
+				delete(s.loans, loanID)
+			}
+		}
+		for reviewID, review := range s.reviews {
+			if review.BookID == id {
+				delete(s.reviews, reviewID)
+			}
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// removes every book, author, publisher, genre, copy, loan, user, api key and review and restarts the ids at 1
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.books = make(map[int64]models.Book)
+	s.nextID = 1
+	s.authors = make(map[int64]models.Author)
+	s.nextAuthorID = 1
+	s.publishers = make(map[int64]models.Publisher)
+	s.nextPublisherID = 1
+	s.genres = make(map[int64]models.Genre)
+	s.nextGenreID = 1
+	s.bookGenres = make(map[int64]map[int64]bool)
+	s.bookTags = make(map[int64]map[string]bool)
+	s.copies = make(map[int64]models.Copy)
+	s.nextCopyID = 1
+	s.loans = make(map[int64]models.Loan)
+	s.nextLoanID = 1
+	s.users = make(map[int64]memoryLogin)
+	s.nextUserID = 1
+	s.refreshTokens = make(map[string]refreshToken)
+	s.revokedTokens = make(map[string]time.Time)
+	s.apiKeys = make(map[int64]memoryAPIKey)
+	s.nextAPIKeyID = 1
+	s.reviews = make(map[int64]models.Review)
+	s.nextReviewID = 1
+	s.ratingScale = nil
+
+	return nil
+}
+
+// nothing to release for the memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+)
+
+// memoryAPIKey is an api key and the hash of its secret
+type memoryAPIKey struct {
+	key  models.APIKey
+	hash string
+}
+
+// insert an api key and return its id
+func (s *MemoryStore) InsertAPIKey(key models.APIKey, keyHash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.key.Prefix == key.Prefix || k.hash == keyHash {
+			return 0, fmt.Errorf("%w: api key prefix %q is already used", ErrConflict, key.Prefix)
+		}
+	}
+	key.ID = s.nextAPIKeyID
+	key.Key = ""
+	key.Scopes = append([]string(nil), key.Scopes...)
+	key.CreatedAt = now()
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+	s.apiKeys[key.ID] = memoryAPIKey{key: key, hash: keyHash}
+	s.nextAPIKeyID++
+
+	return key.ID, nil
+}
+
+// get one api key by id
+func (s *MemoryStore) GetAPIKey(id int64) (models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("api key %w: id %d", ErrNotFound, id)
+	}
+	return k.key, nil
+}
+
+// list every api key, newest first
+func (s *MemoryStore) ListAPIKeys() ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]models.APIKey, 0, len(s.apiKeys))
+	for _, k := range s.apiKeys {
+		list = append(list, k.key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+// revoke an api key
+func (s *MemoryStore) RevokeAPIKey(id int64) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return models.APIKey{}, fmt.Errorf("api key %w: id %d", ErrNotFound, id)
+	}
+	if k.key.RevokedAt != nil {
+		return models.APIKey{}, fmt.Errorf("%w: api key %d is already revoked", ErrConflict, id)
+	}
+	at := now()
+	k.key.RevokedAt = &at
+	s.apiKeys[id] = k
+
+	return k.key, nil
+}
+
+// find a live api key by hash and record its use
+func (s *MemoryStore) UseAPIKey(keyHash string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, k := range s.apiKeys {
+		if k.hash == keyHash && k.key.RevokedAt == nil {
+			at := now()
+			k.key.LastUsedAt = &at
+			s.apiKeys[id] = k
+			return k.key, nil
+		}
+	}
+	return models.APIKey{}, fmt.Errorf("api key %w", ErrNotFound)
+}
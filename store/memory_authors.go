@@ -0,0 +1,179 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+	"strings"
+)
+
+// The memory store keeps a book's credits in its Authors with only the author
+// id and role; names are looked up from s.authors whenever a book is read, so
+// renaming an author shows everywhere at once like the postgres join does.
+
+// checkCredits makes sure every credit names an author or has an id that exists
+func (s *MemoryStore) checkCredits(credits []models.BookAuthor) error {
+	for _, c := range credits {
+		if c.ID == 0 && c.Name == "" {
+			return fmt.Errorf("%w: an author needs an id or a name", ErrInvalid)
+		}
+		if _, ok := s.authors[c.ID]; c.ID != 0 && !ok {
+			return fmt.Errorf("%w: author %d does not exist", ErrInvalid, c.ID)
+		}
+	}
+	return nil
+}
+
+// creditAuthors turns checked credits into links, creating an author for any
+// name that does not match one yet. Crediting the same person twice in the
+// same role keeps the first.
+func (s *MemoryStore) creditAuthors(credits []models.BookAuthor) []models.BookAuthor {
+	var links []models.BookAuthor
+	seen := make(map[models.BookAuthor]bool)
+	for _, c := range credits {
+		id := c.ID
+		if id == 0 {
+			id = s.authorByName(c.Name)
+		}
+		link := models.BookAuthor{ID: id, Role: c.Role}
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// authorByName returns the id of the author matching name, adding them if needed
+func (s *MemoryStore) authorByName(name string) int64 {
+	key := nameKey(name)
+	for _, a := range s.authors {
+		if nameKey(a.Name) == key {
+			return a.ID
+		}
+	}
+	id := s.nextAuthorID
+	s.authors[id] = models.Author{ID: id, Name: name}
+	s.nextAuthorID++
+	return id
+}
+
+// withAuthors fills in the names of the book's credits
+func (s *MemoryStore) withAuthors(book models.Book) models.Book {
+	if len(book.Authors) == 0 {
+		book.Authors = nil
+		return book
+	}
+	named := make([]models.BookAuthor, len(book.Authors))
+	for i, link := range book.Authors {
+		named[i] = models.BookAuthor{ID: link.ID, Name: s.authors[link.ID].Name, Role: link.Role}
+	}
+	book.Authors = named
+	return book
+}
+
+// credit links book to its authors and sets its Author string to match
+func (s *MemoryStore) credit(book *models.Book, credits []models.BookAuthor) {
+	book.Authors = s.creditAuthors(credits)
+	book.Author = authorString(s.withAuthors(*book).Authors)
+}
+
+// authorTaken reports whether an author other than id already has name
+func (s *MemoryStore) authorTaken(name string, id int64) error {
+	key := nameKey(name)
+	for _, a := range s.authors {
+		if a.ID != id && nameKey(a.Name) == key {
+			return fmt.Errorf("%w: author %q already exists as %d", ErrConflict, name, a.ID)
+		}
+	}
+	return nil
+}
+
+// insert an author and return their id
+func (s *MemoryStore) InsertAuthor(author models.Author) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.authorTaken(author.Name, 0); err != nil {
+		return 0, err
+	}
+	author.ID = s.nextAuthorID
+	s.authors[author.ID] = author
+	s.nextAuthorID++
+
+	return author.ID, nil
+}
+
+// get one author by id
+func (s *MemoryStore) GetAuthor(id int64) (models.Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	author, ok := s.authors[id]
+	if !ok {
+		return author, fmt.Errorf("author %w: id %d", ErrNotFound, id)
+	}
+	return author, nil
+}
+
+// list the authors whose name contains name, ordered by name
+func (s *MemoryStore) ListAuthors(name string) ([]models.Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var authors []models.Author
+	for _, a := range s.authors {
+		if strings.Contains(strings.ToLower(a.Name), strings.ToLower(name)) {
+			authors = append(authors, a)
+		}
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Name != authors[j].Name {
+			return authors[i].Name < authors[j].Name
+		}
+		return authors[i].ID < authors[j].ID
+	})
+	return authors, nil
+}
+
+// rename an author and refresh the Author string of the books crediting them
+func (s *MemoryStore) UpdateAuthor(id int64, author models.Author) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authors[id]; !ok {
+		return 0, fmt.Errorf("author %w: id %d", ErrNotFound, id)
+	}
+	if err := s.authorTaken(author.Name, id); err != nil {
+		return 0, err
+	}
+	author.ID = id
+	s.authors[id] = author
+
+	for bookID, book := range s.books {
+		book.Author = authorString(s.withAuthors(book).Authors)
+		s.books[bookID] = book
+	}
+
+	return 1, nil
+}
+
+// delete an author no book credits
+func (s *MemoryStore) DeleteAuthor(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authors[id]; !ok {
+		return 0, fmt.Errorf("author %w: id %d", ErrNotFound, id)
+	}
+	for _, book := range s.books {
+		for _, link := range book.Authors {
+			if link.ID == id {
+				return 0, fmt.Errorf("%w: author %d is credited on book %d", ErrConflict, id, book.ID)
+			}
+		}
+	}
+	delete(s.authors, id)
+
+	return 1, nil
+}
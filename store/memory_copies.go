@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+)
+
+// onLoan reports whether a copy is out on an open loan
+func (s *MemoryStore) onLoan(copyID int64) bool {
+	for _, loan := range s.loans {
+		if loan.CopyID == copyID && loan.ReturnedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// withAvailability fills in whether a copy can be lent right now
+func (s *MemoryStore) withAvailability(item models.Copy) models.Copy {
+	item.Available = lendable(item.Condition) && !s.onLoan(item.ID)
+	return item
+}
+
+// countCopies returns how many copies a book has and how many can be lent
+func (s *MemoryStore) countCopies(bookID int64) (total, available int64) {
+	for _, item := range s.copies {
+		if item.BookID != bookID {
+			continue
+		}
+		total++
+		if s.withAvailability(item).Available {
+			available++
+		}
+	}
+	return total, available
+}
+
+// syncStatus sets a book's Status to whether copies are out and none is left to lend
+func (s *MemoryStore) syncStatus(bookID int64) {
+	_, available := s.countCopies(bookID)
+	_, out := s.openLoan(bookID)
+	book := s.books[bookID]
+	book.Status = out && available == 0
+	s.books[bookID] = book
+}
+
+// barcodeTaken returns ErrConflict if a copy other than id has the barcode
+func (s *MemoryStore) barcodeTaken(barcode string, id int64) error {
+	for _, item := range s.copies {
+		if item.Barcode == barcode && item.ID != id {
+			return fmt.Errorf("%w: barcode %s is already used by copy %d", ErrConflict, barcode, item.ID)
+		}
+	}
+	return nil
+}
+
+// add a copy to a book and return its id
+func (s *MemoryStore) InsertCopy(item models.Copy) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(item.BookID); err != nil {
+		return 0, err
+	}
+	if err := s.barcodeTaken(item.Barcode, 0); err != nil {
+		return 0, err
+	}
+	item.ID = s.nextCopyID
+	item.Available = false
+	s.copies[item.ID] = item
+	s.nextCopyID++
+	s.syncStatus(item.BookID)
+
+	return item.ID, nil
+}
+
+// get one copy by id
+func (s *MemoryStore) GetCopy(id int64) (models.Copy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.copies[id]
+	if !ok || s.liveBook(item.BookID) != nil {
+		return models.Copy{}, fmt.Errorf("copy %w: id %d", ErrNotFound, id)
+	}
+	return s.withAvailability(item), nil
+}
+
+// list the copies of a book by barcode
+func (s *MemoryStore) ListCopies(bookID int64) ([]models.Copy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var copies []models.Copy
+	for _, item := range s.copies {
+		if item.BookID == bookID && s.liveBook(bookID) == nil {
+			copies = append(copies, s.withAvailability(item))
+		}
+	}
+	sort.Slice(copies, func(i, j int) bool { return copies[i].Barcode < copies[j].Barcode })
+	return copies, nil
+}
+
+// change a copy's details, keeping it on the same book
+func (s *MemoryStore) UpdateCopy(id int64, item models.Copy) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.copies[id]
+	if !ok {
+		return 0, fmt.Errorf("copy %w: id %d", ErrNotFound, id)
+	}
+	if err := s.liveBook(old.BookID); err != nil {
+		return 0, err
+	}
+	if err := s.barcodeTaken(item.Barcode, id); err != nil {
+		return 0, err
+	}
+	item.ID = id
+	item.BookID = old.BookID
+	item.Available = false
+	s.copies[id] = item
+	s.syncStatus(item.BookID)
+
+	return 1, nil
+}
+
+// remove a copy that is not on loan, keeping its loans in the book's history
+func (s *MemoryStore) DeleteCopy(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.copies[id]
+	if !ok {
+		return 0, fmt.Errorf("copy %w: id %d", ErrNotFound, id)
+	}
+	if err := s.liveBook(item.BookID); err != nil {
+		return 0, err
+	}
+	if s.onLoan(id) {
+		return 0, fmt.Errorf("%w: copy %d is on loan", ErrConflict, id)
+	}
+	delete(s.copies, id)
+	for loanID, loan := range s.loans {
+		if loan.CopyID == id {
+			loan.CopyID = 0
+			s.loans[loanID] = loan
+		}
+	}
+	s.syncStatus(item.BookID)
+
+	return 1, nil
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+	"time"
+)
+
+// now is the current time at the precision postgres keeps timestamps, so both
+// stores hand back the same values
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// openLoan returns one of the loans a book is out on, if any
+func (s *MemoryStore) openLoan(bookID int64) (models.Loan, bool) {
+	for _, loan := range s.loans {
+		if loan.BookID == bookID && loan.ReturnedAt == nil {
+			return loan, true
+		}
+	}
+	return models.Loan{}, false
+}
+
+// lend a copy of a book to patron, and the user account userID if any, until due
+func (s *MemoryStore) CheckOut(bookID int64, barcode, patron string, userID int64, due time.Time) (models.Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(bookID); err != nil {
+		return models.Loan{}, err
+	}
+	item, err := s.copyToLend(bookID, barcode)
+	if err != nil {
+		return models.Loan{}, err
+	}
+
+	loan := models.Loan{
+		ID:           s.nextLoanID,
+		BookID:       bookID,
+		CopyID:       item.ID,
+		UserID:       userID,
+		Patron:       patron,
+		CheckedOutAt: now(),
+		DueAt:        due.UTC().Truncate(time.Microsecond),
+	}
+	s.loans[loan.ID] = loan
+	s.nextLoanID++
+	s.syncStatus(bookID)
+
+	return loan, nil
+}
+
+// copyToLend picks the copy of a book with barcode, or the available copy with
+// the lowest id when barcode is empty
+func (s *MemoryStore) copyToLend(bookID int64, barcode string) (models.Copy, error) {
+	var pick models.Copy
+	for _, item := range s.copies {
+		if item.BookID != bookID {
+			continue
+		}
+		if barcode != "" {
+			if item.Barcode != barcode {
+				continue
+			}
+			if item = s.withAvailability(item); !item.Available {
+				return item, fmt.Errorf("%w: copy %s is not available", ErrConflict, barcode)
+			}
+			return item, nil
+		}
+		if s.withAvailability(item).Available && (pick.ID == 0 || item.ID < pick.ID) {
+			pick = item
+		}
+	}
+	if barcode != "" {
+		return pick, fmt.Errorf("copy %w: barcode %s of book %d", ErrNotFound, barcode, bookID)
+	}
+	if pick.ID == 0 {
+		return pick, fmt.Errorf("%w: book %d has no copy available", ErrConflict, bookID)
+	}
+	return pick, nil
+}
+
+// close a loan, putting its copy back on the shelf
+func (s *MemoryStore) ReturnLoan(id int64) (models.Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loan, ok := s.loans[id]
+	if !ok {
+		return loan, fmt.Errorf("loan %w: id %d", ErrNotFound, id)
+	}
+	if loan.ReturnedAt != nil {
+		return loan, fmt.Errorf("%w: loan %d was already returned", ErrConflict, id)
+	}
+	returned := now()
+	loan.ReturnedAt = &returned
+	s.loans[id] = loan
+	s.syncStatus(loan.BookID)
+
+	return loan, nil
+}
+
+// extend an open loan to due
+func (s *MemoryStore) RenewLoan(id int64, due time.Time, maxRenewals int) (models.Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loan, ok := s.loans[id]
+	if !ok {
+		return loan, fmt.Errorf("loan %w: id %d", ErrNotFound, id)
+	}
+	if loan.ReturnedAt != nil {
+		return loan, fmt.Errorf("%w: loan %d was already returned", ErrConflict, id)
+	}
+	if loan.Renewals >= maxRenewals {
+		return loan, fmt.Errorf("%w: loan %d has been renewed the most times allowed (%d)", ErrConflict, id, maxRenewals)
+	}
+	if due = due.UTC().Truncate(time.Microsecond); due.After(loan.DueAt) {
+		loan.DueAt = due
+	}
+	loan.Renewals++
+	s.loans[id] = loan
+
+	loan.Overdue = isOverdue(loan, now())
+	return loan, nil
+}
+
+// get one loan by id
+func (s *MemoryStore) GetLoan(id int64) (models.Loan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	loan, ok := s.loans[id]
+	if !ok || s.liveBook(loan.BookID) != nil {
+		return models.Loan{}, fmt.Errorf("loan %w: id %d", ErrNotFound, id)
+	}
+	loan.Overdue = isOverdue(loan, now())
+	return loan, nil
+}
+
+// list the loans matching f, most recent first
+func (s *MemoryStore) ListLoans(f LoanFilter) ([]models.Loan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	at := now()
+	var loans []models.Loan
+	for _, loan := range s.loans {
+		if f.Matches(loan, at) && s.liveBook(loan.BookID) == nil {
+			loan.Overdue = isOverdue(loan, at)
+			loans = append(loans, loan)
+		}
+	}
+	sort.Slice(loans, func(i, j int) bool {
+		if !loans[i].CheckedOutAt.Equal(loans[j].CheckedOutAt) {
+			return loans[i].CheckedOutAt.After(loans[j].CheckedOutAt)
+		}
+		return loans[i].ID > loans[j].ID
+	})
+	return loans, nil
+}
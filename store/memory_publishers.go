@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+	"strings"
+)
+
+// checkPublisher makes sure a book's PublisherID, when set, exists
+func (s *MemoryStore) checkPublisher(book models.Book) error {
+	if _, ok := s.publishers[book.PublisherID]; book.PublisherID != 0 && !ok {
+		return fmt.Errorf("%w: publisher %d does not exist", ErrInvalid, book.PublisherID)
+	}
+	return nil
+}
+
+// publish links a checked book to its publisher, adding a publisher for a
+// name that does not match one yet, and sets Publisher to the publisher's name
+func (s *MemoryStore) publish(book *models.Book) {
+	name := strings.TrimSpace(book.Publisher)
+	if book.PublisherID == 0 && name != "" {
+		book.PublisherID = s.publisherByName(name)
+	}
+	book.Publisher = s.publishers[book.PublisherID].Name
+}
+
+// publisherByName returns the id of the publisher matching name, adding it if needed
+func (s *MemoryStore) publisherByName(name string) int64 {
+	key := nameKey(name)
+	for _, p := range s.publishers {
+		if nameKey(p.Name) == key {
+			return p.ID
+		}
+	}
+	id := s.nextPublisherID
+	s.publishers[id] = models.Publisher{ID: id, Name: name}
+	s.nextPublisherID++
+	return id
+}
+
+// imprintsOf returns id and the ids of every imprint under it
+func (s *MemoryStore) imprintsOf(id int64) map[int64]bool {
+	parents := make(map[int64]*int64, len(s.publishers))
+	for _, p := range s.publishers {
+		parents[p.ID] = p.ParentID
+	}
+	return subtree(id, parents)
+}
+
+// checkPublisherFields makes sure the name is free and the parent can be used
+// by publisher id (0 for a new publisher)
+func (s *MemoryStore) checkPublisherFields(publisher models.Publisher, id int64) error {
+	key := nameKey(publisher.Name)
+	for _, p := range s.publishers {
+		if p.ID != id && nameKey(p.Name) == key {
+			return fmt.Errorf("%w: publisher %q already exists as %d", ErrConflict, publisher.Name, p.ID)
+		}
+	}
+	if publisher.ParentID == nil {
+		return nil
+	}
+	if _, ok := s.publishers[*publisher.ParentID]; !ok {
+		return fmt.Errorf("%w: parent publisher %d does not exist", ErrInvalid, *publisher.ParentID)
+	}
+	if id != 0 && s.imprintsOf(id)[*publisher.ParentID] {
+		return fmt.Errorf("%w: publisher %d cannot be an imprint of itself or of its own imprint", ErrInvalid, id)
+	}
+	return nil
+}
+
+// insert a publisher and return its id
+func (s *MemoryStore) InsertPublisher(publisher models.Publisher) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPublisherFields(publisher, 0); err != nil {
+		return 0, err
+	}
+	publisher.ID = s.nextPublisherID
+	s.publishers[publisher.ID] = publisher
+	s.nextPublisherID++
+
+	return publisher.ID, nil
+}
+
+// get one publisher by id
+func (s *MemoryStore) GetPublisher(id int64) (models.Publisher, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	publisher, ok := s.publishers[id]
+	if !ok {
+		return publisher, fmt.Errorf("publisher %w: id %d", ErrNotFound, id)
+	}
+	return publisher, nil
+}
+
+// list the publishers whose name contains name, optionally only one publisher's imprints
+func (s *MemoryStore) ListPublishers(name string, parentID *int64) ([]models.Publisher, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var publishers []models.Publisher
+	for _, p := range s.publishers {
+		if !strings.Contains(strings.ToLower(p.Name), strings.ToLower(name)) {
+			continue
+		}
+		if parentID != nil && (p.ParentID == nil || *p.ParentID != *parentID) {
+			continue
+		}
+		publishers = append(publishers, p)
+	}
+	sort.Slice(publishers, func(i, j int) bool {
+		if publishers[i].Name != publishers[j].Name {
+			return publishers[i].Name < publishers[j].Name
+		}
+		return publishers[i].ID < publishers[j].ID
+	})
+	return publishers, nil
+}
+
+// rename or re-parent a publisher and refresh the Publisher of its books
+func (s *MemoryStore) UpdatePublisher(id int64, publisher models.Publisher) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.publishers[id]; !ok {
+		return 0, fmt.Errorf("publisher %w: id %d", ErrNotFound, id)
+	}
+	if err := s.checkPublisherFields(publisher, id); err != nil {
+		return 0, err
+	}
+	publisher.ID = id
+	s.publishers[id] = publisher
+
+	for bookID, book := range s.books {
+		if book.PublisherID == id {
+			book.Publisher = publisher.Name
+			s.books[bookID] = book
+		}
+	}
+
+	return 1, nil
+}
+
+// delete a publisher with no books and no imprints
+func (s *MemoryStore) DeletePublisher(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.publishers[id]; !ok {
+		return 0, fmt.Errorf("publisher %w: id %d", ErrNotFound, id)
+	}
+	for _, book := range s.books {
+		if book.PublisherID == id {
+			return 0, fmt.Errorf("%w: publisher %d has book %d", ErrConflict, id, book.ID)
+		}
+	}
+	for _, p := range s.publishers {
+		if p.ParentID != nil && *p.ParentID == id {
+			return 0, fmt.Errorf("%w: publisher %d has imprint %d", ErrConflict, id, p.ID)
+		}
+	}
+	delete(s.publishers, id)
+
+	return 1, nil
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+)
+
+// syncRating works out a book's Rating and RatingCount from its reviews,
+// falling back to its legacy rating while it has none
+func (s *MemoryStore) syncRating(bookID int64) {
+	book, ok := s.books[bookID]
+	if !ok {
+		return
+	}
+	var sum float64
+	var n int64
+	for _, review := range s.reviews {
+		if review.BookID == bookID {
+			sum += review.Rating
+			n++
+		}
+	}
+	book.Rating, book.RatingCount = copyRating(book.LegacyRating), n
+	if n > 0 {
+		average := sum / float64(n)
+		book.Rating = &average
+	}
+	s.books[bookID] = book
+}
+
+// withUsername fills in the name of the user who wrote a review
+func (s *MemoryStore) withUsername(review models.Review) models.Review {
+	review.Username = s.users[review.UserID].user.Username
+	return review
+}
+
+// insert a review and return its id
+func (s *MemoryStore) InsertReview(review models.Review) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(review.BookID); err != nil {
+		return 0, err
+	}
+	if _, ok := s.users[review.UserID]; !ok {
+		return 0, fmt.Errorf("user %w: id %d", ErrNotFound, review.UserID)
+	}
+	for _, other := range s.reviews {
+		if other.BookID == review.BookID && other.UserID == review.UserID {
+			return 0, fmt.Errorf("%w: user %d has already reviewed book %d in review %d", ErrConflict, review.UserID, review.BookID, other.ID)
+		}
+	}
+	review.ID = s.nextReviewID
+	review.CreatedAt = now()
+	review.UpdatedAt = review.CreatedAt
+	s.reviews[review.ID] = review
+	s.nextReviewID++
+	s.syncRating(review.BookID)
+
+	return review.ID, nil
+}
+
+// get one review by id
+func (s *MemoryStore) GetReview(id int64) (models.Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	review, ok := s.reviews[id]
+	if !ok || s.liveBook(review.BookID) != nil {
+		return models.Review{}, fmt.Errorf("review %w: id %d", ErrNotFound, id)
+	}
+	return s.withUsername(review), nil
+}
+
+// list the reviews matching f, newest first
+func (s *MemoryStore) ListReviews(f ReviewFilter) ([]models.Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []models.Review
+	for _, review := range s.reviews {
+		if f.BookID != nil && review.BookID != *f.BookID {
+			continue
+		}
+		if f.UserID != nil && review.UserID != *f.UserID {
+			continue
+		}
+		if s.liveBook(review.BookID) != nil {
+			continue
+		}
+		list = append(list, s.withUsername(review))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+// change a review's rating and text
+func (s *MemoryStore) UpdateReview(id int64, review models.Review) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.reviews[id]
+	if !ok {
+		return 0, fmt.Errorf("review %w: id %d", ErrNotFound, id)
+	}
+	if err := s.liveBook(current.BookID); err != nil {
+		return 0, err
+	}
+	current.Rating = review.Rating
+	current.Text = review.Text
+	current.UpdatedAt = now()
+	s.reviews[id] = current
+	s.syncRating(current.BookID)
+
+	return 1, nil
+}
+
+// delete a review
+func (s *MemoryStore) DeleteReview(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, ok := s.reviews[id]
+	if !ok {
+		return 0, fmt.Errorf("review %w: id %d", ErrNotFound, id)
+	}
+	if err := s.liveBook(review.BookID); err != nil {
+		return 0, err
+	}
+	delete(s.reviews, id)
+	s.syncRating(review.BookID)
+
+	return 1, nil
+}
+
+// record the scale ratings are stored on, or check it is the one recorded
+func (s *MemoryStore) UseRatingScale(sc RatingScale) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ratingScale == nil {
+		s.ratingScale = &sc
+		return nil
+	}
+	return sameScale(*s.ratingScale, sc)
+}
+
+// convert every review and every legacy rating from one rating scale to another
+func (s *MemoryStore) RescaleRatings(from, to RatingScale) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ratingScale != nil {
+		if err := sameScale(*s.ratingScale, from); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int64
+	for id, review := range s.reviews {
+		rating := from.Convert(review.Rating, to)
+		if rating != review.Rating {
+			review.Rating = rating
+			s.reviews[id] = review
+			n++
+		}
+	}
+	for id, book := range s.books {
+		if book.LegacyRating != nil {
+			rating := from.Convert(*book.LegacyRating, to)
+			book.LegacyRating = &rating
+			s.books[id] = book
+		}
+		s.syncRating(id)
+	}
+	s.ratingScale = &to
+
+	return n, nil
+}
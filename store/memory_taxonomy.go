@@ -0,0 +1,242 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+)
+
+// subtree returns root and every id below it, given each id's parent
+func subtree(root int64, parents map[int64]*int64) map[int64]bool {
+	tree := map[int64]bool{root: true}
+	for grew := true; grew; {
+		grew = false
+		for id, parent := range parents {
+			if parent != nil && tree[*parent] && !tree[id] {
+				tree[id] = true
+				grew = true
+			}
+		}
+	}
+	return tree
+}
+
+// subgenresOf returns id and the ids of every genre below it
+func (s *MemoryStore) subgenresOf(id int64) map[int64]bool {
+	parents := make(map[int64]*int64, len(s.genres))
+	for _, g := range s.genres {
+		parents[g.ID] = g.ParentID
+	}
+	return subtree(id, parents)
+}
+
+// withLinks fills in a book's credits, genres, tags and copy counts from the maps they are kept in
+func (s *MemoryStore) withLinks(book models.Book) models.Book {
+	book = s.withAuthors(book)
+
+	book.Genres = nil
+	for id := range s.bookGenres[book.ID] {
+		book.Genres = append(book.Genres, s.genres[id])
+	}
+	sort.Slice(book.Genres, func(i, j int) bool { return book.Genres[i].Name < book.Genres[j].Name })
+
+	book.Tags = nil
+	for tag := range s.bookTags[book.ID] {
+		book.Tags = append(book.Tags, tag)
+	}
+	sort.Strings(book.Tags)
+
+	book.Copies, book.AvailableCopies = s.countCopies(book.ID)
+
+	return book
+}
+
+// checkGenreFields makes sure the name is free and the parent can be used by
+// genre id (0 for a new genre)
+func (s *MemoryStore) checkGenreFields(genre models.Genre, id int64) error {
+	key := nameKey(genre.Name)
+	for _, g := range s.genres {
+		if g.ID != id && nameKey(g.Name) == key {
+			return fmt.Errorf("%w: genre %q already exists as %d", ErrConflict, genre.Name, g.ID)
+		}
+	}
+	if genre.ParentID == nil {
+		return nil
+	}
+	if _, ok := s.genres[*genre.ParentID]; !ok {
+		return fmt.Errorf("%w: parent genre %d does not exist", ErrInvalid, *genre.ParentID)
+	}
+	if id != 0 && s.subgenresOf(id)[*genre.ParentID] {
+		return fmt.Errorf("%w: genre %d cannot be placed under itself or one of its subgenres", ErrInvalid, id)
+	}
+	return nil
+}
+
+// insert a genre and return its id
+func (s *MemoryStore) InsertGenre(genre models.Genre) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkGenreFields(genre, 0); err != nil {
+		return 0, err
+	}
+	genre.ID = s.nextGenreID
+	s.genres[genre.ID] = genre
+	s.nextGenreID++
+
+	return genre.ID, nil
+}
+
+// get one genre by id
+func (s *MemoryStore) GetGenre(id int64) (models.Genre, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	genre, ok := s.genres[id]
+	if !ok {
+		return genre, fmt.Errorf("genre %w: id %d", ErrNotFound, id)
+	}
+	return genre, nil
+}
+
+// list every genre, or the direct children of parentID
+func (s *MemoryStore) ListGenres(parentID *int64) ([]models.Genre, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var genres []models.Genre
+	for _, g := range s.genres {
+		if parentID != nil && (g.ParentID == nil || *g.ParentID != *parentID) {
+			continue
+		}
+		genres = append(genres, g)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if genres[i].Name != genres[j].Name {
+			return genres[i].Name < genres[j].Name
+		}
+		return genres[i].ID < genres[j].ID
+	})
+	return genres, nil
+}
+
+// rename or move a genre
+func (s *MemoryStore) UpdateGenre(id int64, genre models.Genre) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.genres[id]; !ok {
+		return 0, fmt.Errorf("genre %w: id %d", ErrNotFound, id)
+	}
+	if err := s.checkGenreFields(genre, id); err != nil {
+		return 0, err
+	}
+	genre.ID = id
+	s.genres[id] = genre
+
+	return 1, nil
+}
+
+// delete a genre with no children, taking it off every book
+func (s *MemoryStore) DeleteGenre(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.genres[id]; !ok {
+		return 0, fmt.Errorf("genre %w: id %d", ErrNotFound, id)
+	}
+	for _, g := range s.genres {
+		if g.ParentID != nil && *g.ParentID == id {
+			return 0, fmt.Errorf("%w: genre %d has subgenre %d", ErrConflict, id, g.ID)
+		}
+	}
+	delete(s.genres, id)
+	for _, genres := range s.bookGenres {
+		delete(genres, id)
+	}
+
+	return 1, nil
+}
+
+// put a book in a genre
+func (s *MemoryStore) AddBookGenre(bookID, genreID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(bookID); err != nil {
+		return err
+	}
+	if _, ok := s.genres[genreID]; !ok {
+		return fmt.Errorf("genre %w: id %d", ErrNotFound, genreID)
+	}
+	if s.bookGenres[bookID] == nil {
+		s.bookGenres[bookID] = make(map[int64]bool)
+	}
+	s.bookGenres[bookID][genreID] = true
+
+	return nil
+}
+
+// take a book out of a genre
+func (s *MemoryStore) RemoveBookGenre(bookID, genreID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.bookGenres[bookID][genreID] {
+		return fmt.Errorf("book genre %w: book %d is not in genre %d", ErrNotFound, bookID, genreID)
+	}
+	delete(s.bookGenres[bookID], genreID)
+
+	return nil
+}
+
+// tag a book
+func (s *MemoryStore) AddBookTag(bookID int64, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.liveBook(bookID); err != nil {
+		return err
+	}
+	if s.bookTags[bookID] == nil {
+		s.bookTags[bookID] = make(map[string]bool)
+	}
+	s.bookTags[bookID][tag] = true
+
+	return nil
+}
+
+// untag a book
+func (s *MemoryStore) RemoveBookTag(bookID int64, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.bookTags[bookID][tag] {
+		return fmt.Errorf("book tag %w: book %d is not tagged %q", ErrNotFound, bookID, tag)
+	}
+	delete(s.bookTags[bookID], tag)
+
+	return nil
+}
+
+// list every tag in use with its number of books
+func (s *MemoryStore) ListTags() ([]models.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for bookID, tags := range s.bookTags {
+		if s.books[bookID].DeletedAt != nil {
+			continue
+		}
+		for tag := range tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]models.Tag, 0, len(counts))
+	for name, n := range counts {
+		tags = append(tags, models.Tag{Name: name, Books: n})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"fmt"
+	"go-postgres/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// memoryLogin is a user and their password hash
+type memoryLogin struct {
+	user models.User
+	hash string
+}
+
+// refreshToken is a refresh token handed out to a user
+type refreshToken struct {
+	userID  int64
+	expires time.Time
+	revoked bool
+}
+
+// insert a user and return their id
+func (s *MemoryStore) InsertUser(user models.User, passwordHash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.users {
+		if strings.EqualFold(l.user.Username, user.Username) {
+			return 0, fmt.Errorf("%w: username %q is taken", ErrConflict, user.Username)
+		}
+	}
+	user.ID = s.nextUserID
+	user.CreatedAt = now()
+	s.users[user.ID] = memoryLogin{user: user, hash: passwordHash}
+	s.nextUserID++
+
+	return user.ID, nil
+}
+
+// get one user by id
+func (s *MemoryStore) GetUser(id int64) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.users[id]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %w: id %d", ErrNotFound, id)
+	}
+	return l.user, nil
+}
+
+// list every user by username
+func (s *MemoryStore) ListUsers() ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]models.User, 0, len(s.users))
+	for _, l := range s.users {
+		list = append(list, l.user)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Username) < strings.ToLower(list[j].Username)
+	})
+	return list, nil
+}
+
+// change a user's role, keeping at least one admin
+func (s *MemoryStore) SetUserRole(id int64, role string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.users[id]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %w: id %d", ErrNotFound, id)
+	}
+	if l.user.Role == RoleAdmin && role != RoleAdmin {
+		admins := 0
+		for _, other := range s.users {
+			if other.user.Role == RoleAdmin {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return models.User{}, fmt.Errorf("%w: user %d is the last admin", ErrConflict, id)
+		}
+	}
+	l.user.Role = role
+	s.users[id] = l
+
+	return l.user, nil
+}
+
+// get a user and their password hash by username
+func (s *MemoryStore) GetLogin(username string) (models.User, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, l := range s.users {
+		if strings.EqualFold(l.user.Username, username) {
+			return l.user, l.hash, nil
+		}
+	}
+	return models.User{}, "", fmt.Errorf("user %w: username %q", ErrNotFound, username)
+}
+
+// record a refresh token
+func (s *MemoryStore) InsertRefreshToken(userID int64, tokenHash string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("user %w: id %d", ErrNotFound, userID)
+	}
+	s.refreshTokens[tokenHash] = refreshToken{userID: userID, expires: expires}
+	return nil
+}
+
+// swap a live refresh token for a new one
+func (s *MemoryStore) RotateRefreshToken(oldHash, newHash string, expires time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.refreshTokens[oldHash]
+	if !ok || !old.expires.After(time.Now()) {
+		return 0, fmt.Errorf("refresh token %w", ErrNotFound)
+	}
+	if old.revoked {
+		for hash, t := range s.refreshTokens {
+			if t.userID == old.userID {
+				t.revoked = true
+				s.refreshTokens[hash] = t
+			}
+		}
+		return 0, fmt.Errorf("refresh token %w: it was already used, every session of user %d is revoked", ErrNotFound, old.userID)
+	}
+	old.revoked = true
+	s.refreshTokens[oldHash] = old
+	s.refreshTokens[newHash] = refreshToken{userID: old.userID, expires: expires}
+
+	return old.userID, nil
+}
+
+// revoke one of a user's refresh tokens
+func (s *MemoryStore) RevokeRefreshToken(userID int64, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.refreshTokens[tokenHash]
+	if !ok || t.userID != userID {
+		return fmt.Errorf("refresh token %w", ErrNotFound)
+	}
+	t.revoked = true
+	s.refreshTokens[tokenHash] = t
+	return nil
+}
+
+// reject an access token until it expires, forgetting those that already have
+func (s *MemoryStore) RevokeAccessToken(tokenID string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := time.Now()
+	for id, exp := range s.revokedTokens {
+		if !exp.After(at) {
+			delete(s.revokedTokens, id)
+		}
+	}
+	s.revokedTokens[tokenID] = expires
+	return nil
+}
+
+// report whether an access token was revoked
+func (s *MemoryStore) AccessTokenRevoked(tokenID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revokedTokens[tokenID]
+	return ok, nil
+}
@@ -0,0 +1,598 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/isbn"
+	"go-postgres/models"
+	"html"
+	"os" // used to read the environment variable
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv" // package used to read the .env file
+	_ "github.com/lib/pq"      // postgres golang driver
+)
+
+// PoolConfig controls the size and recycling of the shared connection pool
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// DefaultPoolConfig is used for any setting not given in the environment
+var DefaultPoolConfig = PoolConfig{
+	MaxOpenConns:    10,
+	MaxIdleConns:    5,
+	ConnMaxLifetime: 30 * time.Minute,
+	ConnMaxIdleTime: 5 * time.Minute,
+}
+
+// PoolConfigFromEnv reads DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME
+// and DB_CONN_MAX_IDLE_TIME (durations such as "30m"), falling back to DefaultPoolConfig
+func PoolConfigFromEnv() (PoolConfig, error) {
+	cfg := DefaultPoolConfig
+	var err error
+
+	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
+		if cfg.MaxOpenConns, err = strconv.Atoi(v); err != nil {
+			return cfg, fmt.Errorf("DB_MAX_OPEN_CONNS: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_MAX_IDLE_CONNS"); v != "" {
+		if cfg.MaxIdleConns, err = strconv.Atoi(v); err != nil {
+			return cfg, fmt.Errorf("DB_MAX_IDLE_CONNS: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_CONN_MAX_LIFETIME"); v != "" {
+		if cfg.ConnMaxLifetime, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("DB_CONN_MAX_LIFETIME: %v", err)
+		}
+	}
+	if v := os.Getenv("DB_CONN_MAX_IDLE_TIME"); v != "" {
+		if cfg.ConnMaxIdleTime, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("DB_CONN_MAX_IDLE_TIME: %v", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// bookColumns lists the book columns in the order scanBook reads them
+const bookColumns = `id, title, author, publisher, publish_date, publish_date_precision, rating, rating_count, legacy_rating, status, isbn13, publisher_id, deleted_at`
+
+// insertBook is shared by InsertBook and InsertBooks. A new book has no
+// reviews, so it shows the rating it is given as its legacy rating, and no
+// loans, so its status is left false.
+const insertBook = `INSERT INTO book (Title, Author, Publisher, Publish_Date, publish_date_precision, isbn13, legacy_rating, rating) VALUES ($1, $2, $3, $4, $5, $6, $7, $7) RETURNING ID`
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBook reads one row selected with bookColumns, followed by any extra columns
+func scanBook(row scanner, book *models.Book, extra ...interface{}) error {
+	var isbn13 sql.NullString
+	var publisherID sql.NullInt64
+	var published time.Time
+	var deletedAt sql.NullTime
+	dest := []interface{}{&book.ID, &book.Title, &book.Author, &book.Publisher, &published, &book.PublishDatePrecision, &book.Rating, &book.RatingCount, &book.LegacyRating, &book.Status, &isbn13, &publisherID, &deletedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		book.DeletedAt = &t
+	}
+	book.Publish_Date = FormatPublishDate(published, book.PublishDatePrecision)
+	book.PublisherID = publisherID.Int64
+	book.ISBN_13 = isbn13.String
+	book.ISBN_10, _ = isbn.To10(isbn13.String)
+	return nil
+}
+
+// publishDate gives the value to store in the publish_date column for a book's
+// Publish_Date, the first day it covers, and its precision
+func publishDate(book models.Book) (string, string, error) {
+	t, precision, err := ParsePublishDate(book.Publish_Date)
+	if err != nil {
+		return "", "", err
+	}
+	return FormatPublishDate(t, PrecisionDay), precision, nil
+}
+
+// nullIfEmpty stores an empty string as NULL, so the unique isbn index ignores it
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// PostgresStore keeps books in the book table of a postgres database. It holds
+// one connection pool for the life of the process.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore opens the connection pool to url, applies cfg and checks the
+// database can be reached
+func NewPostgresStore(url string, cfg PoolConfig) (*PostgresStore, error) {
+	// Open the connection pool
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	// check the connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+// openPostgres loads .env and opens the store at POSTGRES_URL
+func openPostgres() (*PostgresStore, error) {
+	// load .env file, it is fine for it to be missing if the environment is already set
+	if err := godotenv.Load(".env"); err != nil && os.Getenv("POSTGRES_URL") == "" {
+		return nil, fmt.Errorf("error loading .env file: %v", err)
+	}
+
+	cfg, err := PoolConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPostgresStore(os.Getenv("POSTGRES_URL"), cfg)
+}
+
+// DB returns the connection pool, used to run schema migrations
+func (s *PostgresStore) DB() *sql.DB {
+	return s.db
+}
+
+// Stats reports the state of the connection pool
+func (s *PostgresStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Close closes the connection pool
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// insert book function takes in book model and returns id of book created/inserted
+func (s *PostgresStore) InsertBook(book models.Book) (int64, error) {
+	ids, err := s.InsertBooks([]models.Book{book})
+	if err != nil {
+		return 0, err
+	}
+
+	//return the inserted id
+	return ids[0], nil
+}
+
+// insert every book in a single transaction, returning the ids in order
+func (s *PostgresStore) InsertBooks(books []models.Book) ([]int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// rolling back after a commit is a no-op, so this only undoes failed imports
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertBook)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, len(books))
+	for i, book := range books {
+		date, precision, err := publishDate(book)
+		if err != nil {
+			return nil, err
+		}
+		err = stmt.QueryRow(book.Title, book.Author, book.Publisher, date, precision, nullIfEmpty(book.ISBN_13), book.Rating).Scan(&ids[i])
+		if err != nil {
+			return nil, translateError(err)
+		}
+		if err := saveCredits(tx, ids[i], credits(book)); err != nil {
+			return nil, err
+		}
+		if err := savePublisher(tx, ids[i], book); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
+
+// get one book from the DB by its id
+func (s *PostgresStore) GetBook(id int64) (models.Book, error) {
+	// create a new book model
+	var book models.Book
+
+	// create the select sql query
+	sqlStatement := `SELECT ` + bookColumns + ` FROM book WHERE id=$1 AND deleted_at IS NULL`
+
+	// execute the sql statement
+	row := s.db.QueryRow(sqlStatement, id)
+
+	// unmarshal the row object to book
+	err := scanBook(row, &book)
+
+	if err == sql.ErrNoRows {
+		return book, fmt.Errorf("book %w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return book, err
+	}
+
+	return s.withLinks(book)
+}
+
+// withLinks returns book with its Authors, Genres, Tags and copy counts filled in
+func (s *PostgresStore) withLinks(book models.Book) (models.Book, error) {
+	books := []models.Book{book}
+	err := s.attachLinks(books)
+	return books[0], err
+}
+
+// get one book by its ISBN-13
+func (s *PostgresStore) GetBookByISBN(isbn13 string) (models.Book, error) {
+	var book models.Book
+
+	row := s.db.QueryRow(`SELECT `+bookColumns+` FROM book WHERE isbn13=$1 AND deleted_at IS NULL`, isbn13)
+	err := scanBook(row, &book)
+	if err == sql.ErrNoRows {
+		return book, fmt.Errorf("book %w: isbn %s", ErrNotFound, isbn13)
+	}
+	if err != nil {
+		return book, err
+	}
+
+	return s.withLinks(book)
+}
+
+// get one page of the books that match the query and the total that match
+func (s *PostgresStore) ListBooks(q BookQuery) ([]models.Book, int64, error) {
+	where, args := q.Filter.where()
+
+	// count every match before paging
+	var total int64
+	err := s.db.QueryRow(`SELECT count(*) FROM book`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	books, err := s.selectBooks(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return books, total, s.attachLinks(books)
+}
+
+// selectBooks reads one page of q without its links. The result set is closed
+// before it returns, so the connection is free for the links to be looked up.
+func (s *PostgresStore) selectBooks(q BookQuery) ([]models.Book, error) {
+	var books []models.Book
+
+	where, args := q.Filter.where()
+
+	// a before cursor walks backwards from the cursor and the page is flipped afterwards
+	srt := q.sortOrDefault()
+	col := sortColumns[srt.Field]
+	desc := srt.Desc
+	cursor := q.After
+	if q.Before != nil {
+		cursor = q.Before
+		desc = !desc
+	}
+	if cursor != nil {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		args = append(args, cursor.Value, cursor.ID)
+		where = andWhere(where, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", col.column, op, len(args)-1, col.cast, len(args)))
+	}
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+
+	// create the select sql query
+	sqlStatement := `SELECT ` + bookColumns + ` FROM book` + where + fmt.Sprintf(` ORDER BY %s %s, id %s`, col.column, dir, dir)
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		sqlStatement += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	if q.Offset > 0 {
+		args = append(args, q.Offset)
+		sqlStatement += fmt.Sprintf(` OFFSET $%d`, len(args))
+	}
+
+	// execute the sql statement
+	rows, err := s.db.Query(sqlStatement, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// close the statement
+	defer rows.Close()
+
+	// iterate over the rows
+	for rows.Next() {
+		var book models.Book
+
+		// unmarshal the row object to book
+		err = scanBook(rows, &book)
+
+		if err != nil {
+			return nil, err
+		}
+
+		//append the book to the books list
+		books = append(books, book)
+
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q.Before != nil {
+		reverseBooks(books)
+	}
+
+	return books, nil
+}
+
+// eachBookBatch is how many books EachBook reads before looking up their links
+const eachBookBatch = 500
+
+// stream every matching book to fn a batch at a time. Each batch is a keyset
+// page read and closed before its authors, genres, tags and copy counts are
+// looked up, so only one connection is in use at a time.
+func (s *PostgresStore) EachBook(filter BookFilter, srt Sort, fn func(models.Book) error) error {
+	q := BookQuery{Filter: filter, Sort: srt, Limit: eachBookBatch}
+	srt = q.sortOrDefault()
+	for {
+		batch, err := s.selectBooks(q)
+		if err != nil {
+			return err
+		}
+		if err := s.attachLinks(batch); err != nil {
+			return err
+		}
+		for _, book := range batch {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		if len(batch) < eachBookBatch {
+			return nil
+		}
+		cursor := CursorFor(batch[len(batch)-1], srt.Field)
+		q.After = &cursor
+	}
+}
+
+// ts_headline marks matches with control characters no book field holds, so
+// the field can be escaped before the marks become <mark></mark>
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+// headlineOptions makes ts_headline return the whole field with every match marked
+const headlineOptions = `StartSel="` + headlineStart + `", StopSel="` + headlineStop + `", HighlightAll=true`
+
+// headlineMarks turns the sentinels into <mark></mark> once the field is escaped
+var headlineMarks = strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>")
+
+// markHeadline makes a ts_headline result safe to show as HTML
+func markHeadline(headline string) string {
+	return headlineMarks.Replace(html.EscapeString(headline))
+}
+
+// full-text search over title, author and publisher ranked by ts_rank_cd
+func (s *PostgresStore) SearchBooks(query string, limit, offset int) ([]models.BookMatch, error) {
+	sqlStatement := `SELECT ` + bookColumns + `,
+		ts_rank_cd(search_vector, query) AS rank,
+		ts_headline('english', title, query, $4),
+		ts_headline('english', author, query, $4),
+		ts_headline('english', publisher, query, $4)
+	FROM book, websearch_to_tsquery('english', $1) query
+	WHERE search_vector @@ query AND deleted_at IS NULL
+	ORDER BY rank DESC, id
+	LIMIT $2 OFFSET $3`
+
+	rows, err := s.db.Query(sqlStatement, query, limit, offset, headlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []models.BookMatch
+	for rows.Next() {
+		var m models.BookMatch
+		var title, author, publisher string
+		err := scanBook(rows, &m.Book, &m.Rank, &title, &author, &publisher)
+		if err != nil {
+			return nil, err
+		}
+		m.Highlights = map[string]string{"Title": markHeadline(title), "Author": markHeadline(author), "Publisher": markHeadline(publisher)}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	books := make([]models.Book, len(matches))
+	for i, m := range matches {
+		books[i] = m.Book
+	}
+	if err := s.attachLinks(books); err != nil {
+		return nil, err
+	}
+	for i := range matches {
+		matches[i].Book = books[i]
+	}
+	return matches, nil
+}
+
+// update book from the DB
+func (s *PostgresStore) UpdateBook(id int64, book models.Book) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	date, precision, err := publishDate(book)
+	if err != nil {
+		return 0, err
+	}
+
+	// create the update sql query; the rating replaces the legacy rating, null
+	// clearing it, which the book only shows while it has no reviews. The
+	// status is left alone as it follows from the book's copies and loans.
+	sqlStatement := `UPDATE book SET Title=$2, Author=$3, Publisher=$4, Publish_Date =$5, publish_date_precision = $6, isbn13 = $7,
+		legacy_rating = $8::double precision,
+		rating = CASE WHEN rating_count > 0 THEN rating ELSE $8::double precision END
+		WHERE id=$1 AND deleted_at IS NULL`
+
+	// execute the sql statement
+	res, err := tx.Exec(sqlStatement, id, book.Title, book.Author, book.Publisher, date, precision, nullIfEmpty(book.ISBN_13), book.Rating)
+
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	//check how many rows affected
+	n, err := rowsAffected(res, "book", id)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveCredits(tx, id, credits(book)); err != nil {
+		return 0, err
+	}
+	if err := savePublisher(tx, id, book); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// move a book in the DB to the trash by id
+func (s *PostgresStore) DeleteBook(id int64) (int64, error) {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the book so it cannot be checked out while it is being deleted
+	var loanID sql.NullInt64
+	err = tx.QueryRow(`SELECT l.id FROM book b LEFT JOIN loan l ON l.book_id = b.id AND l.returned_at IS NULL
+		WHERE b.id = $1 AND b.deleted_at IS NULL FOR UPDATE OF b`, id).Scan(&loanID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("book %w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if loanID.Valid {
+		return 0, fmt.Errorf("%w: book %d is on loan %d", ErrConflict, id, loanID.Int64)
+	}
+
+	// create the delete sql query, the book keeps its copies, loan history and
+	// reviews until it is purged
+	sqlStatement := `UPDATE book SET deleted_at = now() WHERE id=$1`
+
+	// execute the sql statement
+	res, err := tx.Exec(sqlStatement, id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// check how many rows affected
+	n, err := rowsAffected(res, "book", id)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// take a book in the DB out of the trash by id
+func (s *PostgresStore) RestoreBook(id int64) (int64, error) {
+	// the isbn index turns a book whose isbn was taken while it was in the
+	// trash into ErrConflict
+	res, err := s.db.Exec(`UPDATE book SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return rowsAffected(res, "book in the trash", id)
+}
+
+// permanently remove the books trashed before cutoff; the copies, loan history
+// and reviews go with them
+func (s *PostgresStore) PurgeBooks(cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM book WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// rowsAffected reports how many rows a write touched, turning zero into ErrNotFound
+// for the kind of record with the given id
+func rowsAffected(res sql.Result, kind string, id int64) (int64, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%s %w: id %d", kind, ErrNotFound, id)
+	}
+	return n, nil
+}
+
+// empties every table and restarts the primary key sequences
+func (s *PostgresStore) Reset() error {
+	// create the delete sql query
+	sqlStatement := `
+	TRUNCATE book, author, book_author, publisher, genre, book_genre, book_tag, copy, loan,
+		app_user, refresh_token, revoked_token, api_key, review;
+	ALTER SEQUENCE book_id_seq RESTART WITH 1;
+	ALTER SEQUENCE author_id_seq RESTART WITH 1;
+	ALTER SEQUENCE publisher_id_seq RESTART WITH 1;
+	ALTER SEQUENCE genre_id_seq RESTART WITH 1;
+	ALTER SEQUENCE copy_id_seq RESTART WITH 1;
+	ALTER SEQUENCE loan_id_seq RESTART WITH 1;
+	ALTER SEQUENCE app_user_id_seq RESTART WITH 1;
+	ALTER SEQUENCE api_key_id_seq RESTART WITH 1;
+	ALTER SEQUENCE review_id_seq RESTART WITH 1;`
+
+	// execute the sql statement
+	_, err := s.db.Exec(sqlStatement)
+
+	return err
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+
+	"github.com/lib/pq"
+)
+
+// apiKeyColumns lists the api key columns in the order scanAPIKey reads them
+const apiKeyColumns = `id, name, prefix, role, scopes, created_at, last_used_at, revoked_at`
+
+// scanAPIKey reads one row selected with apiKeyColumns
+func scanAPIKey(row scanner, key *models.APIKey) error {
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Role, pq.Array(&key.Scopes), &key.CreatedAt, &lastUsed, &revoked); err != nil {
+		return err
+	}
+	key.CreatedAt = key.CreatedAt.UTC()
+	if lastUsed.Valid {
+		t := lastUsed.Time.UTC()
+		key.LastUsedAt = &t
+	}
+	if revoked.Valid {
+		t := revoked.Time.UTC()
+		key.RevokedAt = &t
+	}
+	return nil
+}
+
+// insert an api key and return its id
+func (s *PostgresStore) InsertAPIKey(key models.APIKey, keyHash string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO api_key (name, prefix, key_hash, role, scopes) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		key.Name, key.Prefix, keyHash, key.Role, pq.Array(key.Scopes)).Scan(&id)
+	return id, translateError(err)
+}
+
+// get one api key by id
+func (s *PostgresStore) GetAPIKey(id int64) (models.APIKey, error) {
+	var key models.APIKey
+	err := scanAPIKey(s.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_key WHERE id = $1`, id), &key)
+	if err == sql.ErrNoRows {
+		return key, fmt.Errorf("api key %w: id %d", ErrNotFound, id)
+	}
+	return key, err
+}
+
+// list every api key, newest first
+func (s *PostgresStore) ListAPIKeys() ([]models.APIKey, error) {
+	rows, err := s.db.Query(`SELECT ` + apiKeyColumns + ` FROM api_key ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, err
+		}
+		list = append(list, key)
+	}
+	return list, rows.Err()
+}
+
+// revoke an api key
+func (s *PostgresStore) RevokeAPIKey(id int64) (models.APIKey, error) {
+	var key models.APIKey
+	err := scanAPIKey(s.db.QueryRow(`UPDATE api_key SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL RETURNING `+apiKeyColumns, id), &key)
+	if err != sql.ErrNoRows {
+		return key, err
+	}
+	//nothing was revoked, either there is no such key or it already was
+	if _, err := s.GetAPIKey(id); err != nil {
+		return key, err
+	}
+	return key, fmt.Errorf("%w: api key %d is already revoked", ErrConflict, id)
+}
+
+// find a live api key by hash and record its use
+func (s *PostgresStore) UseAPIKey(keyHash string) (models.APIKey, error) {
+	var key models.APIKey
+	err := scanAPIKey(s.db.QueryRow(`UPDATE api_key SET last_used_at = now() WHERE key_hash = $1 AND revoked_at IS NULL RETURNING `+apiKeyColumns, keyHash), &key)
+	if err == sql.ErrNoRows {
+		return key, fmt.Errorf("api key %w", ErrNotFound)
+	}
+	return key, err
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+
+	"github.com/lib/pq"
+)
+
+// syncAuthorColumn rewrites book.author from the author role credits, so the
+// filters, sort and search that read the column see the current names
+const syncAuthorColumn = `UPDATE book SET author = COALESCE((
+		SELECT string_agg(a.name, ', ' ORDER BY ba.position)
+		FROM book_author ba JOIN author a ON a.id = ba.author_id
+		WHERE ba.book_id = book.id AND ba.role = 'author'), '')`
+
+// saveCredits replaces the credits on a book inside tx, adding an author for
+// any name that does not match one yet, and then refreshes the book's author
+// column. Crediting the same person twice in the same role keeps the first.
+func saveCredits(tx *sql.Tx, bookID int64, credits []models.BookAuthor) error {
+	if _, err := tx.Exec(`DELETE FROM book_author WHERE book_id = $1`, bookID); err != nil {
+		return err
+	}
+	for i, c := range credits {
+		id := c.ID
+		if id == 0 {
+			if c.Name == "" {
+				return fmt.Errorf("%w: an author needs an id or a name", ErrInvalid)
+			}
+			// the no-op update makes RETURNING give the id of an existing author too
+			err := tx.QueryRow(`INSERT INTO author (name) VALUES ($1)
+				ON CONFLICT (name_key) DO UPDATE SET name = author.name RETURNING id`, c.Name).Scan(&id)
+			if err != nil {
+				return translateError(err)
+			}
+		}
+		_, err := tx.Exec(`INSERT INTO book_author (book_id, author_id, role, position) VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING`, bookID, id, c.Role, i)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	_, err := tx.Exec(syncAuthorColumn+` WHERE id = $1`, bookID)
+	return err
+}
+
+// attachAuthors fills in the Authors of every book with one query
+func (s *PostgresStore) attachAuthors(books []models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(books))
+	index := make(map[int64]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+		index[book.ID] = i
+	}
+
+	rows, err := s.db.Query(`SELECT ba.book_id, a.id, a.name, ba.role
+		FROM book_author ba JOIN author a ON a.id = ba.author_id
+		WHERE ba.book_id = ANY($1)
+		ORDER BY ba.book_id, ba.position`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int64
+		var c models.BookAuthor
+		if err := rows.Scan(&bookID, &c.ID, &c.Name, &c.Role); err != nil {
+			return err
+		}
+		book := &books[index[bookID]]
+		book.Authors = append(book.Authors, c)
+	}
+	return rows.Err()
+}
+
+// insert an author and return their id
+func (s *PostgresStore) InsertAuthor(author models.Author) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO author (name) VALUES ($1) RETURNING id`, author.Name).Scan(&id)
+	return id, translateError(err)
+}
+
+// get one author by id
+func (s *PostgresStore) GetAuthor(id int64) (models.Author, error) {
+	var author models.Author
+	err := s.db.QueryRow(`SELECT id, name FROM author WHERE id = $1`, id).Scan(&author.ID, &author.Name)
+	if err == sql.ErrNoRows {
+		return author, fmt.Errorf("author %w: id %d", ErrNotFound, id)
+	}
+	return author, err
+}
+
+// list the authors whose name contains name, ordered by name
+func (s *PostgresStore) ListAuthors(name string) ([]models.Author, error) {
+	rows, err := s.db.Query(`SELECT id, name FROM author WHERE name ILIKE $1 ORDER BY name, id`, "%"+escapeLike(name)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var authors []models.Author
+	for rows.Next() {
+		var a models.Author
+		if err := rows.Scan(&a.ID, &a.Name); err != nil {
+			return nil, err
+		}
+		authors = append(authors, a)
+	}
+	return authors, rows.Err()
+}
+
+// rename an author and refresh the author column of the books crediting them
+func (s *PostgresStore) UpdateAuthor(id int64, author models.Author) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE author SET name = $2 WHERE id = $1`, id, author.Name)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	n, err := rowsAffected(res, "author", id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(syncAuthorColumn+` WHERE id IN (SELECT book_id FROM book_author WHERE author_id = $1)`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// delete an author no book credits
+func (s *PostgresStore) DeleteAuthor(id int64) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM author WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return 0, fmt.Errorf("%w: author %d is credited on books", ErrConflict, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rowsAffected(res, "author", id)
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+
+	"github.com/lib/pq"
+)
+
+// availableCopy is true for a copy c that can be lent right now: it is not on
+// an open loan and its condition is not one of unlendable
+const availableCopy = `(c.condition NOT IN ('damaged', 'lost')
+	AND NOT EXISTS (SELECT 1 FROM loan l WHERE l.copy_id = c.id AND l.returned_at IS NULL))`
+
+// syncStatus sets the status of book $1 to whether copies of it are out and
+// none is left to lend
+const syncStatus = `UPDATE book SET status =
+	EXISTS (SELECT 1 FROM loan WHERE book_id = $1 AND returned_at IS NULL)
+	AND NOT EXISTS (SELECT 1 FROM copy c WHERE c.book_id = $1 AND ` + availableCopy + `)
+	WHERE id = $1`
+
+// copyColumns lists the copy columns, read from copy c, in the order scanCopy reads them
+const copyColumns = `c.id, c.book_id, c.barcode, c.condition, c.acquired_on, c.location, ` + availableCopy
+
+// copyFrom is what copyColumns are selected from, leaving out the copies of
+// books in the trash
+const copyFrom = ` FROM copy c JOIN book b ON b.id = c.book_id AND b.deleted_at IS NULL`
+
+// scanCopy reads one row selected with copyColumns
+func scanCopy(row scanner, item *models.Copy) error {
+	var acquired sql.NullTime
+	err := row.Scan(&item.ID, &item.BookID, &item.Barcode, &item.Condition, &acquired, &item.Location, &item.Available)
+	if err != nil {
+		return err
+	}
+	item.AcquiredOn = ""
+	if acquired.Valid {
+		item.AcquiredOn = acquired.Time.Format("2006-01-02")
+	}
+	return nil
+}
+
+// attachCopies fills in the Copies and AvailableCopies of every book with one query
+func (s *PostgresStore) attachCopies(books []models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(books))
+	index := make(map[int64]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+		index[book.ID] = i
+	}
+
+	rows, err := s.db.Query(`SELECT c.book_id, count(*), count(*) FILTER (WHERE `+availableCopy+`)
+		FROM copy c WHERE c.book_id = ANY($1) GROUP BY c.book_id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookID, total, available int64
+		if err := rows.Scan(&bookID, &total, &available); err != nil {
+			return err
+		}
+		book := &books[index[bookID]]
+		book.Copies, book.AvailableCopies = total, available
+	}
+	return rows.Err()
+}
+
+// add a copy to a book and return its id
+func (s *PostgresStore) InsertCopy(item models.Copy) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, item.BookID); err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow(`INSERT INTO copy (book_id, barcode, condition, acquired_on, location) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		item.BookID, item.Barcode, item.Condition, nullIfEmpty(item.AcquiredOn), item.Location).Scan(&id)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	if _, err := tx.Exec(syncStatus, item.BookID); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// get one copy by id
+func (s *PostgresStore) GetCopy(id int64) (models.Copy, error) {
+	var item models.Copy
+	err := scanCopy(s.db.QueryRow(`SELECT `+copyColumns+copyFrom+` WHERE c.id = $1`, id), &item)
+	if err == sql.ErrNoRows {
+		return item, fmt.Errorf("copy %w: id %d", ErrNotFound, id)
+	}
+	return item, err
+}
+
+// list the copies of a book by barcode
+func (s *PostgresStore) ListCopies(bookID int64) ([]models.Copy, error) {
+	rows, err := s.db.Query(`SELECT `+copyColumns+copyFrom+` WHERE c.book_id = $1 ORDER BY c.barcode`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var copies []models.Copy
+	for rows.Next() {
+		var item models.Copy
+		if err := scanCopy(rows, &item); err != nil {
+			return nil, err
+		}
+		copies = append(copies, item)
+	}
+	return copies, rows.Err()
+}
+
+// lockCopyBook locks the book a copy belongs to and returns the book's id
+func lockCopyBook(tx *sql.Tx, id int64) (int64, error) {
+	var bookID int64
+	err := tx.QueryRow(`SELECT book_id FROM copy WHERE id = $1`, id).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("copy %w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return bookID, lockBook(tx, bookID)
+}
+
+// change a copy's details, keeping it on the same book
+func (s *PostgresStore) UpdateCopy(id int64, item models.Copy) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := lockCopyBook(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`UPDATE copy SET barcode = $2, condition = $3, acquired_on = $4, location = $5 WHERE id = $1`,
+		id, item.Barcode, item.Condition, nullIfEmpty(item.AcquiredOn), item.Location)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	n, err := rowsAffected(res, "copy", id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(syncStatus, bookID); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// remove a copy that is not on loan; its loans keep their place in the book's
+// history with copy_id set to NULL
+func (s *PostgresStore) DeleteCopy(id int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := lockCopyBook(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	var out bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM loan WHERE copy_id = $1 AND returned_at IS NULL)`, id).Scan(&out)
+	if err != nil {
+		return 0, err
+	}
+	if out {
+		return 0, fmt.Errorf("%w: copy %d is on loan", ErrConflict, id)
+	}
+	res, err := tx.Exec(`DELETE FROM copy WHERE id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rowsAffected(res, "copy", id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(syncStatus, bookID); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
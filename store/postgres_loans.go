@@ -0,0 +1,228 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loanColumns lists the loan columns in the order scanLoan reads them; the
+// last one is worked out by the database so it agrees with the filter
+const loanColumns = `id, book_id, COALESCE(copy_id, 0), COALESCE(user_id, 0), patron, checked_out_at, due_at, returned_at, renewals,
+	returned_at IS NULL AND due_at < now()`
+
+// liveLoan leaves out the loan history of books in the trash
+const liveLoan = `book_id IN (SELECT id FROM book WHERE deleted_at IS NULL)`
+
+// scanLoan reads one row selected with loanColumns
+func scanLoan(row scanner, loan *models.Loan) error {
+	var returned sql.NullTime
+	err := row.Scan(&loan.ID, &loan.BookID, &loan.CopyID, &loan.UserID, &loan.Patron, &loan.CheckedOutAt, &loan.DueAt, &returned, &loan.Renewals, &loan.Overdue)
+	if err != nil {
+		return err
+	}
+	loan.CheckedOutAt = loan.CheckedOutAt.UTC()
+	loan.DueAt = loan.DueAt.UTC()
+	if returned.Valid {
+		t := returned.Time.UTC()
+		loan.ReturnedAt = &t
+	}
+	return nil
+}
+
+// lockBook locks a book row for the rest of tx, returning ErrNotFound if there
+// is no such book or it is in the trash. Everything that changes which copies of a book can be lent
+// takes this lock first, so checkouts of one book run one at a time and its
+// status is worked out from a settled set of copies and loans.
+func lockBook(tx *sql.Tx, id int64) error {
+	err := tx.QueryRow(`SELECT id FROM book WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("book %w: id %d", ErrNotFound, id)
+	}
+	return err
+}
+
+// lend a copy of a book to patron, and the user account userID if any, until due. A second checkout of the same
+// book waits on the book lock and then sees the first one's loan; the partial
+// unique index on open loans backs this up.
+func (s *PostgresStore) CheckOut(bookID int64, barcode, patron string, userID int64, due time.Time) (models.Loan, error) {
+	var loan models.Loan
+	tx, err := s.db.Begin()
+	if err != nil {
+		return loan, err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, bookID); err != nil {
+		return loan, err
+	}
+
+	var copyID int64
+	var available bool
+	if barcode != "" {
+		err = tx.QueryRow(`SELECT c.id, `+availableCopy+` FROM copy c WHERE c.book_id = $1 AND c.barcode = $2`, bookID, barcode).Scan(&copyID, &available)
+		if err == sql.ErrNoRows {
+			return loan, fmt.Errorf("copy %w: barcode %s of book %d", ErrNotFound, barcode, bookID)
+		}
+		if err == nil && !available {
+			return loan, fmt.Errorf("%w: copy %s is not available", ErrConflict, barcode)
+		}
+	} else {
+		err = tx.QueryRow(`SELECT c.id FROM copy c WHERE c.book_id = $1 AND `+availableCopy+` ORDER BY c.id LIMIT 1`, bookID).Scan(&copyID)
+		if err == sql.ErrNoRows {
+			return loan, fmt.Errorf("%w: book %d has no copy available", ErrConflict, bookID)
+		}
+	}
+	if err != nil {
+		return loan, err
+	}
+
+	var user sql.NullInt64
+	if userID != 0 {
+		user = sql.NullInt64{Int64: userID, Valid: true}
+	}
+	row := tx.QueryRow(`INSERT INTO loan (book_id, copy_id, user_id, patron, due_at) VALUES ($1, $2, $3, $4, $5) RETURNING `+loanColumns,
+		bookID, copyID, user, patron, due)
+	if err := scanLoan(row, &loan); err != nil {
+		return loan, translateError(err)
+	}
+	if _, err := tx.Exec(syncStatus, bookID); err != nil {
+		return loan, err
+	}
+
+	return loan, tx.Commit()
+}
+
+// lockLoan reads an open loan inside tx and locks it, returning ErrNotFound or
+// ErrConflict if it does not exist or was already returned
+func lockLoan(tx *sql.Tx, id int64) (models.Loan, error) {
+	var loan models.Loan
+	err := scanLoan(tx.QueryRow(`SELECT `+loanColumns+` FROM loan WHERE id = $1 FOR UPDATE`, id), &loan)
+	if err == sql.ErrNoRows {
+		return loan, fmt.Errorf("loan %w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return loan, err
+	}
+	if loan.ReturnedAt != nil {
+		return loan, fmt.Errorf("%w: loan %d was already returned", ErrConflict, id)
+	}
+	return loan, nil
+}
+
+// close a loan, putting its copy back on the shelf
+func (s *PostgresStore) ReturnLoan(id int64) (models.Loan, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Loan{}, err
+	}
+	defer tx.Rollback()
+
+	// lock the book before the loan, in the same order as CheckOut
+	var bookID int64
+	err = tx.QueryRow(`SELECT book_id FROM loan WHERE id = $1`, id).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return models.Loan{}, fmt.Errorf("loan %w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return models.Loan{}, err
+	}
+	if err := lockBook(tx, bookID); err != nil {
+		return models.Loan{}, err
+	}
+	loan, err := lockLoan(tx, id)
+	if err != nil {
+		return loan, err
+	}
+	row := tx.QueryRow(`UPDATE loan SET returned_at = now() WHERE id = $1 RETURNING `+loanColumns, id)
+	if err := scanLoan(row, &loan); err != nil {
+		return loan, err
+	}
+	if _, err := tx.Exec(syncStatus, bookID); err != nil {
+		return loan, err
+	}
+
+	return loan, tx.Commit()
+}
+
+// extend an open loan to due
+func (s *PostgresStore) RenewLoan(id int64, due time.Time, maxRenewals int) (models.Loan, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Loan{}, err
+	}
+	defer tx.Rollback()
+
+	loan, err := lockLoan(tx, id)
+	if err != nil {
+		return loan, err
+	}
+	if loan.Renewals >= maxRenewals {
+		return loan, fmt.Errorf("%w: loan %d has been renewed the most times allowed (%d)", ErrConflict, id, maxRenewals)
+	}
+	row := tx.QueryRow(`UPDATE loan SET due_at = GREATEST(due_at, $2), renewals = renewals + 1
+		WHERE id = $1 RETURNING `+loanColumns, id, due)
+	if err := scanLoan(row, &loan); err != nil {
+		return loan, err
+	}
+
+	return loan, tx.Commit()
+}
+
+// get one loan by id
+func (s *PostgresStore) GetLoan(id int64) (models.Loan, error) {
+	var loan models.Loan
+	err := scanLoan(s.db.QueryRow(`SELECT `+loanColumns+` FROM loan WHERE id = $1 AND `+liveLoan, id), &loan)
+	if err == sql.ErrNoRows {
+		return loan, fmt.Errorf("loan %w: id %d", ErrNotFound, id)
+	}
+	return loan, err
+}
+
+// list the loans matching f, most recent first
+func (s *PostgresStore) ListLoans(f LoanFilter) ([]models.Loan, error) {
+	conds := []string{liveLoan}
+	var args []interface{}
+	if f.BookID != nil {
+		args = append(args, *f.BookID)
+		conds = append(conds, `book_id = $`+strconv.Itoa(len(args)))
+	}
+	if f.Patron != "" {
+		args = append(args, f.Patron)
+		conds = append(conds, `patron = $`+strconv.Itoa(len(args)))
+	}
+	if f.UserID != nil {
+		args = append(args, *f.UserID)
+		conds = append(conds, `user_id = $`+strconv.Itoa(len(args)))
+	}
+	if f.Open != nil {
+		if *f.Open {
+			conds = append(conds, `returned_at IS NULL`)
+		} else {
+			conds = append(conds, `returned_at IS NOT NULL`)
+		}
+	}
+	if f.Overdue {
+		conds = append(conds, `returned_at IS NULL AND due_at < now()`)
+	}
+
+	sqlStatement := `SELECT ` + loanColumns + ` FROM loan WHERE ` + strings.Join(conds, ` AND `)
+	rows, err := s.db.Query(sqlStatement+` ORDER BY checked_out_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []models.Loan
+	for rows.Next() {
+		var loan models.Loan
+		if err := scanLoan(rows, &loan); err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+	"strings"
+)
+
+// imprintTree selects the id given as $1 and every imprint under it. UNION
+// rather than UNION ALL stops the walk should a cycle ever be stored.
+const imprintTree = `WITH RECURSIVE tree (id) AS (
+		SELECT $1::integer UNION SELECT p.id FROM publisher p JOIN tree ON p.parent_id = tree.id
+	) SELECT id FROM tree`
+
+// savePublisher links a book to its publisher inside tx, adding a publisher for
+// a name that does not match one yet, and sets the book's publisher column to
+// the publisher's name
+func savePublisher(tx *sql.Tx, bookID int64, book models.Book) error {
+	var id sql.NullInt64
+	if book.PublisherID != 0 {
+		id = sql.NullInt64{Int64: book.PublisherID, Valid: true}
+	} else if name := strings.TrimSpace(book.Publisher); name != "" {
+		// the no-op update makes RETURNING give the id of an existing publisher too
+		err := tx.QueryRow(`INSERT INTO publisher (name) VALUES ($1)
+			ON CONFLICT (name_key) DO UPDATE SET name = publisher.name RETURNING id`, name).Scan(&id)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE book SET publisher_id = $2,
+		publisher = COALESCE((SELECT name FROM publisher WHERE id = $2), '') WHERE id = $1`, bookID, id)
+	return translateError(err)
+}
+
+// scanPublisher reads the id, name and parent_id columns
+func scanPublisher(row scanner, p *models.Publisher) error {
+	var parent sql.NullInt64
+	if err := row.Scan(&p.ID, &p.Name, &parent); err != nil {
+		return err
+	}
+	if parent.Valid {
+		p.ParentID = &parent.Int64
+	}
+	return nil
+}
+
+// insert a publisher and return its id
+func (s *PostgresStore) InsertPublisher(publisher models.Publisher) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO publisher (name, parent_id) VALUES ($1, $2) RETURNING id`,
+		publisher.Name, publisher.ParentID).Scan(&id)
+	return id, translateError(err)
+}
+
+// get one publisher by id
+func (s *PostgresStore) GetPublisher(id int64) (models.Publisher, error) {
+	var publisher models.Publisher
+	row := s.db.QueryRow(`SELECT id, name, parent_id FROM publisher WHERE id = $1`, id)
+	err := scanPublisher(row, &publisher)
+	if err == sql.ErrNoRows {
+		return publisher, fmt.Errorf("publisher %w: id %d", ErrNotFound, id)
+	}
+	return publisher, err
+}
+
+// list the publishers whose name contains name, optionally only one publisher's imprints
+func (s *PostgresStore) ListPublishers(name string, parentID *int64) ([]models.Publisher, error) {
+	sqlStatement := `SELECT id, name, parent_id FROM publisher WHERE name ILIKE $1`
+	args := []interface{}{"%" + escapeLike(name) + "%"}
+	if parentID != nil {
+		sqlStatement += ` AND parent_id = $2`
+		args = append(args, *parentID)
+	}
+	rows, err := s.db.Query(sqlStatement+` ORDER BY name, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publishers []models.Publisher
+	for rows.Next() {
+		var p models.Publisher
+		if err := scanPublisher(rows, &p); err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	return publishers, rows.Err()
+}
+
+// rename or re-parent a publisher and refresh the publisher column of its books
+func (s *PostgresStore) UpdatePublisher(id int64, publisher models.Publisher) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if publisher.ParentID != nil {
+		// the lock stops two concurrent re-parents from building a cycle between them
+		if _, err := tx.Exec(`LOCK TABLE publisher IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return 0, err
+		}
+		var cycle bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM (`+imprintTree+`) t WHERE t.id = $2)`, id, *publisher.ParentID).Scan(&cycle)
+		if err != nil {
+			return 0, err
+		}
+		if cycle {
+			return 0, fmt.Errorf("%w: publisher %d cannot be an imprint of itself or of its own imprint", ErrInvalid, id)
+		}
+	}
+
+	res, err := tx.Exec(`UPDATE publisher SET name = $2, parent_id = $3 WHERE id = $1`, id, publisher.Name, publisher.ParentID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	n, err := rowsAffected(res, "publisher", id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE book SET publisher = $2 WHERE publisher_id = $1`, id, publisher.Name); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// delete a publisher with no books and no imprints
+func (s *PostgresStore) DeletePublisher(id int64) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM publisher WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return 0, fmt.Errorf("%w: publisher %d still has books or imprints", ErrConflict, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rowsAffected(res, "publisher", id)
+}
@@ -0,0 +1,283 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+	"strings"
+)
+
+// reviewColumns lists the review columns in the order scanReview reads them,
+// selected from review r joined to app_user u
+const reviewColumns = `r.id, r.book_id, r.user_id, u.username, r.rating, r.body, r.created_at, r.updated_at`
+
+// reviewFrom is the join reviewColumns are selected from; reviews of books in
+// the trash are left out
+const reviewFrom = ` FROM review r JOIN app_user u ON u.id = r.user_id
+	JOIN book b ON b.id = r.book_id AND b.deleted_at IS NULL`
+
+// bookRating is a book's rating and rating_count worked out from its reviews.
+// A book without reviews keeps showing the legacy_rating it had before them.
+const bookRating = `(SELECT COALESCE(avg(r.rating), book.legacy_rating), count(*) FROM review r WHERE r.book_id = book.id)`
+
+// syncRating works out a book's rating and rating_count from its reviews. It
+// runs after lockBook so reviews of one book written at once are counted in turn.
+const syncRating = `UPDATE book SET (rating, rating_count) = ` + bookRating + ` WHERE id = $1`
+
+// scanReview reads one row selected with reviewColumns
+func scanReview(row scanner, review *models.Review) error {
+	err := row.Scan(&review.ID, &review.BookID, &review.UserID, &review.Username, &review.Rating, &review.Text, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	review.CreatedAt = review.CreatedAt.UTC()
+	review.UpdatedAt = review.UpdatedAt.UTC()
+	return nil
+}
+
+// insert a review and return its id
+func (s *PostgresStore) InsertReview(review models.Review) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, review.BookID); err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow(`INSERT INTO review (book_id, user_id, rating, body) VALUES ($1, $2, $3, $4) RETURNING id`,
+		review.BookID, review.UserID, review.Rating, review.Text).Scan(&id)
+	if isForeignKeyViolation(err) {
+		return 0, fmt.Errorf("user %w: id %d", ErrNotFound, review.UserID)
+	}
+	if err != nil {
+		return 0, translateError(err)
+	}
+	if _, err := tx.Exec(syncRating, review.BookID); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// get one review by id
+func (s *PostgresStore) GetReview(id int64) (models.Review, error) {
+	var review models.Review
+	err := scanReview(s.db.QueryRow(`SELECT `+reviewColumns+reviewFrom+` WHERE r.id = $1`, id), &review)
+	if err == sql.ErrNoRows {
+		return review, fmt.Errorf("review %w: id %d", ErrNotFound, id)
+	}
+	return review, err
+}
+
+// list the reviews matching f, newest first
+func (s *PostgresStore) ListReviews(f ReviewFilter) ([]models.Review, error) {
+	var where []string
+	var args []interface{}
+	if f.BookID != nil {
+		args = append(args, *f.BookID)
+		where = append(where, fmt.Sprintf("r.book_id = $%d", len(args)))
+	}
+	if f.UserID != nil {
+		args = append(args, *f.UserID)
+		where = append(where, fmt.Sprintf("r.user_id = $%d", len(args)))
+	}
+	sqlStatement := `SELECT ` + reviewColumns + reviewFrom
+	if where != nil {
+		sqlStatement += ` WHERE ` + strings.Join(where, " AND ")
+	}
+
+	rows, err := s.db.Query(sqlStatement+` ORDER BY r.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Review
+	for rows.Next() {
+		var review models.Review
+		if err := scanReview(rows, &review); err != nil {
+			return nil, err
+		}
+		list = append(list, review)
+	}
+	return list, rows.Err()
+}
+
+// reviewBook returns the book a review is of, or ErrNotFound
+func reviewBook(tx *sql.Tx, id int64) (int64, error) {
+	var bookID int64
+	err := tx.QueryRow(`SELECT book_id FROM review WHERE id = $1`, id).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("review %w: id %d", ErrNotFound, id)
+	}
+	return bookID, err
+}
+
+// change a review's rating and text
+func (s *PostgresStore) UpdateReview(id int64, review models.Review) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := reviewBook(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := lockBook(tx, bookID); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`UPDATE review SET rating = $2, body = $3, updated_at = now() WHERE id = $1`, id, review.Rating, review.Text)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rowsAffected(res, "review", id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(syncRating, bookID); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// delete a review
+func (s *PostgresStore) DeleteReview(id int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	bookID, err := reviewBook(tx, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := lockBook(tx, bookID); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM review WHERE id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rowsAffected(res, "review", id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(syncRating, bookID); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// recordScale makes sc the recorded rating scale
+const recordScale = `INSERT INTO rating_scale (min, max, step) VALUES ($1, $2, $3)
+	ON CONFLICT (id) DO UPDATE SET (min, max, step, updated_at) = (excluded.min, excluded.max, excluded.step, now())`
+
+// recordedScale reads the rating scale ratings are stored on, if one is recorded
+func recordedScale(row scanner) (RatingScale, bool, error) {
+	var sc RatingScale
+	err := row.Scan(&sc.Min, &sc.Max, &sc.Step)
+	if err == sql.ErrNoRows {
+		return sc, false, nil
+	}
+	if err != nil {
+		return sc, false, err
+	}
+	return sc, true, nil
+}
+
+// record the scale ratings are stored on, or check it is the one recorded
+func (s *PostgresStore) UseRatingScale(sc RatingScale) error {
+	_, err := s.db.Exec(`INSERT INTO rating_scale (min, max, step) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING`, sc.Min, sc.Max, sc.Step)
+	if err != nil {
+		return err
+	}
+	recorded, _, err := recordedScale(s.db.QueryRow(`SELECT min, max, step FROM rating_scale`))
+	if err != nil {
+		return err
+	}
+	return sameScale(recorded, sc)
+}
+
+// convert every review and every legacy rating that is set from one rating
+// scale to another in one transaction, recording the new scale in the
+// same transaction. The conversion is done here rather than in sql so both
+// stores round ratings the same way.
+func (s *PostgresStore) RescaleRatings(from, to RatingScale) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// the scale row is locked so two rescales cannot both start from it
+	recorded, ok, err := recordedScale(tx.QueryRow(`SELECT min, max, step FROM rating_scale FOR UPDATE`))
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if err := sameScale(recorded, from); err != nil {
+			return 0, err
+		}
+	}
+
+	// keep reviews from being written while they are rescaled
+	if _, err := tx.Exec(`LOCK TABLE review IN EXCLUSIVE MODE`); err != nil {
+		return 0, err
+	}
+	n, err := rescale(tx, `SELECT id, rating FROM review`, `UPDATE review SET rating = $2 WHERE id = $1`, from, to)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := rescale(tx, `SELECT id, legacy_rating FROM book WHERE legacy_rating IS NOT NULL FOR UPDATE`,
+		`UPDATE book SET legacy_rating = $2 WHERE id = $1`, from, to); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE book SET (rating, rating_count) = ` + bookRating); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(recordScale, to.Min, to.Max, to.Step); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// rescale converts the rating of every (id, rating) row selected and writes
+// back the ones that changed, returning how many did
+func rescale(tx *sql.Tx, selectRatings, updateRating string, from, to RatingScale, args ...interface{}) (int64, error) {
+	rows, err := tx.Query(selectRatings, args...)
+	if err != nil {
+		return 0, err
+	}
+	changed := make(map[int64]float64)
+	for rows.Next() {
+		var id int64
+		var rating float64
+		if err := rows.Scan(&id, &rating); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if converted := from.Convert(rating, to); converted != rating {
+			changed[id] = converted
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, rating := range changed {
+		if _, err := tx.Exec(updateRating, id, rating); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(changed)), nil
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+
+	"github.com/lib/pq"
+)
+
+// subgenreTree selects the genre id given as $1 and every genre below it
+const subgenreTree = `WITH RECURSIVE tree (id) AS (
+		SELECT $1::integer UNION SELECT g.id FROM genre g JOIN tree ON g.parent_id = tree.id
+	) SELECT id FROM tree`
+
+// attachLinks fills in the Authors, Genres, Tags and copy counts of every book
+func (s *PostgresStore) attachLinks(books []models.Book) error {
+	if err := s.attachAuthors(books); err != nil {
+		return err
+	}
+	if err := s.attachTaxonomy(books); err != nil {
+		return err
+	}
+	return s.attachCopies(books)
+}
+
+// attachTaxonomy fills in the Genres and Tags of every book, one query each
+func (s *PostgresStore) attachTaxonomy(books []models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(books))
+	index := make(map[int64]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+		index[book.ID] = i
+	}
+
+	rows, err := s.db.Query(`SELECT bg.book_id, g.id, g.name, g.parent_id
+		FROM book_genre bg JOIN genre g ON g.id = bg.genre_id
+		WHERE bg.book_id = ANY($1)
+		ORDER BY bg.book_id, g.name`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookID int64
+		var g models.Genre
+		var parent sql.NullInt64
+		if err := rows.Scan(&bookID, &g.ID, &g.Name, &parent); err != nil {
+			return err
+		}
+		if parent.Valid {
+			g.ParentID = &parent.Int64
+		}
+		book := &books[index[bookID]]
+		book.Genres = append(book.Genres, g)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tagRows, err := s.db.Query(`SELECT book_id, tag FROM book_tag WHERE book_id = ANY($1) ORDER BY book_id, tag`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var bookID int64
+		var tag string
+		if err := tagRows.Scan(&bookID, &tag); err != nil {
+			return err
+		}
+		book := &books[index[bookID]]
+		book.Tags = append(book.Tags, tag)
+	}
+	return tagRows.Err()
+}
+
+// scanGenre reads the id, name and parent_id columns
+func scanGenre(row scanner, g *models.Genre) error {
+	var parent sql.NullInt64
+	if err := row.Scan(&g.ID, &g.Name, &parent); err != nil {
+		return err
+	}
+	if parent.Valid {
+		g.ParentID = &parent.Int64
+	}
+	return nil
+}
+
+// insert a genre and return its id
+func (s *PostgresStore) InsertGenre(genre models.Genre) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO genre (name, parent_id) VALUES ($1, $2) RETURNING id`, genre.Name, genre.ParentID).Scan(&id)
+	return id, translateError(err)
+}
+
+// get one genre by id
+func (s *PostgresStore) GetGenre(id int64) (models.Genre, error) {
+	var genre models.Genre
+	err := scanGenre(s.db.QueryRow(`SELECT id, name, parent_id FROM genre WHERE id = $1`, id), &genre)
+	if err == sql.ErrNoRows {
+		return genre, fmt.Errorf("genre %w: id %d", ErrNotFound, id)
+	}
+	return genre, err
+}
+
+// list every genre, or the direct children of parentID
+func (s *PostgresStore) ListGenres(parentID *int64) ([]models.Genre, error) {
+	sqlStatement := `SELECT id, name, parent_id FROM genre`
+	var args []interface{}
+	if parentID != nil {
+		sqlStatement += ` WHERE parent_id = $1`
+		args = append(args, *parentID)
+	}
+	rows, err := s.db.Query(sqlStatement+` ORDER BY name, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genres []models.Genre
+	for rows.Next() {
+		var g models.Genre
+		if err := scanGenre(rows, &g); err != nil {
+			return nil, err
+		}
+		genres = append(genres, g)
+	}
+	return genres, rows.Err()
+}
+
+// rename or move a genre
+func (s *PostgresStore) UpdateGenre(id int64, genre models.Genre) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if genre.ParentID != nil {
+		// the lock stops two concurrent moves from building a cycle between them
+		if _, err := tx.Exec(`LOCK TABLE genre IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return 0, err
+		}
+		var cycle bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM (`+subgenreTree+`) t WHERE t.id = $2)`, id, *genre.ParentID).Scan(&cycle)
+		if err != nil {
+			return 0, err
+		}
+		if cycle {
+			return 0, fmt.Errorf("%w: genre %d cannot be placed under itself or one of its subgenres", ErrInvalid, id)
+		}
+	}
+
+	res, err := tx.Exec(`UPDATE genre SET name = $2, parent_id = $3 WHERE id = $1`, id, genre.Name, genre.ParentID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	n, err := rowsAffected(res, "genre", id)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
+
+// delete a genre with no children; book_genre rows go with it
+func (s *PostgresStore) DeleteGenre(id int64) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM genre WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return 0, fmt.Errorf("%w: genre %d still has subgenres", ErrConflict, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rowsAffected(res, "genre", id)
+}
+
+// bookExists returns ErrNotFound unless the book is there
+func (s *PostgresStore) bookExists(id int64) error {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM book WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("book %w: id %d", ErrNotFound, id)
+	}
+	return nil
+}
+
+// put a book in a genre
+func (s *PostgresStore) AddBookGenre(bookID, genreID int64) error {
+	if err := s.bookExists(bookID); err != nil {
+		return err
+	}
+	if _, err := s.GetGenre(genreID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO book_genre (book_id, genre_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, bookID, genreID)
+	if isForeignKeyViolation(err) {
+		// the book or genre was deleted between the checks and the insert
+		return fmt.Errorf("%w: book %d or genre %d", ErrNotFound, bookID, genreID)
+	}
+	return err
+}
+
+// take a book out of a genre
+func (s *PostgresStore) RemoveBookGenre(bookID, genreID int64) error {
+	res, err := s.db.Exec(`DELETE FROM book_genre WHERE book_id = $1 AND genre_id = $2`, bookID, genreID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("book genre %w: book %d is not in genre %d", ErrNotFound, bookID, genreID)
+	}
+	return nil
+}
+
+// tag a book
+func (s *PostgresStore) AddBookTag(bookID int64, tag string) error {
+	if err := s.bookExists(bookID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO book_tag (book_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`, bookID, tag)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("book %w: id %d", ErrNotFound, bookID)
+	}
+	return translateError(err)
+}
+
+// untag a book
+func (s *PostgresStore) RemoveBookTag(bookID int64, tag string) error {
+	res, err := s.db.Exec(`DELETE FROM book_tag WHERE book_id = $1 AND tag = $2`, bookID, tag)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("book tag %w: book %d is not tagged %q", ErrNotFound, bookID, tag)
+	}
+	return nil
+}
+
+// list every tag in use with its number of books
+func (s *PostgresStore) ListTags() ([]models.Tag, error) {
+	rows, err := s.db.Query(`SELECT t.tag, count(*) FROM book_tag t JOIN book b ON b.id = t.book_id
+		WHERE b.deleted_at IS NULL GROUP BY t.tag ORDER BY t.tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.Name, &t.Books); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+	"time"
+)
+
+// userColumns lists the user columns in the order scanUser reads them
+const userColumns = `id, username, role, created_at`
+
+// scanUser reads one row selected with userColumns
+func scanUser(row scanner, user *models.User) error {
+	if err := row.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt); err != nil {
+		return err
+	}
+	user.CreatedAt = user.CreatedAt.UTC()
+	return nil
+}
+
+// insert a user and return their id
+func (s *PostgresStore) InsertUser(user models.User, passwordHash string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO app_user (username, password_hash, role) VALUES ($1, $2, $3) RETURNING id`,
+		user.Username, passwordHash, user.Role).Scan(&id)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return id, nil
+}
+
+// get one user by id
+func (s *PostgresStore) GetUser(id int64) (models.User, error) {
+	var user models.User
+	err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM app_user WHERE id = $1`, id), &user)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("user %w: id %d", ErrNotFound, id)
+	}
+	return user, err
+}
+
+// list every user by username
+func (s *PostgresStore) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM app_user ORDER BY lower(username)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.User
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		list = append(list, user)
+	}
+	return list, rows.Err()
+}
+
+// change a user's role, keeping at least one admin. The admins are locked so
+// two admins demoting each other at once cannot leave none.
+func (s *PostgresStore) SetUserRole(id int64, role string) (models.User, error) {
+	var user models.User
+	tx, err := s.db.Begin()
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	var admins int
+	err = tx.QueryRow(`SELECT count(*) FROM (SELECT 1 FROM app_user WHERE role = 'admin' FOR UPDATE) AS admin`).Scan(&admins)
+	if err != nil {
+		return user, err
+	}
+
+	err = scanUser(tx.QueryRow(`UPDATE app_user SET role = $2 WHERE id = $1 RETURNING `+userColumns, id, role), &user)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("user %w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return user, translateError(err)
+	}
+	if err := tx.QueryRow(`SELECT count(*) FROM app_user WHERE role = 'admin'`).Scan(&admins); err != nil {
+		return user, err
+	}
+	if admins == 0 {
+		return models.User{}, fmt.Errorf("%w: user %d is the last admin", ErrConflict, id)
+	}
+
+	return user, tx.Commit()
+}
+
+// get a user and their password hash by username
+func (s *PostgresStore) GetLogin(username string) (models.User, string, error) {
+	var user models.User
+	var hash string
+	err := s.db.QueryRow(`SELECT id, username, role, created_at, password_hash FROM app_user WHERE lower(username) = lower($1)`, username).
+		Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt, &hash)
+	if err == sql.ErrNoRows {
+		return user, "", fmt.Errorf("user %w: username %q", ErrNotFound, username)
+	}
+	user.CreatedAt = user.CreatedAt.UTC()
+	return user, hash, err
+}
+
+// record a refresh token
+func (s *PostgresStore) InsertRefreshToken(userID int64, tokenHash string, expires time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO refresh_token (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`, tokenHash, userID, expires)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %w: id %d", ErrNotFound, userID)
+	}
+	return translateError(err)
+}
+
+// swap a live refresh token for a new one. The old row is locked so two
+// refreshes racing with the same token cannot both succeed.
+func (s *PostgresStore) RotateRefreshToken(oldHash, newHash string, expires time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var live, revoked bool
+	err = tx.QueryRow(`SELECT user_id, expires_at > now(), revoked_at IS NOT NULL FROM refresh_token WHERE token_hash = $1 FOR UPDATE`, oldHash).
+		Scan(&userID, &live, &revoked)
+	if err == sql.ErrNoRows || (err == nil && !live) {
+		return 0, fmt.Errorf("refresh token %w", ErrNotFound)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if revoked {
+		// commit the revocation even though the refresh itself fails
+		if _, err := tx.Exec(`UPDATE refresh_token SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("refresh token %w: it was already used, every session of user %d is revoked", ErrNotFound, userID)
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_token SET revoked_at = now() WHERE token_hash = $1`, oldHash); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_token (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`, newHash, userID, expires); err != nil {
+		return 0, translateError(err)
+	}
+
+	return userID, tx.Commit()
+}
+
+// revoke one of a user's refresh tokens
+func (s *PostgresStore) RevokeRefreshToken(userID int64, tokenHash string) error {
+	res, err := s.db.Exec(`UPDATE refresh_token SET revoked_at = COALESCE(revoked_at, now()) WHERE token_hash = $1 AND user_id = $2`, tokenHash, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("refresh token %w", ErrNotFound)
+	}
+	return nil
+}
+
+// reject an access token until it expires, forgetting those that already have
+func (s *PostgresStore) RevokeAccessToken(tokenID string, expires time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM revoked_token WHERE expires_at <= now()`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO revoked_token (token_id, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`, tokenID, expires)
+	return err
+}
+
+// report whether an access token was revoked
+func (s *PostgresStore) AccessTokenRevoked(tokenID string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM revoked_token WHERE token_id = $1)`, tokenID).Scan(&revoked)
+	return revoked, err
+}
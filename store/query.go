@@ -0,0 +1,190 @@
+package store
+
+import (
+	"go-postgres/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortColumn describes how a sortable book field is stored in postgres
+type sortColumn struct {
+	column string
+	cast   string
+}
+
+// sortColumns maps the models.Book json fields a listing can be sorted by to
+// their column. Fields not stored in a book column (ISBN_10, Authors, Genres,
+// Tags, Copies, CopiesAvailable, DeletedAt) cannot be sorted by. Missing
+// isbns and publishers sort first, as they do in the memory store.
+var sortColumns = map[string]sortColumn{
+	"ID":           {"id", "bigint"},
+	"Title":        {"title", "text"},
+	"Author":       {"author", "text"},
+	"Publisher":    {"publisher", "text"},
+	"Publish_Date": {"publish_date", "date"},
+	"Rating":       {"COALESCE(rating, '-Infinity')", "double precision"},
+	"RatingCount":  {"rating_count", "bigint"},
+	"Status":       {"status", "boolean"},
+	"ISBN_13":      {"COALESCE(isbn13, '')", "text"},
+	"PublisherID":  {"COALESCE(publisher_id, 0)", "bigint"},
+}
+
+// SortFields lists the fields a listing can be sorted by, in alphabetical order
+func SortFields() []string {
+	fields := make([]string, 0, len(sortColumns))
+	for field := range sortColumns {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// SortField returns the canonical name of a sortable book field, matched
+// ignoring case, and whether there is such a field
+func SortField(name string) (string, bool) {
+	for field := range sortColumns {
+		if strings.EqualFold(field, name) {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+// Sort orders a listing by one book field. Ties are broken by ID in the same
+// direction so the order is total, which keyset pagination relies on.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Cursor marks a position in a sorted listing: the sort field value and id of
+// the book at that position
+type Cursor struct {
+	Value string
+	ID    int64
+}
+
+// CursorFor returns the cursor positioned at book when sorting by field
+func CursorFor(book models.Book, field string) Cursor {
+	return Cursor{Value: fieldValue(book, field), ID: book.ID}
+}
+
+// fieldValue renders the value of a sortable field as text postgres can cast
+// back to the column type
+func fieldValue(book models.Book, field string) string {
+	switch field {
+	case "Title":
+		return book.Title
+	case "Author":
+		return book.Author
+	case "Publisher":
+		return book.Publisher
+	case "Publish_Date":
+		if t, _, err := ParsePublishDate(book.Publish_Date); err == nil {
+			return FormatPublishDate(t, PrecisionDay)
+		}
+		return book.Publish_Date
+	case "Rating":
+		//books without a rating sort below every rating, as they do in postgres
+		if book.Rating == nil {
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(*book.Rating, 'g', -1, 64)
+	case "RatingCount":
+		return strconv.FormatInt(book.RatingCount, 10)
+	case "Status":
+		return strconv.FormatBool(book.Status)
+	case "ISBN_13":
+		return book.ISBN_13
+	case "PublisherID":
+		return strconv.FormatInt(book.PublisherID, 10)
+	default:
+		return strconv.FormatInt(book.ID, 10)
+	}
+}
+
+// compareValues orders two fieldValue results for field
+func compareValues(field, a, b string) int {
+	switch field {
+	case "ID", "RatingCount", "PublisherID":
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return compareInts(x, y)
+	case "Rating":
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case "Status":
+		x, _ := strconv.ParseBool(a)
+		y, _ := strconv.ParseBool(b)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareInts(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareCursors orders two positions under sort s, ascending or descending
+func compareCursors(s Sort, a, b Cursor) int {
+	c := compareValues(s.Field, a.Value, b.Value)
+	if c == 0 {
+		c = compareInts(a.ID, b.ID)
+	}
+	if s.Desc {
+		return -c
+	}
+	return c
+}
+
+// BookQuery describes one page of a book listing
+type BookQuery struct {
+	Filter BookFilter
+	// Sort defaults to ID ascending
+	Sort Sort
+	// Limit caps the number of books returned, 0 means no limit
+	Limit int
+	// Offset skips that many books; used for offset pagination
+	Offset int
+	// After and Before are keyset cursors: only books strictly after or
+	// before the position are returned. With Before the page is the Limit
+	// books closest to the cursor, still in sort order.
+	After  *Cursor
+	Before *Cursor
+}
+
+// reverseBooks flips books in place
+func reverseBooks(books []models.Book) {
+	for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+		books[i], books[j] = books[j], books[i]
+	}
+}
+
+// sortOrDefault returns the query sort, defaulting to ID ascending
+func (q BookQuery) sortOrDefault() Sort {
+	if q.Sort.Field == "" {
+		return Sort{Field: "ID"}
+	}
+	return q.Sort
+}
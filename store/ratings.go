@@ -0,0 +1,103 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// RatingScale is the range reviews rate books on and the precision they may use
+type RatingScale struct {
+	Min float64
+	Max float64
+	// Step is the smallest difference between two ratings counted up from Min,
+	// e.g. 1 for whole stars or 0.5 for half stars. 0 allows any value in range.
+	Step float64
+}
+
+// DefaultRatingScale is the 1-3 scale books have always been rated on
+var DefaultRatingScale = RatingScale{Min: 1, Max: 3}
+
+// stepTolerance absorbs the float error in ratings such as 0.1 + 0.2
+const stepTolerance = 1e-9
+
+// Check reports why a scale cannot be used, if it cannot
+func (sc RatingScale) Check() error {
+	if math.IsNaN(sc.Min) || math.IsNaN(sc.Max) || math.IsInf(sc.Min, 0) || math.IsInf(sc.Max, 0) {
+		return fmt.Errorf("rating scale %s: min and max must be numbers", sc)
+	}
+	if sc.Min >= sc.Max {
+		return fmt.Errorf("rating scale %s: min must be less than max", sc)
+	}
+	if sc.Step < 0 || math.IsNaN(sc.Step) || math.IsInf(sc.Step, 0) {
+		return fmt.Errorf("rating scale %s: step must be 0 or more", sc)
+	}
+	if sc.Step > 0 && !sc.onStep(sc.Max) {
+		return fmt.Errorf("rating scale %s: max must be a whole number of steps from min", sc)
+	}
+	return nil
+}
+
+// Allows reports whether rating is on the scale
+func (sc RatingScale) Allows(rating float64) bool {
+	if math.IsNaN(rating) || rating < sc.Min || rating > sc.Max {
+		return false
+	}
+	return sc.Step == 0 || sc.onStep(rating)
+}
+
+// onStep reports whether rating is a whole number of steps from Min
+func (sc RatingScale) onStep(rating float64) bool {
+	steps := (rating - sc.Min) / sc.Step
+	return math.Abs(steps-math.Round(steps)) < stepTolerance
+}
+
+// Round brings rating onto the scale, clamping it to the range and snapping
+// it to the nearest step
+func (sc RatingScale) Round(rating float64) float64 {
+	rating = math.Max(sc.Min, math.Min(sc.Max, rating))
+	if sc.Step == 0 {
+		return rating
+	}
+	return math.Min(sc.Max, sc.Min+math.Round((rating-sc.Min)/sc.Step)*sc.Step)
+}
+
+// Convert maps rating from this scale onto to, keeping its place between the
+// ends of the range, so 2 of 1-3 becomes 3 of 1-5
+func (sc RatingScale) Convert(rating float64, to RatingScale) float64 {
+	place := (rating - sc.Min) / (sc.Max - sc.Min)
+	return to.Round(to.Min + place*(to.Max-to.Min))
+}
+
+// String describes the scale in the form shown to api callers, e.g. "1-5 in steps of 0.5"
+func (sc RatingScale) String() string {
+	s := formatRating(sc.Min) + "-" + formatRating(sc.Max)
+	if sc.Step > 0 {
+		s += " in steps of " + formatRating(sc.Step)
+	}
+	return s
+}
+
+// sameScale returns ErrConflict, naming both scales, unless the ratings stored
+// on recorded may be used as sc
+func sameScale(recorded, sc RatingScale) error {
+	if recorded == sc {
+		return nil
+	}
+	return fmt.Errorf("%w: ratings are stored on the %s scale, not %s; convert them with migrate rescale-ratings", ErrConflict, recorded, sc)
+}
+
+// copyRating returns a copy of a rating that may be nil, so a book handed out
+// does not share its rating with the one stored
+func copyRating(rating *float64) *float64 {
+	if rating == nil {
+		return nil
+	}
+	r := *rating
+	return &r
+}
+
+// formatRating writes a rating without trailing zeros
+func formatRating(rating float64) string {
+	return strconv.FormatFloat(rating, 'g', -1, 64)
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"go-postgres/models"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// This file is the memory store's stand-in for postgres full-text search. It
+// understands the same websearch syntax (words, "quoted phrases", OR and
+// -excluded words) with a much cruder english stemmer, so results and ranks
+// are close to, but not identical to, what postgres returns.
+
+// searchWeights mirror the default postgres weights for the A, B and C labels
+var searchWeights = []struct {
+	field  string
+	weight float64
+}{
+	{"Title", 1.0},
+	{"Author", 0.4},
+	{"Publisher", 0.2},
+}
+
+// stopWords are dropped from documents and queries like the postgres english config does
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "the": true, "to": true, "with": true,
+}
+
+// searchTerm is a word or phrase in a query, as stems
+type searchTerm struct {
+	stems   []string
+	exclude bool
+}
+
+// searchQuery is a parsed websearch query: any one group of terms matching
+// (OR), where every term in the group must match (AND)
+type searchQuery [][]searchTerm
+
+// stem reduces a lower case word to a rough english stem
+func stem(word string) string {
+	word = strings.TrimSuffix(word, "'s")
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(word)-len(suffix) >= 3 && strings.HasSuffix(word, suffix) {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// words splits text into lower case words, keeping apostrophes inside words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// stems returns the stems of the words in text that are not stop words
+func stems(text string) []string {
+	var out []string
+	for _, w := range words(text) {
+		if !stopWords[w] {
+			out = append(out, stem(w))
+		}
+	}
+	return out
+}
+
+// parseSearch reads websearch syntax: words are ANDed, "quoted text" is a
+// phrase, a leading - excludes a word or phrase and OR separates alternatives
+func parseSearch(q string) searchQuery {
+	var query searchQuery
+	var group []searchTerm
+	for len(q) > 0 {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+		exclude := false
+		if q[0] == '-' {
+			exclude = true
+			q = q[1:]
+		}
+		var text string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.Index(q[1:], `"`)
+			if end < 0 {
+				text, q = q[1:], ""
+			} else {
+				text, q = q[1:end+1], q[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(q, unicode.IsSpace)
+			if end < 0 {
+				end = len(q)
+			}
+			text, q = q[:end], q[end:]
+			if !exclude && strings.EqualFold(text, "or") {
+				if len(group) > 0 {
+					query = append(query, group)
+					group = nil
+				}
+				continue
+			}
+		}
+		if s := stems(text); len(s) > 0 {
+			group = append(group, searchTerm{stems: s, exclude: exclude})
+		}
+	}
+	if len(group) > 0 {
+		query = append(query, group)
+	}
+	return query
+}
+
+// occurrences counts how often the term's stems appear in order in doc
+func (t searchTerm) occurrences(doc []string) int {
+	n := 0
+	for i := 0; i+len(t.stems) <= len(doc); i++ {
+		match := true
+		for j, s := range t.stems {
+			if doc[i+j] != s {
+				match = false
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+// rank scores book against the query, returning false if it does not match
+func (q searchQuery) rank(book models.Book) (float64, bool) {
+	docs := make(map[string][]string)
+	var all []string
+	for _, w := range searchWeights {
+		docs[w.field] = stems(fieldValue(book, w.field))
+		all = append(all, docs[w.field]...)
+	}
+
+	best, matched := 0.0, false
+	for _, group := range q {
+		score, ok := 0.0, true
+		for _, term := range group {
+			found := 0
+			for _, w := range searchWeights {
+				n := term.occurrences(docs[w.field])
+				found += n
+				if !term.exclude {
+					score += float64(n) * w.weight
+				}
+			}
+			// a phrase may also run across fields the way postgres concatenates them
+			if found == 0 && len(term.stems) > 1 {
+				found = term.occurrences(all)
+			}
+			if (found > 0) == term.exclude {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = true
+			if score > best {
+				best = score
+			}
+		}
+	}
+	return best / 10, matched
+}
+
+// highlight wraps every word of text whose stem appears in the query in
+// <mark></mark>, escaping the text so only the marks are HTML
+func (q searchQuery) highlight(text string) string {
+	wanted := make(map[string]bool)
+	for _, group := range q {
+		for _, term := range group {
+			if !term.exclude {
+				for _, s := range term.stems {
+					wanted[s] = true
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '\'') {
+			j++
+		}
+		word := string(runes[i:j])
+		lower := strings.ToLower(word)
+		if !stopWords[lower] && wanted[stem(lower)] {
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// searchBooks runs the memory store's full-text search over the books not in the trash
+func searchBooks(books map[int64]models.Book, text string, limit, offset int) []models.BookMatch {
+	q := parseSearch(text)
+	if len(q) == 0 {
+		return nil
+	}
+
+	var matches []models.BookMatch
+	for _, book := range books {
+		if book.DeletedAt != nil {
+			continue
+		}
+		rank, ok := q.rank(book)
+		if !ok {
+			continue
+		}
+		matches = append(matches, models.BookMatch{
+			Book: book,
+			Rank: rank,
+			Highlights: map[string]string{
+				"Title":     q.highlight(book.Title),
+				"Author":    q.highlight(book.Author),
+				"Publisher": q.highlight(book.Publisher),
+			},
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Rank != matches[j].Rank {
+			return matches[i].Rank > matches[j].Rank
+		}
+		return matches[i].Book.ID < matches[j].Book.ID
+	})
+
+	if offset >= len(matches) {
+		return nil
+	}
+	matches = matches[offset:]
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
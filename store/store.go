@@ -0,0 +1,282 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"go-postgres/models"
+	"time"
+)
+
+// BookStore is everything the handlers need to persist and read books.
+// The postgres store is what we run in production, the memory store is
+// used for local development and for running the endpoint tests offline.
+type BookStore interface {
+	// InsertBook saves a new book and returns the id it was given. Its Rating,
+	// nil for none, is kept as the legacy rating the book shows until reviewed.
+	InsertBook(book models.Book) (int64, error)
+	// InsertBooks saves every book in one transaction and returns their ids in
+	// order; if any insert fails none of the books are saved
+	InsertBooks(books []models.Book) ([]int64, error)
+	// GetBook returns the book with the given id or ErrNotFound
+	GetBook(id int64) (models.Book, error)
+	// GetBookByISBN returns the book with the given normalized ISBN-13 or ErrNotFound
+	GetBookByISBN(isbn13 string) (models.Book, error)
+	// ListBooks returns one page of the books matching q, in q's sort order,
+	// along with the total number of books that match the filter
+	ListBooks(q BookQuery) ([]models.Book, int64, error)
+	// EachBook calls fn for every book matching filter in srt order without
+	// loading them all into memory first, stopping at the first error fn returns
+	EachBook(filter BookFilter, srt Sort, fn func(models.Book) error) error
+	// SearchBooks runs a full-text search over title, author and publisher
+	// using websearch syntax and returns the best ranked matches first
+	SearchBooks(query string, limit, offset int) ([]models.BookMatch, error)
+	// UpdateBook overwrites the book with the given id and returns the rows
+	// affected, or ErrNotFound if there is no such book. Its Rating replaces
+	// the legacy rating, nil clearing it. Status and the copy counts are not
+	// written; they keep following the book's copies and loans.
+	UpdateBook(id int64, book models.Book) (int64, error)
+	// DeleteBook moves the book with the given id to the trash and returns
+	// the rows affected, or ErrNotFound if there is no such book. Books in the
+	// trash are left out of every other read until restored or purged.
+	DeleteBook(id int64) (int64, error)
+	// RestoreBook takes the book with the given id out of the trash and
+	// returns the rows affected, or ErrNotFound if it is not in the trash
+	RestoreBook(id int64) (int64, error)
+	// PurgeBooks permanently removes the books moved to the trash before
+	// cutoff, with their copies, loan history and reviews, and returns how
+	// many were removed
+	PurgeBooks(cutoff time.Time) (int64, error)
+	// Reset removes every record, books and everything else, and restarts
+	// the id sequences at 1
+	Reset() error
+	// Close releases anything the store holds open
+	Close() error
+}
+
+// AuthorStore persists the people credited on books. Books credit authors
+// through their Authors list, see BookStore.
+type AuthorStore interface {
+	// InsertAuthor saves a new author and returns the id it was given, or
+	// ErrConflict if the name matches an existing author
+	InsertAuthor(author models.Author) (int64, error)
+	// GetAuthor returns the author with the given id or ErrNotFound
+	GetAuthor(id int64) (models.Author, error)
+	// ListAuthors returns the authors whose name contains name, ignoring
+	// case, ordered by name; an empty name lists every author
+	ListAuthors(name string) ([]models.Author, error)
+	// UpdateAuthor renames the author with the given id, which also changes
+	// the Author string of every book crediting them as an author
+	UpdateAuthor(id int64, author models.Author) (int64, error)
+	// DeleteAuthor removes the author with the given id, or returns
+	// ErrConflict if any book still credits them
+	DeleteAuthor(id int64) (int64, error)
+}
+
+// PublisherStore persists publishers and their imprints. Books link to them
+// through PublisherID, see BookStore.
+type PublisherStore interface {
+	// InsertPublisher saves a new publisher and returns the id it was given,
+	// ErrConflict if the name matches an existing publisher or ErrInvalid if
+	// the parent does not exist
+	InsertPublisher(publisher models.Publisher) (int64, error)
+	// GetPublisher returns the publisher with the given id or ErrNotFound
+	GetPublisher(id int64) (models.Publisher, error)
+	// ListPublishers returns the publishers whose name contains name, ignoring
+	// case, ordered by name; parentID narrows the list to that publisher's
+	// direct imprints
+	ListPublishers(name string, parentID *int64) ([]models.Publisher, error)
+	// UpdatePublisher renames or re-parents a publisher, which also changes
+	// the Publisher of its books. ErrInvalid is returned if the new parent
+	// does not exist or is the publisher itself or one of its imprints.
+	UpdatePublisher(id int64, publisher models.Publisher) (int64, error)
+	// DeletePublisher removes the publisher with the given id, or returns
+	// ErrConflict if it still has books or imprints
+	DeletePublisher(id int64) (int64, error)
+}
+
+// TaxonomyStore persists the genre tree and the genres and tags on each book
+type TaxonomyStore interface {
+	// InsertGenre saves a new genre and returns the id it was given,
+	// ErrConflict if the name is taken or ErrInvalid if the parent does not exist
+	InsertGenre(genre models.Genre) (int64, error)
+	// GetGenre returns the genre with the given id or ErrNotFound
+	GetGenre(id int64) (models.Genre, error)
+	// ListGenres returns every genre ordered by name, or only the direct
+	// children of parentID when it is given
+	ListGenres(parentID *int64) ([]models.Genre, error)
+	// UpdateGenre renames or moves a genre. ErrInvalid is returned if the new
+	// parent does not exist or is the genre itself or one of its descendants.
+	UpdateGenre(id int64, genre models.Genre) (int64, error)
+	// DeleteGenre removes a genre and takes it off every book, or returns
+	// ErrConflict if it still has child genres
+	DeleteGenre(id int64) (int64, error)
+	// AddBookGenre puts a book in a genre; adding it twice is not an error.
+	// ErrNotFound is returned if either the book or the genre does not exist.
+	AddBookGenre(bookID, genreID int64) error
+	// RemoveBookGenre takes a book out of a genre, or returns ErrNotFound if
+	// the book is not in it
+	RemoveBookGenre(bookID, genreID int64) error
+	// AddBookTag tags a book; tagging it twice is not an error. ErrNotFound
+	// is returned if the book does not exist.
+	AddBookTag(bookID int64, tag string) error
+	// RemoveBookTag untags a book, or returns ErrNotFound if it lacks the tag
+	RemoveBookTag(bookID int64, tag string) error
+	// ListTags returns every tag in use with its number of books, by name
+	ListTags() ([]models.Tag, error)
+}
+
+// LoanStore persists the circulation of books: which copy a patron has and
+// until when. A copy is on loan to at most one patron at a time, and a book's
+// Status is true while copies of it are out and none is left to lend.
+type LoanStore interface {
+	// CheckOut lends a copy of a book to patron until due and returns the new
+	// loan, made out to the user account userID unless it is 0. The copy with
+	// the given barcode is lent, or any available copy when barcode is empty. ErrNotFound is returned if the book or barcode
+	// does not exist and ErrConflict if the copy, or every copy, is
+	// unavailable, even when several checkouts race for it.
+	CheckOut(bookID int64, barcode, patron string, userID int64, due time.Time) (models.Loan, error)
+	// ReturnLoan closes a loan, or returns ErrConflict if it was already returned
+	ReturnLoan(id int64) (models.Loan, error)
+	// RenewLoan moves an open loan's due date to due, never earlier than it
+	// was, and counts the renewal. ErrConflict is returned if the loan was
+	// returned or has already been renewed maxRenewals times.
+	RenewLoan(id int64, due time.Time, maxRenewals int) (models.Loan, error)
+	// GetLoan returns the loan with the given id or ErrNotFound
+	GetLoan(id int64) (models.Loan, error)
+	// ListLoans returns the loans matching f, most recent checkout first
+	ListLoans(f LoanFilter) ([]models.Loan, error)
+}
+
+// CopyStore persists the physical copies of each book
+type CopyStore interface {
+	// InsertCopy adds a copy to a book and returns the id it was given.
+	// ErrNotFound is returned if the book does not exist and ErrConflict if
+	// the barcode is already used.
+	InsertCopy(item models.Copy) (int64, error)
+	// GetCopy returns the copy with the given id or ErrNotFound
+	GetCopy(id int64) (models.Copy, error)
+	// ListCopies returns the copies of one book ordered by barcode
+	ListCopies(bookID int64) ([]models.Copy, error)
+	// UpdateCopy changes a copy's barcode, condition, acquisition date and
+	// location; the book it belongs to stays the same
+	UpdateCopy(id int64, item models.Copy) (int64, error)
+	// DeleteCopy removes a copy from the inventory, or returns ErrConflict if
+	// it is on loan. Its past loans stay in the book's history.
+	DeleteCopy(id int64) (int64, error)
+}
+
+// UserStore persists user accounts and the state of their sessions: the
+// refresh tokens handed out and the access tokens revoked before they expire.
+// Tokens are stored as hashes.
+type UserStore interface {
+	// InsertUser saves a new user with a hashed password and user.Role and
+	// returns the id it was given, or ErrConflict if the username is taken,
+	// ignoring case
+	InsertUser(user models.User, passwordHash string) (int64, error)
+	// GetUser returns the user with the given id or ErrNotFound
+	GetUser(id int64) (models.User, error)
+	// ListUsers returns every user ordered by username
+	ListUsers() ([]models.User, error)
+	// SetUserRole changes a user's role and returns the user. ErrConflict is
+	// returned if that would leave no admin.
+	SetUserRole(id int64, role string) (models.User, error)
+	// GetLogin returns the user with the given username, ignoring case, and
+	// their password hash, or ErrNotFound
+	GetLogin(username string) (models.User, string, error)
+	// InsertRefreshToken records a refresh token for a user until expires
+	InsertRefreshToken(userID int64, tokenHash string, expires time.Time) error
+	// RotateRefreshToken swaps a live refresh token for a new one and returns
+	// the user it belongs to. ErrNotFound is returned if the old token is
+	// unknown, expired or revoked; a revoked token being used again means it
+	// leaked, so every refresh token of its user is revoked as well.
+	RotateRefreshToken(oldHash, newHash string, expires time.Time) (int64, error)
+	// RevokeRefreshToken stops a refresh token of userID from being used again
+	RevokeRefreshToken(userID int64, tokenHash string) error
+	// RevokeAccessToken rejects the access token with the given id until it expires
+	RevokeAccessToken(tokenID string, expires time.Time) error
+	// AccessTokenRevoked reports whether the access token with the given id was revoked
+	AccessTokenRevoked(tokenID string) (bool, error)
+}
+
+// ReviewStore persists the users' reviews of books. A book's Rating and
+// RatingCount are worked out from its reviews whenever they change.
+type ReviewStore interface {
+	// InsertReview saves a review and returns the id it was given.
+	// ErrNotFound is returned if the book or user does not exist and
+	// ErrConflict if the user has already reviewed the book.
+	InsertReview(review models.Review) (int64, error)
+	// GetReview returns the review with the given id or ErrNotFound
+	GetReview(id int64) (models.Review, error)
+	// ListReviews returns the reviews matching f, newest first
+	ListReviews(f ReviewFilter) ([]models.Review, error)
+	// UpdateReview changes a review's rating and text
+	UpdateReview(id int64, review models.Review) (int64, error)
+	// DeleteReview removes the review with the given id
+	DeleteReview(id int64) (int64, error)
+	// UseRatingScale records sc as the scale ratings are stored on when none
+	// is recorded yet, and returns ErrConflict when another one is
+	UseRatingScale(sc RatingScale) error
+	// RescaleRatings converts every review's rating, and every legacy rating
+	// that is set, from one scale to another and works out each book's Rating
+	// again, returning how many reviews changed. The recorded scale becomes to;
+	// ErrConflict is returned if it was not from.
+	RescaleRatings(from, to RatingScale) (int64, error)
+}
+
+// ReviewFilter narrows ListReviews to one book or one user's reviews
+type ReviewFilter struct {
+	BookID *int64
+	UserID *int64
+}
+
+// APIKeyStore persists the API keys scripts call the API with. Keys are
+// stored as hashes.
+type APIKeyStore interface {
+	// InsertAPIKey saves a new key with the hash of its secret and returns the
+	// id it was given, or ErrConflict if the prefix is already used
+	InsertAPIKey(key models.APIKey, keyHash string) (int64, error)
+	// GetAPIKey returns the key with the given id or ErrNotFound
+	GetAPIKey(id int64) (models.APIKey, error)
+	// ListAPIKeys returns every key, revoked ones included, newest first
+	ListAPIKeys() ([]models.APIKey, error)
+	// RevokeAPIKey stops a key from being used and returns it, or returns
+	// ErrConflict if it was already revoked
+	RevokeAPIKey(id int64) (models.APIKey, error)
+	// UseAPIKey returns the live key with the given hash and records that it
+	// was used, or returns ErrNotFound if there is none
+	UseAPIKey(keyHash string) (models.APIKey, error)
+}
+
+// Store is every store the handlers use, implemented by both backends
+type Store interface {
+	BookStore
+	AuthorStore
+	PublisherStore
+	TaxonomyStore
+	LoanStore
+	CopyStore
+	UserStore
+	APIKeyStore
+	ReviewStore
+}
+
+// PoolStatser is implemented by stores backed by a database connection pool
+type PoolStatser interface {
+	Stats() sql.DBStats
+}
+
+// Open returns the store registered under name ("postgres" or "memory")
+func Open(name string) (Store, error) {
+	switch name {
+	case "postgres":
+		s, err := openPostgres()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown book store %q", name)
+	}
+}
@@ -0,0 +1,29 @@
+package store
+
+// the roles a user can have
+const (
+	RoleReader = "reader"
+	RoleStaff  = "staff"
+	RoleAdmin  = "admin"
+)
+
+// Roles lists every role, least trusted first; the same list is in the
+// app_user role check
+var Roles = []string{RoleReader, RoleStaff, RoleAdmin}
+
+// the scopes an api key can be given, one for each part of the api a script
+// may use. Users, api keys and the caller's own account have no scope, so no
+// key can reach them whatever its role.
+const (
+	ScopeBooks      = "books"
+	ScopeAuthors    = "authors"
+	ScopePublishers = "publishers"
+	ScopeGenres     = "genres"
+	ScopeCopies     = "copies"
+	ScopeLoans      = "loans"
+	ScopeReviews    = "reviews"
+	ScopeStats      = "stats"
+)
+
+// Scopes lists every api key scope; the same list is in the api_key scopes check
+var Scopes = []string{ScopeBooks, ScopeAuthors, ScopePublishers, ScopeGenres, ScopeCopies, ScopeLoans, ScopeReviews, ScopeStats}